@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"chat-app/backend/models"
+	"chat-app/backend/validate"
+
+	"github.com/gorilla/mux"
+)
+
+// apiPrefix is where the versioned REST routes live. The routes from before
+// versioning still answer as deprecated aliases.
+const apiPrefix = "/api/v1"
+
+// v1 returns the versioned route for path. The routes are registered on the
+// root router with the full path: with a PathPrefix subrouter, mux answers a
+// wrong method with 404 instead of 405.
+func v1(path string) string { return apiPrefix + path }
+
+// statusCodes picks the error code for a status when a handler doesn't name
+// a more specific one.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            models.ErrCodeBadRequest,
+	http.StatusUnauthorized:          models.ErrCodeUnauthorized,
+	http.StatusForbidden:             models.ErrCodeForbidden,
+	http.StatusNotFound:              models.ErrCodeNotFound,
+	http.StatusMethodNotAllowed:      models.ErrCodeMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: models.ErrCodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  models.ErrCodeUnsupportedMediaType,
+	http.StatusTooManyRequests:       models.ErrCodeRateLimited,
+	http.StatusInternalServerError:   models.ErrCodeInternal,
+	http.StatusServiceUnavailable:    models.ErrCodeUnavailable,
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the JSON error envelope with the code matching status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorDetails(w, status, "", message, nil)
+}
+
+// writeErrorDetails writes the JSON error envelope. An empty code is derived
+// from the status.
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]string) {
+	if code == "" {
+		code = statusCodes[status]
+		if code == "" {
+			code = models.ErrCodeInternal
+		}
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeJSON(w, status, models.ErrorResponse{Error: models.APIError{
+		Code:    code,
+		Message: message,
+		Details: details,
+	}})
+}
+
+// idParam reads a numeric id from the path variable name or, on the legacy
+// routes that take it in the query string, the query parameter. On failure
+// it writes the error response and returns false.
+func idParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	s, ok := mux.Vars(r)[name]
+	if !ok {
+		s = r.URL.Query().Get(name)
+	}
+	if s == "" {
+		writeErrorDetails(w, http.StatusBadRequest, "", "Missing "+name, map[string]string{name: "is required"})
+		return 0, false
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		writeErrorDetails(w, http.StatusBadRequest, "", "Invalid "+name, map[string]string{name: "must be a positive integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+// queryInt reads an optional positive integer query parameter into dst. On
+// failure it writes the error response and returns false.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, dst *int) bool {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		writeErrorDetails(w, http.StatusBadRequest, "", "Invalid "+name, map[string]string{name: "must be a positive integer"})
+		return false
+	}
+	*dst = n
+	return true
+}
+
+// maxJSONBodyBytes bounds every JSON request body.
+const maxJSONBodyBytes = 64 << 10
+
+// decodeJSON reads the JSON request body into v. On failure it writes the
+// error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Body exceeds %d bytes", maxJSONBodyBytes))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return false
+	}
+	return true
+}
+
+// validRequest checks v against its validation rules. When it breaks them
+// it writes a validation_failed error listing each field and returns false.
+func validRequest(w http.ResponseWriter, v interface{}) bool {
+	err := validate.Struct(v)
+	if err == nil {
+		return true
+	}
+	var errs validate.Errors
+	errors.As(err, &errs)
+	writeErrorDetails(w, http.StatusBadRequest, models.ErrCodeValidationFailed, err.Error(), errs.Map())
+	return false
+}
+
+// routeVar matches the {name} placeholders of a route template.
+var routeVar = regexp.MustCompile(`\{(\w+)\}`)
+
+// deprecated serves a pre-v1 route. Responses point at the successor route,
+// whose {placeholders} are filled from the request's path variables or query.
+func deprecated(successor string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		link := routeVar.ReplaceAllStringFunc(successor, func(m string) string {
+			name := m[1 : len(m)-1]
+			if v, ok := vars[name]; ok {
+				return v
+			}
+			return r.URL.Query().Get(name)
+		})
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+link+">; rel=\"successor-version\"")
+		h(w, r)
+	}
+}
+
+// notFoundHandler and methodNotAllowedHandler answer requests no route
+// matches with the JSON error envelope.
+var (
+	notFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "No route for "+r.URL.Path)
+	})
+	methodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "Method "+r.Method+" not allowed on "+r.URL.Path)
+	})
+)
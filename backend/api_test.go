@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+// apiError sends a request that must fail and returns its error envelope.
+func (ts *testServer) apiError(t *testing.T, method, path string, wantStatus int) models.APIError {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: %d, want %d", method, path, resp.StatusCode, wantStatus)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type %q, want application/json", method, path, ct)
+	}
+	var body models.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s %s: decode error envelope: %v", method, path, err)
+	}
+	return body.Error
+}
+
+func TestAPIV1Resources(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "GROUP", "general", alice, bob)
+
+	var lookup models.UserLookup
+	ts.do(t, "GET", "/api/v1/users?username=bob", nil, &lookup)
+	if !lookup.Exists || lookup.ID != bob {
+		t.Errorf("lookup bob = %+v, want exists with id %d", lookup, bob)
+	}
+
+	var channels []models.Channel
+	ts.do(t, "GET", fmt.Sprintf("/api/v1/users/%d/channels", alice), nil, &channels)
+	if len(channels) != 1 || channels[0].ID != channel {
+		t.Errorf("alice's channels = %+v, want [%d]", channels, channel)
+	}
+
+	carol := ts.createUser(t, "carol")
+	ts.do(t, "POST", fmt.Sprintf("/api/v1/channels/%d/members", channel), models.AddMemberRequest{UserID: carol}, nil)
+
+	var messages []models.Message
+	ts.do(t, "GET", fmt.Sprintf("/api/v1/channels/%d/messages", channel), nil, &messages)
+	if messages == nil || len(messages) != 0 {
+		t.Errorf("messages = %#v, want an empty list", messages)
+	}
+}
+
+func TestLegacyRoutesAreDeprecatedAliases(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	resp, err := ts.Client().Get(ts.URL + fmt.Sprintf("/fetch_messages?channel_id=%d", channel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("legacy /fetch_messages: %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation = %q, want true", got)
+	}
+	want := fmt.Sprintf(`</api/v1/channels/%d/messages>; rel="successor-version"`, channel)
+	if got := resp.Header.Get("Link"); got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+
+	resp, err = ts.Client().Get(ts.URL + fmt.Sprintf("/api/v1/channels/%d/messages", channel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Deprecation") != "" {
+		t.Error("v1 route marked as deprecated")
+	}
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	ts := newTestServer(t)
+
+	e := ts.apiError(t, "GET", "/api/v1/channels/abc/messages", http.StatusBadRequest)
+	if e.Code != models.ErrCodeBadRequest || e.Details["channel_id"] == "" {
+		t.Errorf("invalid id: %+v, want bad_request with a channel_id detail", e)
+	}
+	e = ts.apiError(t, "GET", "/fetch_messages", http.StatusBadRequest)
+	if e.Details["channel_id"] != "is required" {
+		t.Errorf("missing id: %+v, want channel_id is required", e)
+	}
+	e = ts.apiError(t, "POST", "/api/v1/channels", http.StatusBadRequest)
+	if e.Message == "" || e.Details["channel_type"] == "" {
+		t.Errorf("bad channel: %+v, want a message and a channel_type detail", e)
+	}
+	if e := ts.apiError(t, "GET", "/api/v1/nope", http.StatusNotFound); e.Code != models.ErrCodeNotFound {
+		t.Errorf("unknown route: code %q, want not_found", e.Code)
+	}
+	if e := ts.apiError(t, "DELETE", "/api/v1/channels", http.StatusMethodNotAllowed); e.Code != models.ErrCodeMethodNotAllowed {
+		t.Errorf("wrong method: code %q, want method_not_allowed", e.Code)
+	}
+	if e := ts.apiError(t, "GET", "/api/v1/admin/sessions", http.StatusForbidden); e.Code != models.ErrCodeForbidden {
+		t.Errorf("admin without token: code %q, want forbidden", e.Code)
+	}
+}
+
+func TestAPIWrongMethodOnLegacyRoute(t *testing.T) {
+	ts := newTestServer(t)
+	if e := ts.apiError(t, "DELETE", "/create_channel", http.StatusMethodNotAllowed); e.Code != models.ErrCodeMethodNotAllowed {
+		t.Errorf("code %q, want method_not_allowed", e.Code)
+	}
+}
+
+func TestMessageHistoryPages(t *testing.T) {
+	for name, db := range map[string]store.Store{"memory": store.NewMemory(), "sqlite": migratedSQLite(t)} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestServerWithStore(t, db)
+			alice := ts.createUser(t, "alice")
+			bob := ts.createUser(t, "bob")
+			channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+			other := ts.createChannel(t, "GROUP", "noise", alice, bob)
+			var ids []int
+			for i := 1; i <= 7; i++ {
+				msg, err := db.InsertMessage(context.Background(), channel, alice, fmt.Sprintf("m%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, msg.ID)
+				db.InsertMessage(context.Background(), other, bob, "elsewhere")
+			}
+
+			page := func(query string) []string {
+				t.Helper()
+				var msgs []models.Message
+				ts.do(t, "GET", fmt.Sprintf("/api/v1/channels/%d/messages?%s", channel, query), nil, &msgs)
+				texts := []string{}
+				for _, m := range msgs {
+					texts = append(texts, m.Content)
+				}
+				return texts
+			}
+			for query, want := range map[string]string{
+				"":                                       "m1 m2 m3 m4 m5 m6 m7",
+				"limit=3":                                "m5 m6 m7",
+				fmt.Sprintf("before=%d&limit=3", ids[4]): "m2 m3 m4",
+				fmt.Sprintf("before=%d&limit=3", ids[1]): "m1",
+				fmt.Sprintf("before=%d", ids[0]):         "",
+				fmt.Sprintf("after=%d&limit=2", ids[3]):  "m5 m6",
+				fmt.Sprintf("after=%d", ids[6]):          "",
+			} {
+				if got := strings.Join(page(query), " "); got != want {
+					t.Errorf("?%s = %q, want %q", query, got, want)
+				}
+			}
+
+			e := ts.apiError(t, "GET", fmt.Sprintf("/api/v1/channels/%d/messages?before=5&after=2", channel), http.StatusBadRequest)
+			if e.Details["after"] == "" {
+				t.Errorf("before and after: %+v, want an after detail", e)
+			}
+			e = ts.apiError(t, "GET", fmt.Sprintf("/api/v1/channels/%d/messages?limit=0", channel), http.StatusBadRequest)
+			if e.Details["limit"] != "must be a positive integer" {
+				t.Errorf("limit=0: %+v", e)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package apispec
+
+// Frame is one WebSocket frame type. Frames are JSON objects told apart by
+// their "type" property.
+type Frame struct {
+	Name        string // component name, unique across both directions
+	Type        string // value of the frame's "type" property
+	Summary     string
+	Description string
+	Payload     interface{} // a zero value of the frame's Go type
+	Example     interface{} // optional
+}
+
+// AsyncAPI is the input of an AsyncAPI document for one WebSocket endpoint.
+type AsyncAPI struct {
+	Info        Info
+	Path        string // the endpoint, e.g. /ws
+	Description string
+	Query       []Param // query parameters of the upgrade request
+	Publish     []Frame // frames the client sends
+	Subscribe   []Frame // frames the server sends
+}
+
+// Document builds the AsyncAPI 2.6 document.
+func (a AsyncAPI) Document() Object {
+	schemas := NewSchemas("#/components/schemas/")
+	messages := Object{}
+	refs := func(frames []Frame) Object {
+		var oneOf []Object
+		for _, f := range frames {
+			msg := Object{
+				"name":    f.Type,
+				"title":   f.Name,
+				"summary": f.Summary,
+				"payload": Object{"allOf": []Object{
+					schemas.For(f.Payload),
+					{"type": "object", "properties": Object{"type": Object{"const": f.Type}}},
+				}},
+			}
+			if f.Description != "" {
+				msg["description"] = f.Description
+			}
+			if f.Example != nil {
+				msg["examples"] = []Object{{"name": f.Type, "payload": f.Example}}
+			}
+			messages[f.Name] = msg
+			oneOf = append(oneOf, Object{"$ref": "#/components/messages/" + f.Name})
+		}
+		return Object{"oneOf": oneOf}
+	}
+
+	query := Object{"type": "object", "properties": Object{}}
+	var required []string
+	for _, p := range a.Query {
+		sch := Object{"type": "string"}
+		if p.Example != nil {
+			sch = schemas.For(p.Example)
+		}
+		if p.Description != "" {
+			sch["description"] = p.Description
+		}
+		query["properties"].(Object)[p.Name] = sch
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	if len(required) > 0 {
+		query["required"] = required
+	}
+
+	channel := Object{
+		"description": a.Description,
+		"bindings": Object{"ws": Object{
+			"method":         "GET",
+			"query":          query,
+			"bindingVersion": "0.1.0",
+		}},
+		"publish": Object{
+			"operationId": "sendFrame",
+			"summary":     "Frames the client sends.",
+			"message":     refs(a.Publish),
+		},
+		"subscribe": Object{
+			"operationId": "receiveFrame",
+			"summary":     "Frames the server sends.",
+			"message":     refs(a.Subscribe),
+		},
+	}
+	return Object{
+		"asyncapi":           "2.6.0",
+		"info":               info(a.Info),
+		"defaultContentType": "application/json",
+		"channels":           Object{a.Path: channel},
+		"components":         Object{"messages": messages, "schemas": schemas.Defs()},
+	}
+}
@@ -0,0 +1,158 @@
+package apispec
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Info describes the API as a whole.
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// Param is a path or query parameter.
+type Param struct {
+	Name        string
+	In          string // "path" or "query"
+	Description string
+	Required    bool        // path parameters always are
+	Example     interface{} // a value of the parameter's type; nil means string
+}
+
+// Operation is one route of the REST API.
+type Operation struct {
+	Method      string
+	Path        string // a route template, e.g. /api/v1/users/{user_id}
+	ID          string
+	Tag         string
+	Summary     string
+	Description string
+	Params      []Param
+	Security    []string // security scheme names, any of which grants access
+
+	Body     interface{} // JSON request body, a zero value of its type
+	FormFile string      // name of the multipart/form-data file field, instead of Body
+
+	Status      int         // success status, 200 if zero
+	Response    interface{} // JSON response body, a zero value of its type
+	ContentType string      // non-JSON response content type, instead of Response
+
+	Errors []int // error statuses, answered with ErrorBody
+}
+
+// OpenAPI is the input of an OpenAPI document.
+type OpenAPI struct {
+	Info            Info
+	Operations      []Operation
+	ErrorBody       interface{}       // the error envelope of every error response
+	CommonErrors    []int             // error statuses any operation may answer
+	SecuritySchemes map[string]Object // keyed by the names Operation.Security uses
+}
+
+// Document builds the OpenAPI 3.0 document.
+func (o OpenAPI) Document() Object {
+	schemas := NewSchemas("#/components/schemas/")
+	errorSchema := schemas.For(o.ErrorBody)
+
+	paths := Object{}
+	for _, op := range o.Operations {
+		item, _ := paths[op.Path].(Object)
+		if item == nil {
+			item = Object{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = o.operation(op, schemas, errorSchema)
+	}
+
+	components := Object{"schemas": schemas.Defs()}
+	if len(o.SecuritySchemes) > 0 {
+		components["securitySchemes"] = o.SecuritySchemes
+	}
+	return Object{
+		"openapi":    "3.0.3",
+		"info":       info(o.Info),
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+func (o OpenAPI) operation(op Operation, schemas *Schemas, errorSchema Object) Object {
+	out := Object{"operationId": op.ID, "summary": op.Summary}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+	if op.Tag != "" {
+		out["tags"] = []string{op.Tag}
+	}
+
+	var params []Object
+	for _, p := range op.Params {
+		sch := Object{"type": "string"}
+		if p.Example != nil {
+			sch = schemas.For(p.Example)
+		}
+		param := Object{"name": p.Name, "in": p.In, "schema": sch, "required": p.Required || p.In == "path"}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	switch {
+	case op.Body != nil:
+		out["requestBody"] = Object{"required": true, "content": Object{
+			"application/json": Object{"schema": schemas.For(op.Body)},
+		}}
+	case op.FormFile != "":
+		out["requestBody"] = Object{"required": true, "content": Object{
+			"multipart/form-data": Object{"schema": Object{
+				"type":       "object",
+				"properties": Object{op.FormFile: Object{"type": "string", "format": "binary"}},
+				"required":   []string{op.FormFile},
+			}},
+		}}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := Object{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		ok["content"] = Object{op.ContentType: Object{"schema": Object{"type": "string", "format": "binary"}}}
+	case op.Response != nil:
+		ok["content"] = Object{"application/json": Object{"schema": schemas.For(op.Response)}}
+	}
+	responses := Object{strconv.Itoa(status): ok}
+	for _, code := range append(append([]int{}, op.Errors...), o.CommonErrors...) {
+		responses[strconv.Itoa(code)] = Object{
+			"description": http.StatusText(code),
+			"content":     Object{"application/json": Object{"schema": errorSchema}},
+		}
+	}
+	out["responses"] = responses
+
+	if len(op.Security) > 0 {
+		var security []Object
+		for _, name := range op.Security {
+			security = append(security, Object{name: []string{}})
+		}
+		out["security"] = security
+	}
+	return out
+}
+
+func info(i Info) Object {
+	out := Object{"title": i.Title, "version": i.Version}
+	if i.Description != "" {
+		out["description"] = i.Description
+	}
+	return out
+}
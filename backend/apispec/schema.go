@@ -0,0 +1,200 @@
+// Package apispec builds the OpenAPI and AsyncAPI documents the server
+// publishes. Their schemas are generated from the Go types the handlers
+// encode and decode, validation rules included, so the documents can't
+// drift from the wire format; only the list of operations and frames is
+// written by hand.
+package apispec
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app/backend/validate"
+)
+
+// Object is a JSON object of a generated document.
+type Object = map[string]interface{}
+
+// Schemas generates JSON Schemas for Go values and collects the named
+// struct types among them as reusable definitions.
+//
+// A struct field maps to the property named by its json tag. In a struct
+// with validate tags the fields tagged required are required, and the other
+// rules become the matching constraints; in other structs every field
+// without omitempty is required, since the server always sends it.
+type Schemas struct {
+	refPrefix string
+	defs      map[string]Object
+	types     map[string]reflect.Type
+}
+
+// NewSchemas returns an empty set whose references point below refPrefix,
+// e.g. "#/components/schemas/".
+func NewSchemas(refPrefix string) *Schemas {
+	return &Schemas{refPrefix: refPrefix, defs: map[string]Object{}, types: map[string]reflect.Type{}}
+}
+
+// For returns the schema of v's type, e.g. models.User{} or []models.User{}.
+// Named structs are returned as references and added to the definitions.
+func (s *Schemas) For(v interface{}) Object {
+	return s.schema(reflect.TypeOf(v))
+}
+
+// Defs returns the definitions collected so far, keyed by name.
+func (s *Schemas) Defs() map[string]Object {
+	return s.defs
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *Schemas) schema(t reflect.Type) Object {
+	if t == timeType {
+		return Object{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		sch := s.schema(t.Elem())
+		if _, ok := sch["$ref"]; !ok {
+			sch["nullable"] = true
+		}
+		return sch
+	case reflect.Bool:
+		return Object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Object{"type": "integer"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return Object{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return Object{"type": "number"}
+	case reflect.String:
+		return Object{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Object{"type": "string", "format": "byte"}
+		}
+		return Object{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return Object{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Interface:
+		return Object{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	}
+	panic(fmt.Sprintf("apispec: unsupported type %s", t))
+}
+
+// ref adds t to the definitions under its exported name and references it.
+func (s *Schemas) ref(t reflect.Type) Object {
+	r, size := utf8.DecodeRuneInString(t.Name())
+	name := string(unicode.ToUpper(r)) + t.Name()[size:]
+	if prev, ok := s.types[name]; ok && prev != t {
+		panic(fmt.Sprintf("apispec: %s and %s share the schema name %s", prev, t, name))
+	} else if !ok {
+		s.types[name] = t
+		s.defs[name] = s.object(t)
+	}
+	return Object{"$ref": s.refPrefix + name}
+}
+
+func (s *Schemas) object(t reflect.Type) Object {
+	props := Object{}
+	var required []string
+	s.fields(t, hasValidateTags(t), props, &required)
+	obj := Object{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// fields adds t's properties, flattening embedded structs as encoding/json
+// does.
+func (s *Schemas) fields(t reflect.Type, validated bool, props Object, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			s.fields(sf.Type, validated, props, required)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		sch := s.schema(sf.Type)
+		isRequired := !validated && !strings.Contains(","+opts+",", ",omitempty,")
+		if tag, ok := sf.Tag.Lookup("validate"); ok {
+			isRequired = applyRules(sch, sf.Type, tag)
+		}
+		props[name] = sch
+		if isRequired {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func hasValidateTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("validate"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// namePattern is the charset=name rule as a regular expression.
+const namePattern = `^[A-Za-z0-9_.-]*$`
+
+// applyRules turns a validate tag into schema constraints and reports
+// whether the field is required. Rules after dive constrain the items.
+func applyRules(sch Object, t reflect.Type, tag string) (required bool) {
+	target, kind, dived := sch, t.Kind(), false
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "required":
+			if !dived {
+				required = true
+			} else if kind == reflect.String {
+				target["minLength"] = 1
+			}
+		case "dive":
+			items, _ := target["items"].(Object)
+			if items == nil {
+				return required
+			}
+			target, kind, dived = items, t.Elem().Kind(), true
+		case "min", "max":
+			n, _ := strconv.Atoi(arg)
+			switch kind {
+			case reflect.String:
+				target[name+"Length"] = n
+			case reflect.Slice:
+				target[name+"Items"] = n
+			default:
+				target[map[string]string{"min": "minimum", "max": "maximum"}[name]] = n
+			}
+		case "oneof":
+			target["enum"] = strings.Fields(arg)
+		case "charset":
+			if arg == "name" {
+				target["pattern"] = namePattern
+			}
+			target["description"] = "May only contain " + validate.CharsetDescription(arg) + "."
+		}
+	}
+	return required
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+	"chat-app/backend/store"
+)
+
+// allowedAttachmentTypes are the sniffed MIME types accepted for upload.
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// newStorageKey returns a random key below the channel's prefix. The
+// user-supplied filename never becomes part of the key.
+func newStorageKey(channelID int) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("channels/%d/%s", channelID, hex.EncodeToString(b))
+}
+
+// countingReader fails once more than limit bytes have been read.
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+var errTooLarge = errors.New("attachment too large")
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.n > c.limit {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// HandleUploadAttachment (POST /api/v1/channels/{channel_id}/attachments?user_id=1) stores the
+// multipart "file" field and returns its attachment descriptor. The returned id is
+// sent in the attachmentIDs of a WebSocket "message" frame to link it to a message.
+func HandleUploadAttachment(db store.Store, files storage.Storage, images *ImageProcessor, limit int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channelID, ok := idParam(w, r, "channel_id")
+		if !ok {
+			return
+		}
+		userID, ok := idParam(w, r, "user_id")
+		if !ok {
+			return
+		}
+		isMember, err := db.CheckMembership(r.Context(), channelID, userID)
+		if err != nil {
+			loggerFrom(r.Context()).Error("CheckMembership failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !isMember {
+			writeError(w, http.StatusForbidden, "Not a member of this channel")
+			return
+		}
+
+		// Leave room for the multipart framing around the file itself.
+		r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Expected multipart/form-data")
+			return
+		}
+		var part io.ReadCloser
+		var filename string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Missing file field")
+				return
+			}
+			if p.FormName() == "file" {
+				part, filename = p, path.Base(p.FileName())
+				break
+			}
+			p.Close()
+		}
+		defer part.Close()
+
+		// Sniff the type from the content rather than trusting the client.
+		head := make([]byte, 512)
+		n, err := io.ReadFull(part, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "Upload failed")
+			return
+		}
+		head = head[:n]
+		if n == 0 {
+			writeError(w, http.StatusBadRequest, "Empty file")
+			return
+		}
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+		if !allowedAttachmentTypes[contentType] {
+			writeError(w, http.StatusUnsupportedMediaType, "Unsupported file type "+contentType)
+			return
+		}
+
+		var body io.Reader = &countingReader{r: io.MultiReader(bytes.NewReader(head), part), limit: limit}
+		if strings.HasPrefix(contentType, "image/") {
+			// Images are buffered so EXIF/GPS metadata can be stripped
+			// before anything reaches storage.
+			data, err := io.ReadAll(body)
+			if err != nil {
+				uploadReadError(w, r, err, limit)
+				return
+			}
+			data, err = stripImageMetadata(contentType, data)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid image")
+				return
+			}
+			body = bytes.NewReader(data)
+		}
+
+		key := newStorageKey(channelID)
+		size, err := files.Put(r.Context(), key, body)
+		if err != nil {
+			files.Delete(r.Context(), key)
+			uploadReadError(w, r, err, limit)
+			return
+		}
+
+		if filename == "." || filename == "/" {
+			filename = "attachment"
+		}
+		att, err := db.InsertAttachment(r.Context(), models.Attachment{
+			ChannelID:   channelID,
+			UploaderID:  userID,
+			Filename:    filename,
+			ContentType: contentType,
+			SizeBytes:   size,
+			StorageKey:  key,
+		})
+		if err != nil {
+			loggerFrom(r.Context()).Error("InsertAttachment failed", "err", err)
+			files.Delete(r.Context(), key)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		images.Enqueue(att)
+
+		writeJSON(w, http.StatusCreated, att)
+	}
+}
+
+// uploadReadError reports a failure while reading or storing an upload.
+func uploadReadError(w http.ResponseWriter, r *http.Request, err error, limit int64) {
+	var maxErr *http.MaxBytesError
+	if errors.Is(err, errTooLarge) || errors.As(err, &maxErr) {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, "", fmt.Sprintf("File exceeds %d bytes", limit),
+			map[string]string{"file": fmt.Sprintf("must be at most %d bytes", limit)})
+		return
+	}
+	loggerFrom(r.Context()).Error("upload failed", "err", err)
+	writeError(w, http.StatusInternalServerError, "Storage error")
+}
+
+// HandleDownloadAttachment (GET /api/v1/attachments/{attachment_id}?user_id=1) streams an
+// attachment to members of the channel it was uploaded to.
+func HandleDownloadAttachment(db store.Store, files storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		att, ok := authorizeAttachment(w, r, db)
+		if !ok {
+			return
+		}
+		serveStoredFile(w, r, files, att.StorageKey, att.ContentType, att.Filename)
+	}
+}
+
+// HandleDownloadThumbnail (GET /api/v1/attachments/{attachment_id}/thumbnail?size=thumb|preview&user_id=1)
+// serves a rendition of an image attachment once background processing finished.
+func HandleDownloadThumbnail(db store.Store, files storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		att, ok := authorizeAttachment(w, r, db)
+		if !ok {
+			return
+		}
+		key := att.ThumbnailKey
+		switch r.URL.Query().Get("size") {
+		case "", "thumb":
+		case "preview":
+			key = att.PreviewKey
+		default:
+			writeError(w, http.StatusBadRequest, "Invalid size")
+			return
+		}
+		if key == "" {
+			writeError(w, http.StatusNotFound, "Thumbnail not ready")
+			return
+		}
+		serveStoredFile(w, r, files, key, thumbnailContentType(att.ContentType), att.Filename)
+	}
+}
+
+// authorizeAttachment loads the attachment named in the path and checks that
+// the requesting user is a member of its channel. On failure it writes the
+// error response and returns false.
+func authorizeAttachment(w http.ResponseWriter, r *http.Request, db store.Store) (models.Attachment, bool) {
+	attachmentID, ok := idParam(w, r, "attachment_id")
+	if !ok {
+		return models.Attachment{}, false
+	}
+	userID, ok := idParam(w, r, "user_id")
+	if !ok {
+		return models.Attachment{}, false
+	}
+
+	att, err := db.GetAttachment(r.Context(), attachmentID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Attachment not found")
+		return models.Attachment{}, false
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("GetAttachment failed", "attachment_id", attachmentID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return models.Attachment{}, false
+	}
+	isMember, err := db.CheckMembership(r.Context(), att.ChannelID, userID)
+	if err != nil {
+		loggerFrom(r.Context()).Error("CheckMembership failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return models.Attachment{}, false
+	}
+	if !isMember {
+		writeError(w, http.StatusForbidden, "Not a member of this channel")
+		return models.Attachment{}, false
+	}
+	return att, true
+}
+
+// serveStoredFile streams an object from storage with safe download headers.
+func serveStoredFile(w http.ResponseWriter, r *http.Request, files storage.Storage, key, contentType, filename string) {
+	f, err := files.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("storage open failed", "key", key, "err", err)
+		writeError(w, http.StatusInternalServerError, "Storage error")
+		return
+	}
+	defer f.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := io.Copy(w, f); err != nil {
+		loggerFrom(r.Context()).Warn("attachment stream interrupted", "key", key, "err", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+// uploadError uploads data and checks that it is refused with status.
+func (ts *testServer) uploadError(t *testing.T, channelID, userID int, data []byte, wantStatus int) models.APIError {
+	t.Helper()
+	resp := ts.upload(t, channelID, userID, data)
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("upload of %d bytes: %d, want %d", len(data), resp.StatusCode, wantStatus)
+	}
+	var body models.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error envelope: %v", err)
+	}
+	return body.Error
+}
+
+// noisyPNG is a PNG that doesn't compress, so its size follows w×h.
+func noisyPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Uploads.MaxBytes = 4 << 10
+	ts := newTestServerWithConfig(t, cfg, store.NewMemory())
+	alice := ts.createUser(t, "alice")
+	channel := ts.createChannel(t, "GROUP", "files", alice, ts.createUser(t, "bob"))
+
+	if resp := ts.upload(t, channel, alice, bytes.Repeat([]byte("a"), 4<<10)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload at the limit: %d, want 201", resp.StatusCode)
+	}
+	for name, data := range map[string][]byte{
+		"text":  bytes.Repeat([]byte("a"), 4<<10+1),
+		"image": noisyPNG(t, 64, 64),
+		// More than the multipart allowance on top of the limit.
+		"huge": bytes.Repeat([]byte("a"), 256<<10),
+	} {
+		apiErr := ts.uploadError(t, channel, alice, data, http.StatusRequestEntityTooLarge)
+		if apiErr.Code != "payload_too_large" || apiErr.Details["file"] != "must be at most 4096 bytes" {
+			t.Errorf("%s: %+v", name, apiErr)
+		}
+	}
+}
+
+func TestUploadSniffsType(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	channel := ts.createChannel(t, "GROUP", "files", alice, ts.createUser(t, "bob"))
+
+	// ts.upload names every file pixel.png; only the content counts.
+	for name, data := range map[string][]byte{
+		"executable": append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 64)...),
+		"html":       []byte("<!DOCTYPE html><script>alert(1)</script>"),
+		"zip":        []byte("PK\x03\x04\x14\x00\x00\x00\x08\x00"),
+		"svg":        []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+	} {
+		if apiErr := ts.uploadError(t, channel, alice, data, http.StatusUnsupportedMediaType); apiErr.Code != "unsupported_media_type" {
+			t.Errorf("%s: %+v", name, apiErr)
+		}
+	}
+
+	resp := ts.upload(t, channel, alice, []byte("just notes\n"))
+	var att models.Attachment
+	if err := json.NewDecoder(resp.Body).Decode(&att); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("plain text upload: %d, %v", resp.StatusCode, err)
+	}
+	if att.ContentType != "text/plain" {
+		t.Errorf("content type %q, want text/plain", att.ContentType)
+	}
+}
+
+func TestAttachmentDownloadNeedsMembership(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	mallory := ts.createUser(t, "mallory")
+	channel := ts.createChannel(t, "GROUP", "files", alice, ts.createUser(t, "bob"))
+
+	data := testPNG(t)
+	resp := ts.upload(t, channel, alice, data)
+	var att models.Attachment
+	if err := json.NewDecoder(resp.Body).Decode(&att); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "thumbnail", func() bool {
+		a, err := ts.db.GetAttachment(context.Background(), att.ID)
+		return err == nil && a.ThumbnailKey != ""
+	})
+
+	get, err := ts.Client().Get(fmt.Sprintf("%s/api/v1/attachments/%d?user_id=%d", ts.URL, att.ID, alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(get.Body)
+	get.Body.Close()
+	if get.StatusCode != http.StatusOK || !bytes.Equal(got, data) {
+		t.Fatalf("member download: %d, %d bytes; want 200 and the upload", get.StatusCode, len(got))
+	}
+
+	for _, path := range []string{
+		fmt.Sprintf("/api/v1/attachments/%d?user_id=%d", att.ID, mallory),
+		fmt.Sprintf("/api/v1/attachments/%d/thumbnail?user_id=%d", att.ID, mallory),
+		fmt.Sprintf("/api/v1/attachments/%d/thumbnail?size=preview&user_id=%d", att.ID, mallory),
+	} {
+		if apiErr := ts.apiError(t, "GET", path, http.StatusForbidden); apiErr.Code != "forbidden" {
+			t.Errorf("%s: %+v", path, apiErr)
+		}
+	}
+	ts.apiError(t, "GET", fmt.Sprintf("/api/v1/attachments/%d?user_id=%d", att.ID+1, alice), http.StatusNotFound)
+
+	if resp := ts.upload(t, channel, mallory, data); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("non-member upload: %d, want 403", resp.StatusCode)
+	}
+}
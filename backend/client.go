@@ -2,93 +2,295 @@ package main
 
 import (
 	"chat-app/backend/models"
+	"chat-app/backend/store"
+	"chat-app/backend/validate"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Client represents a WebSocket connection.
+const (
+	// Time allowed to write a frame to the peer.
+	writeWait = 10 * time.Second
+)
+
+// Client represents a WebSocket connection. A user with several tabs or
+// devices has one Client per connection, each with its own session id.
 type Client struct {
-    hub         *Hub
-    conn        *websocket.Conn
-    db          DBInterface
-    userID      int
-    messageType int // We'll assume we always use TextMessage
+	hub         *Hub
+	conn        *websocket.Conn
+	db          store.Store
+	id          string
+	userID      int
+	remoteAddr  string
+	userAgent   string
+	connectedAt time.Time
+	send        chan []byte
+	writerDone  chan struct{} // closed when WritePump returns
+	limits      WebSocketConfig
+	log         *slog.Logger      // tagged with conn_id, user_id and the upgrade's request_id
+	upgradeSpan trace.SpanContext // span of the /ws request, linked from every frame span
+	frames      *FrameLimiter
+	// channelTypes maps the user's channels to their type, which selects
+	// the frame budgets. Only ReadPump uses it after the connection starts.
+	channelTypes map[int]string
+	flood        floodState
+	messageType  int // We'll assume we always use TextMessage
 }
 
-// DBInterface allows us to mock DB calls if needed.
-type DBInterface interface {
-    InsertMessage(channelID, senderID int, content string) error
-    CheckMembership(channelID, userID int) (bool, error)
+// newSessionID returns a random identifier for a connection.
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // ReadPump listens for incoming WebSocket messages from the client.
 func (c *Client) ReadPump() {
-    defer func() {
-        // Optionally unsubscribe from all channels or handle cleanup.
-        c.conn.Close()
-    }()
-
-    for {
-        _, data, err := c.conn.ReadMessage()
-        if err != nil {
-            log.Println("Read error:", err)
-            break
-        }
-
-        var incoming models.WSIncoming
-        if err := json.Unmarshal(data, &incoming); err != nil {
-            log.Println("JSON parse error:", err)
-            continue
-        }
-
-        switch incoming.Type {
-        case "subscribe":
-            // Manual subscription still possible, if you want to keep that logic
-            isMember, err := c.db.CheckMembership(incoming.ChannelID, c.userID)
-            if err != nil {
-                log.Println("CheckMembership error:", err)
-                continue
-            }
-            if !isMember {
-                log.Printf("User %d is not a member of channel %d\n", c.userID, incoming.ChannelID)
-                continue
-            }
-            c.hub.subscribe <- Subscription{
-                ChannelID: incoming.ChannelID,
-                Client:    c,
-            }
-
-        case "unsubscribe":
-            c.hub.unsubscribe <- Subscription{
-                ChannelID: incoming.ChannelID,
-                Client:    c,
-            }
-
-        case "message":
-            // Insert into DB
-            err := c.db.InsertMessage(incoming.ChannelID, c.userID, incoming.Text)
-            if err != nil {
-                log.Println("InsertMessage error:", err)
-            }
-            // Broadcast to the channel
-            out := models.WSOutgoing{
-                Type:      "message",
-                ChannelID: incoming.ChannelID,
-                SenderID:  c.userID,
-                Content:   incoming.Text,
-                // CreatedAt can be retrieved from DB if needed, or set now
-            }
-            encoded, _ := json.Marshal(out)
-
-            c.hub.broadcast <- BroadcastMessage{
-                ChannelID: incoming.ChannelID,
-                Data:      encoded,
-            }
-
-        default:
-            log.Println("Unknown message type:", incoming.Type)
-        }
-    }
+	defer func() {
+		// Drop the client from its channels and update presence.
+		c.hub.Unregister(c)
+		c.conn.Close()
+	}()
+
+	ctx := context.Background()
+	// Oversized frames fail the read and close the connection.
+	c.conn.SetReadLimit(c.limits.MaxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(c.limits.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.limits.PongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.log.Debug("read error", "err", err)
+			break
+		}
+
+		var incoming models.WSIncoming
+		if err := json.Unmarshal(data, &incoming); err != nil {
+			c.log.Warn("invalid frame", "err", err)
+			wsFramesRejected.WithLabelValues(frameUnknown, "invalid").Inc()
+			c.sendError("invalid_frame", "Frame is not valid JSON", "", 0)
+			continue
+		}
+
+		// Once the server is shutting down new frames are ignored; the
+		// client has been told to reconnect.
+		if !c.hub.beginWork() {
+			c.log.Info("server shutting down, ignoring frame", "type", incoming.Type)
+			continue
+		}
+		// Invalid frames are still charged to the budgets, so they can't be
+		// used to flood either.
+		if !c.admit(incoming, time.Now()) || !c.validFrame(incoming) {
+			c.hub.endWork()
+			continue
+		}
+		frameCtx, span := tracer.Start(ctx, "ws.frame",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithLinks(trace.Link{SpanContext: c.upgradeSpan}),
+			trace.WithAttributes(
+				attribute.String("chat.frame_type", incoming.Type),
+				attribute.String("chat.conn_id", c.id),
+				attribute.Int("chat.user_id", c.userID),
+				attribute.Int("chat.channel_id", incoming.ChannelID),
+			))
+		c.handleFrame(frameCtx, incoming)
+		span.End()
+		c.hub.endWork()
+	}
+}
+
+// validFrame checks a frame against the rules on models.WSIncoming and
+// answers a broken one with an invalid_frame error listing the fields.
+func (c *Client) validFrame(incoming models.WSIncoming) bool {
+	err := validate.Struct(incoming)
+	if err == nil {
+		return true
+	}
+	var errs validate.Errors
+	errors.As(err, &errs)
+	c.log.Debug("invalid frame", "type", incoming.Type, "err", err)
+	wsFramesRejected.WithLabelValues(frameKind(incoming.Type), "invalid").Inc()
+	data, _ := json.Marshal(models.WSError{
+		Type:    "error",
+		Code:    "invalid_frame",
+		Message: err.Error(),
+		Frame:   incoming.Type,
+		Fields:  errs.Map(),
+	})
+	c.hub.Send(c, data)
+	return false
+}
+
+// handleFrame processes one frame from the client. The frame has passed
+// validation.
+func (c *Client) handleFrame(ctx context.Context, incoming models.WSIncoming) {
+	switch incoming.Type {
+	case "subscribe":
+		// Manual subscription still possible, if you want to keep that logic
+		isMember, err := c.db.CheckMembership(ctx, incoming.ChannelID, c.userID)
+		if err != nil {
+			c.log.Error("CheckMembership failed", "channel_id", incoming.ChannelID, "err", err)
+			return
+		}
+		if !isMember {
+			c.log.Warn("subscribe refused, not a member", "channel_id", incoming.ChannelID)
+			return
+		}
+		if _, known := c.channelTypes[incoming.ChannelID]; !known {
+			// Joined after connecting: learn the channel's type for the frame limits.
+			c.loadChannelTypes(ctx)
+		}
+		c.hub.Subscribe(Subscription{
+			ChannelID: incoming.ChannelID,
+			Client:    c,
+		})
+
+	case "unsubscribe":
+		c.hub.Unsubscribe(Subscription{
+			ChannelID: incoming.ChannelID,
+			Client:    c,
+		})
+
+	case "set_status":
+		c.hub.SetStatus(StatusUpdate{
+			UserID: c.userID,
+			Status: incoming.Status,
+		})
+
+	case "message":
+		// Only members may post, like they may only subscribe.
+		isMember, err := c.db.CheckMembership(ctx, incoming.ChannelID, c.userID)
+		if err != nil {
+			c.log.Error("CheckMembership failed", "channel_id", incoming.ChannelID, "err", err)
+			c.sendError(models.ErrCodeInternal, "Message not sent", incoming.Type, 0)
+			return
+		}
+		if !isMember {
+			c.log.Warn("message refused, not a member", "channel_id", incoming.ChannelID)
+			c.sendError(models.ErrCodeForbidden, "Not a member of this channel", incoming.Type, 0)
+			return
+		}
+		// Insert into DB
+		msg, err := c.db.InsertMessage(ctx, incoming.ChannelID, c.userID, incoming.Text)
+		if err != nil {
+			c.log.Error("InsertMessage failed", "channel_id", incoming.ChannelID, "err", err)
+			c.sendError(models.ErrCodeInternal, "Message not sent", incoming.Type, 0)
+			return
+		}
+		messagesTotal.Inc()
+		var attachments []models.Attachment
+		if len(incoming.AttachmentIDs) > 0 {
+			attachments, err = c.db.LinkAttachments(ctx, msg.ID, incoming.ChannelID, c.userID, incoming.AttachmentIDs)
+			if err != nil {
+				c.log.Error("LinkAttachments failed", "message_id", msg.ID, "err", err)
+				c.sendError(models.ErrCodeInternal, "Attachments not linked", incoming.Type, 0)
+				return
+			}
+		}
+		// Broadcast to the channel
+		out := models.WSOutgoing{
+			Type:        "message",
+			ID:          msg.ID,
+			ChannelID:   incoming.ChannelID,
+			SenderID:    c.userID,
+			Content:     incoming.Text,
+			CreatedAt:   msg.CreatedAt,
+			Attachments: attachments,
+		}
+		encoded, _ := json.Marshal(out)
+
+		c.hub.Broadcast(BroadcastMessage{
+			ChannelID: incoming.ChannelID,
+			SenderID:  c.userID,
+			Data:      encoded,
+			Trace:     trace.SpanContextFromContext(ctx),
+		})
+
+	case "typing":
+		// Only to channels the connection already receives, which saves a
+		// membership query on this frequent frame.
+		if !c.hub.Subscribed(c, incoming.ChannelID) {
+			c.log.Debug("typing in a channel not subscribed to", "channel_id", incoming.ChannelID)
+			return
+		}
+		encoded, _ := json.Marshal(models.WSTyping{
+			Type:      "typing",
+			ChannelID: incoming.ChannelID,
+			UserID:    c.userID,
+		})
+		c.hub.Broadcast(BroadcastMessage{
+			ChannelID:  incoming.ChannelID,
+			SkipUserID: c.userID,
+			Data:       encoded,
+			Trace:      trace.SpanContextFromContext(ctx),
+		})
+
+	default:
+		c.log.Warn("unknown frame type", "type", incoming.Type)
+	}
+}
+
+// loadChannelTypes refreshes the connection's channel-type map.
+func (c *Client) loadChannelTypes(ctx context.Context) {
+	channels, err := c.db.FetchUserChannels(ctx, c.userID)
+	if err != nil {
+		c.log.Error("FetchUserChannels failed", "err", err)
+		return
+	}
+	c.channelTypes = channelTypeMap(channels)
+}
+
+// channelTypeMap indexes channel types by channel ID.
+func channelTypeMap(channels []models.Channel) map[int]string {
+	types := make(map[int]string, len(channels))
+	for _, ch := range channels {
+		types[ch.ID] = ch.ChannelType
+	}
+	return types
+}
+
+// WritePump sends frames queued by the hub to the connection. It is the only
+// goroutine that writes to conn. When the hub closes the send channel a close
+// frame is sent and the connection shut down.
+func (c *Client) WritePump() {
+	// Ping often enough that a healthy peer's pong beats the read deadline.
+	ticker := time.NewTicker(c.limits.PongWait * 9 / 10)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.writerDone)
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteMessage(c.messageType, data); err != nil {
+				c.log.Warn("write error", "err", err)
+				writeErrors.Inc()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
 }
@@ -0,0 +1,386 @@
+// Package client is the Go SDK of the chat server: typed methods for the
+// /api/v1 REST routes and a WebSocket Session that reconnects on its own.
+//
+//	c, err := client.New("http://localhost:8080")
+//	id, err := c.CreateUser(ctx, "bot")
+//	s, err := c.Connect(ctx, id, client.SessionConfig{})
+//	defer s.Close()
+//	for msg := range s.Messages() { ... }
+//
+// Requests and responses use the types of the models package. A failed
+// request returns an *Error carrying the server's error envelope.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/backend/models"
+)
+
+// Client calls one chat server. It is safe for concurrent use.
+type Client struct {
+	base       *url.URL
+	http       *http.Client
+	adminToken string
+	userAgent  string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests, WebSocket handshakes excepted, through hc.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithAdminToken sets the bearer token the admin methods send, the
+// server's ADMIN_TOKEN.
+func WithAdminToken(token string) Option {
+	return func(c *Client) { c.adminToken = token }
+}
+
+// WithUserAgent names the program in the User-Agent header, which also
+// shows up in the server's session list.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL %q must be http or https", baseURL)
+	}
+	c := &Client{base: u, http: &http.Client{Timeout: 30 * time.Second}, userAgent: "chat-go-client"}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Error is a failed request: the HTTP status and the server's error envelope.
+type Error struct {
+	StatusCode int
+	models.APIError
+	RetryAfter time.Duration // from the Retry-After header of a 429
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("chat API: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Users
+
+// CreateUser creates a user and returns its id.
+func (c *Client) CreateUser(ctx context.Context, username string) (int, error) {
+	var resp models.CreateUserResponse
+	err := c.do(ctx, "POST", "/api/v1/users", nil, models.CreateUserRequest{Username: username}, &resp)
+	return resp.UserID, err
+}
+
+// LookupUser finds a user by name.
+func (c *Client) LookupUser(ctx context.Context, username string) (models.UserLookup, error) {
+	var resp models.UserLookup
+	err := c.do(ctx, "GET", "/api/v1/users", url.Values{"username": {username}}, nil, &resp)
+	return resp, err
+}
+
+// UserChannels lists the channels a user belongs to.
+func (c *Client) UserChannels(ctx context.Context, userID int) ([]models.Channel, error) {
+	var resp []models.Channel
+	err := c.do(ctx, "GET", fmt.Sprintf("/api/v1/users/%d/channels", userID), nil, nil, &resp)
+	return resp, err
+}
+
+// Presence returns the presence of each user.
+func (c *Client) Presence(ctx context.Context, userIDs ...int) ([]models.Presence, error) {
+	var resp []models.Presence
+	err := c.do(ctx, "GET", "/api/v1/presence", url.Values{"user_ids": {joinIDs(userIDs)}}, nil, &resp)
+	return resp, err
+}
+
+// Channels and messages
+
+// CreateChannel creates a DIRECT or GROUP channel and returns its id.
+func (c *Client) CreateChannel(ctx context.Context, req models.CreateChannelRequest) (int, error) {
+	var resp models.CreateChannelResponse
+	err := c.do(ctx, "POST", "/api/v1/channels", nil, req, &resp)
+	return resp.ChannelID, err
+}
+
+// AddMember adds a user to a channel.
+func (c *Client) AddMember(ctx context.Context, channelID, userID int) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/api/v1/channels/%d/members", channelID), nil,
+		models.AddMemberRequest{UserID: userID}, nil)
+}
+
+// Messages lists the whole history of a channel, oldest first.
+func (c *Client) Messages(ctx context.Context, channelID int) ([]models.Message, error) {
+	var resp []models.Message
+	err := c.do(ctx, "GET", fmt.Sprintf("/api/v1/channels/%d/messages", channelID), nil, nil, &resp)
+	return resp, err
+}
+
+// MessagesPage lists one page of a channel's history, oldest first. A zero
+// Limit leaves the server's default of 50; a page shorter than the limit is
+// the last one in its direction.
+func (c *Client) MessagesPage(ctx context.Context, channelID int, page models.MessagePage) ([]models.Message, error) {
+	query := url.Values{}
+	for name, v := range map[string]int{"before": page.BeforeID, "after": page.AfterID, "limit": page.Limit} {
+		if v > 0 {
+			query.Set(name, strconv.Itoa(v))
+		}
+	}
+	if len(query) == 0 {
+		// Without parameters the server returns everything.
+		query.Set("before", strconv.Itoa(math.MaxInt32))
+	}
+	var resp []models.Message
+	err := c.do(ctx, "GET", fmt.Sprintf("/api/v1/channels/%d/messages", channelID), query, nil, &resp)
+	return resp, err
+}
+
+// Search searches the messages in the user's channels. q takes free text
+// plus the from:, in:, before:, after: and has:link filters. A zero limit
+// leaves the server's default.
+func (c *Client) Search(ctx context.Context, userID int, q string, limit, offset int) (models.SearchPage, error) {
+	query := url.Values{"user_id": {strconv.Itoa(userID)}, "q": {q}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	var resp models.SearchPage
+	err := c.do(ctx, "GET", "/api/v1/search", query, nil, &resp)
+	return resp, err
+}
+
+// Attachments
+
+// UploadAttachment stores a file for the members of a channel. Link it to a
+// message by passing its id to Session.Send.
+func (c *Client) UploadAttachment(ctx context.Context, channelID, userID int, filename string, r io.Reader) (models.Attachment, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		fw, err := mw.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(fw, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	u := c.url(fmt.Sprintf("/api/v1/channels/%d/attachments", channelID), url.Values{"user_id": {strconv.Itoa(userID)}})
+	req, err := http.NewRequestWithContext(ctx, "POST", u, pr)
+	if err != nil {
+		pr.Close()
+		return models.Attachment{}, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var att models.Attachment
+	err = c.send(req, &att)
+	pr.Close()
+	return att, err
+}
+
+// DownloadAttachment opens an attachment. The caller closes the returned
+// body; contentType is the type the server sniffed on upload.
+func (c *Client) DownloadAttachment(ctx context.Context, attachmentID, userID int) (body io.ReadCloser, contentType string, err error) {
+	return c.download(ctx, fmt.Sprintf("/api/v1/attachments/%d", attachmentID), url.Values{"user_id": {strconv.Itoa(userID)}})
+}
+
+// DownloadThumbnail opens the "thumb" (200px) or "preview" (800px) rendition
+// of an image attachment. It fails with a 404 until processing finished.
+func (c *Client) DownloadThumbnail(ctx context.Context, attachmentID, userID int, size string) (body io.ReadCloser, contentType string, err error) {
+	query := url.Values{"user_id": {strconv.Itoa(userID)}}
+	if size != "" {
+		query.Set("size", size)
+	}
+	return c.download(ctx, fmt.Sprintf("/api/v1/attachments/%d/thumbnail", attachmentID), query)
+}
+
+func (c *Client) download(ctx context.Context, path string, query url.Values) (io.ReadCloser, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(path, query), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// Admin, authenticated with WithAdminToken
+
+// AllSessions lists every live connection on the server.
+func (c *Client) AllSessions(ctx context.Context) ([]models.Session, error) {
+	var resp []models.Session
+	err := c.do(ctx, "GET", "/api/v1/admin/sessions", nil, nil, &resp)
+	return resp, err
+}
+
+// UserSessions lists a user's live connections.
+func (c *Client) UserSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	var resp []models.Session
+	err := c.do(ctx, "GET", fmt.Sprintf("/api/v1/admin/users/%d/sessions", userID), nil, nil, &resp)
+	return resp, err
+}
+
+// Disconnect force-closes one session of a user, or all of them when
+// sessionID is empty, and returns how many were closed.
+func (c *Client) Disconnect(ctx context.Context, userID int, sessionID string) (int, error) {
+	path := fmt.Sprintf("/api/v1/admin/users/%d/sessions", userID)
+	if sessionID != "" {
+		path += "/" + url.PathEscape(sessionID)
+	}
+	var resp models.DisconnectResponse
+	err := c.do(ctx, "DELETE", path, nil, nil, &resp)
+	return resp.Disconnected, err
+}
+
+// OriginViolations counts the requests the server refused because of their
+// origin.
+func (c *Client) OriginViolations(ctx context.Context) (models.OriginViolations, error) {
+	var resp models.OriginViolations
+	err := c.do(ctx, "GET", "/api/v1/admin/origin_violations", nil, nil, &resp)
+	return resp, err
+}
+
+// Health
+
+// Live runs the server's liveness checks. A failing report comes back with
+// an error.
+func (c *Client) Live(ctx context.Context) (models.HealthReport, error) {
+	return c.health(ctx, "/livez")
+}
+
+// Ready runs the server's readiness checks. A failing report comes back
+// with an error.
+func (c *Client) Ready(ctx context.Context) (models.HealthReport, error) {
+	return c.health(ctx, "/readyz")
+}
+
+func (c *Client) health(ctx context.Context, path string) (models.HealthReport, error) {
+	var report models.HealthReport
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(path, nil), nil)
+	if err != nil {
+		return report, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return report, fmt.Errorf("chat API: GET %s: %s", path, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return report, fmt.Errorf("chat API: GET %s: %s", path, resp.Status)
+	}
+	return report, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("chat API: %s %s: decode response: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// roundTrip sends req and turns a non-2xx response into an *Error.
+func (c *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if c.adminToken != "" && strings.HasPrefix(req.URL.Path, "/api/v1/admin/") {
+		req.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	var envelope models.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err == nil && envelope.Error.Code != "" {
+		apiErr.APIError = envelope.Error
+	} else {
+		apiErr.Code, apiErr.Message = "http_error", resp.Status
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return nil, apiErr
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := *c.base
+	u.Path = strings.TrimRight(c.base.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
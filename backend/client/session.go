@@ -0,0 +1,622 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat-app/backend/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// SessionConfig tunes a Session. The zero value is ready to use.
+type SessionConfig struct {
+	// Buffer is the capacity of each event channel, 256 if zero. Events of a
+	// kind whose channel is full are dropped and counted by Dropped, so an
+	// unread channel never stalls the others.
+	Buffer int
+	// MinBackoff and MaxBackoff bound the randomised delay between reconnect
+	// attempts, 500ms and 30s if zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// NoReconnect ends the session at the first disconnect.
+	NoReconnect bool
+	// NoResume skips fetching the messages missed while disconnected.
+	NoResume bool
+	// Header is sent with every handshake, e.g. an Origin.
+	Header http.Header
+}
+
+// State is the connection state of a Session.
+type State int
+
+const (
+	Connected State = iota
+	Reconnecting
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ErrNotConnected is returned by the send methods while a Session is
+// reconnecting or closed.
+var ErrNotConnected = errors.New("client: not connected")
+
+// Session is a user's WebSocket connection. The server subscribes it to all
+// of the user's channels when it connects.
+//
+// When the connection drops, or the server announces a shutdown, the
+// session reconnects with backoff and resumes: it repeats the Subscribe and
+// Unsubscribe calls made so far, then delivers the messages sent in the
+// meantime, so Messages sees every message once. Messages sent at the same
+// time by different users may arrive out of id order.
+//
+// Events arrive on typed channels, which are closed when the session ends.
+type Session struct {
+	c      *Client
+	userID int
+	cfg    SessionConfig
+	dialer websocket.Dialer
+
+	writeMu sync.Mutex // one writer at a time, as gorilla/websocket requires
+
+	mu   sync.Mutex
+	conn *websocket.Conn  // nil while reconnecting
+	subs map[int]bool     // channel -> subscribed, from Subscribe and Unsubscribe
+	seen map[int]*seenIDs // channel -> message ids delivered
+	err  error
+
+	messages    chan models.WSOutgoing
+	presence    chan models.WSPresenceChanged
+	typing      chan models.WSTyping
+	attachments chan models.WSAttachmentProcessed
+	errs        chan models.WSError
+	states      chan State
+	dropped     atomic.Int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Connect opens a session for userID. It fails if the first connection
+// can't be made; later ones are retried until Close.
+func (c *Client) Connect(ctx context.Context, userID int, cfg SessionConfig) (*Session, error) {
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = 256
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(30*time.Second, cfg.MinBackoff)
+	}
+	s := &Session{
+		c:           c,
+		userID:      userID,
+		cfg:         cfg,
+		dialer:      websocket.Dialer{HandshakeTimeout: 10 * time.Second, Proxy: http.ProxyFromEnvironment},
+		subs:        map[int]bool{},
+		seen:        map[int]*seenIDs{},
+		messages:    make(chan models.WSOutgoing, cfg.Buffer),
+		presence:    make(chan models.WSPresenceChanged, cfg.Buffer),
+		typing:      make(chan models.WSTyping, cfg.Buffer),
+		attachments: make(chan models.WSAttachmentProcessed, cfg.Buffer),
+		errs:        make(chan models.WSError, cfg.Buffer),
+		states:      make(chan State, 16),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	// Mark the history before dialling: a message sent in between is then
+	// newer than the mark, and either catchUp or the socket delivers it.
+	var channels []int
+	if !cfg.NoResume {
+		var err error
+		if channels, err = s.markHistory(ctx); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range channels {
+		if err := s.catchUp(ctx, id); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	s.setConn(conn)
+	go s.run(conn)
+	return s, nil
+}
+
+// Messages delivers chat messages of the subscribed channels.
+func (s *Session) Messages() <-chan models.WSOutgoing { return s.messages }
+
+// Presence delivers presence changes of users sharing a channel.
+func (s *Session) Presence() <-chan models.WSPresenceChanged { return s.presence }
+
+// Typing delivers typing notices of the subscribed channels.
+func (s *Session) Typing() <-chan models.WSTyping { return s.typing }
+
+// Attachments delivers image attachments whose renditions are ready.
+func (s *Session) Attachments() <-chan models.WSAttachmentProcessed { return s.attachments }
+
+// Errors delivers the server's refusals of frames this session sent.
+func (s *Session) Errors() <-chan models.WSError { return s.errs }
+
+// States delivers connection state changes: Connected, then Reconnecting
+// and Connected again after each drop, and Closed last.
+func (s *Session) States() <-chan State { return s.states }
+
+// Dropped counts the events dropped because their channel was full.
+func (s *Session) Dropped() int64 { return s.dropped.Load() }
+
+// Done is closed when the session has ended; Err then tells why.
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+// Err is nil while the session runs and after Close. Otherwise it is the
+// error that ended it.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// UserID is the user the session connected as.
+func (s *Session) UserID() int { return s.userID }
+
+// Subscribe starts receiving a channel's messages, e.g. one the user joined
+// after connecting. It is repeated after every reconnect.
+func (s *Session) Subscribe(channelID int) error {
+	s.mu.Lock()
+	s.subs[channelID] = true
+	s.mu.Unlock()
+	return s.write(models.WSIncoming{Type: "subscribe", ChannelID: channelID})
+}
+
+// Unsubscribe stops receiving a channel's messages, also after reconnects.
+func (s *Session) Unsubscribe(channelID int) error {
+	s.mu.Lock()
+	s.subs[channelID] = false
+	s.mu.Unlock()
+	return s.write(models.WSIncoming{Type: "unsubscribe", ChannelID: channelID})
+}
+
+// Send posts a message to a channel, optionally linking uploaded
+// attachments. The server echoes it on Messages.
+func (s *Session) Send(channelID int, text string, attachmentIDs ...int) error {
+	return s.write(models.WSIncoming{Type: "message", ChannelID: channelID, Text: text, AttachmentIDs: attachmentIDs})
+}
+
+// SetStatus sets the user's presence to online, away or dnd.
+func (s *Session) SetStatus(status string) error {
+	return s.write(models.WSIncoming{Type: "set_status", Status: status})
+}
+
+// SendTyping tells a channel's other members that the user is typing.
+func (s *Session) SendTyping(channelID int) error {
+	return s.write(models.WSIncoming{Type: "typing", ChannelID: channelID})
+}
+
+// Close ends the session and waits for it to stop.
+func (s *Session) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		if conn := s.currentConn(); conn != nil {
+			s.writeMu.Lock()
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			s.writeMu.Unlock()
+			conn.Close()
+		}
+	})
+	<-s.done
+	return nil
+}
+
+func (s *Session) write(frame models.WSIncoming) error {
+	conn := s.currentConn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.WriteJSON(frame); err != nil {
+		return fmt.Errorf("client: send %s: %w", frame.Type, err)
+	}
+	return nil
+}
+
+func (s *Session) currentConn() *websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+func (s *Session) setConn(conn *websocket.Conn) {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	if conn != nil {
+		deliver(s, s.states, Connected)
+	}
+}
+
+func (s *Session) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// errPermanent marks handshake refusals that retrying won't fix.
+var errPermanent = errors.New("client: connection refused")
+
+func (s *Session) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := *s.c.base
+	u.Scheme = map[string]string{"http": "ws", "https": "wss"}[u.Scheme]
+	u.Path += "/ws"
+	u.RawQuery = "user_id=" + strconv.Itoa(s.userID)
+
+	header := http.Header{"User-Agent": {s.c.userAgent}}
+	for k, v := range s.cfg.Header {
+		header[k] = v
+	}
+	conn, resp, err := s.dialer.DialContext(ctx, u.String(), header)
+	if err == nil {
+		return conn, nil
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope models.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil {
+			apiErr.APIError = envelope.Error
+		}
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, fmt.Errorf("%w: %w", errPermanent, apiErr)
+		}
+		return nil, apiErr
+	}
+	return nil, fmt.Errorf("client: connect: %w", err)
+}
+
+// run reads the connection and replaces it whenever it fails, until Close
+// or a permanent error.
+func (s *Session) run(conn *websocket.Conn) {
+	defer s.finish()
+	for {
+		wait := s.read(conn)
+		conn.Close()
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		if s.stopped() {
+			return
+		}
+		if s.cfg.NoReconnect {
+			s.fail(errors.New("client: connection lost"))
+			return
+		}
+		deliver(s, s.states, Reconnecting)
+		if conn = s.reconnect(wait); conn == nil {
+			return
+		}
+	}
+}
+
+// read dispatches frames until the connection fails. It returns how long
+// the server asked to wait before reconnecting, if it announced a shutdown.
+func (s *Session) read(conn *websocket.Conn) (wait time.Duration) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return wait
+		}
+		var head struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(data, &head) != nil {
+			continue
+		}
+		switch head.Type {
+		case "message":
+			var m models.WSOutgoing
+			if json.Unmarshal(data, &m) == nil && s.fresh(m.ChannelID, m.ID) {
+				deliver(s, s.messages, m)
+			}
+		case "presence_changed":
+			decodeAndDeliver(s, s.presence, data)
+		case "typing":
+			decodeAndDeliver(s, s.typing, data)
+		case "attachment_processed":
+			decodeAndDeliver(s, s.attachments, data)
+		case "error":
+			decodeAndDeliver(s, s.errs, data)
+		case "server_shutdown":
+			var notice models.WSServerShutdown
+			if json.Unmarshal(data, &notice) == nil {
+				wait = time.Duration(notice.ReconnectAfterMs) * time.Millisecond
+				if notice.JitterMs > 0 {
+					wait += time.Duration(rand.Int63n(notice.JitterMs)) * time.Millisecond
+				}
+			}
+		}
+	}
+}
+
+func decodeAndDeliver[T any](s *Session, ch chan T, data []byte) {
+	var v T
+	if json.Unmarshal(data, &v) == nil {
+		deliver(s, ch, v)
+	}
+}
+
+func deliver[T any](s *Session, ch chan T, v T) {
+	select {
+	case ch <- v:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// seenLimit bounds the ids remembered per channel. Older ones are folded
+// into the floor.
+const seenLimit = 1024
+
+// seenIDs is what a session has delivered of one channel: every id up to
+// floor, and the ids above it. Live messages may arrive out of id order, as
+// each sender's message is stored and broadcast separately, so a single
+// newest id isn't enough to tell a duplicate.
+type seenIDs struct {
+	floor int
+	ids   map[int]bool
+}
+
+// fresh records a message id and reports whether it wasn't delivered yet,
+// which filters the overlap between resumed and live messages.
+func (s *Session) fresh(channelID, id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 {
+		return true
+	}
+	seen := s.seenLocked(channelID)
+	if id <= seen.floor || seen.ids[id] {
+		return false
+	}
+	seen.ids[id] = true
+	if len(seen.ids) > seenLimit {
+		// Forget the older half; a message that late is taken as delivered.
+		ids := make([]int, 0, len(seen.ids))
+		for id := range seen.ids {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		for _, id := range ids[:len(ids)/2] {
+			delete(seen.ids, id)
+		}
+		seen.floor = ids[len(ids)/2-1]
+	}
+	return true
+}
+
+// markSeen takes every id up to id as delivered.
+func (s *Session) markSeen(channelID, id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := s.seenLocked(channelID)
+	seen.floor = max(seen.floor, id)
+	for id := range seen.ids {
+		if id <= seen.floor {
+			delete(seen.ids, id)
+		}
+	}
+}
+
+// resumeAfter is the id a catch-up of the channel starts after: the floor,
+// below which nothing can be missing.
+func (s *Session) resumeAfter(channelID int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seenLocked(channelID).floor
+}
+
+func (s *Session) seenLocked(channelID int) *seenIDs {
+	seen := s.seen[channelID]
+	if seen == nil {
+		seen = &seenIDs{ids: map[int]bool{}}
+		s.seen[channelID] = seen
+	}
+	return seen
+}
+
+// reconnect dials with backoff, starting after wait if the server asked for
+// it, and resumes the session. It returns nil once the session is over.
+func (s *Session) reconnect(wait time.Duration) *websocket.Conn {
+	backoff := s.cfg.MinBackoff
+	if wait <= 0 {
+		wait = jitter(backoff)
+	}
+	for {
+		select {
+		case <-s.stop:
+			return nil
+		case <-time.After(wait):
+		}
+
+		ctx, cancel := s.stopContext()
+		conn, err := s.dial(ctx)
+		if err == nil {
+			err = s.resume(ctx, conn)
+			if err != nil {
+				conn.Close()
+			}
+		}
+		cancel()
+		if err == nil {
+			s.setConn(conn)
+			return conn
+		}
+		if errors.Is(err, errPermanent) {
+			s.fail(err)
+			return nil
+		}
+		backoff = min(2*backoff, s.cfg.MaxBackoff)
+		wait = jitter(backoff)
+	}
+}
+
+// resume repeats the subscription changes on a new connection and delivers
+// the messages sent while the session was away.
+func (s *Session) resume(ctx context.Context, conn *websocket.Conn) error {
+	s.mu.Lock()
+	subs := make(map[int]bool, len(s.subs))
+	for id, on := range s.subs {
+		subs[id] = on
+	}
+	s.mu.Unlock()
+	for id, on := range subs {
+		frame := models.WSIncoming{Type: "unsubscribe", ChannelID: id}
+		if on {
+			frame.Type = "subscribe"
+		}
+		if err := conn.WriteJSON(frame); err != nil {
+			return err
+		}
+	}
+	if s.cfg.NoResume {
+		return nil
+	}
+
+	channels, err := s.c.UserChannels(ctx, s.userID)
+	if err != nil {
+		return err
+	}
+	for _, ch := range channels {
+		if on, ok := subs[ch.ID]; ok && !on {
+			continue
+		}
+		if err := s.catchUp(ctx, ch.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUp delivers the channel's messages that weren't delivered yet.
+func (s *Session) catchUp(ctx context.Context, channelID int) error {
+	const pageSize = 200
+	after := s.resumeAfter(channelID)
+	for {
+		var msgs []models.Message
+		var err error
+		if after == 0 {
+			// A channel joined during the session: all of it is new.
+			msgs, err = s.c.Messages(ctx, channelID)
+		} else {
+			msgs, err = s.c.MessagesPage(ctx, channelID, models.MessagePage{AfterID: after, Limit: pageSize})
+		}
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if s.fresh(m.ChannelID, m.ID) {
+				deliver(s, s.messages, models.WSOutgoing{
+					Type:        "message",
+					ID:          m.ID,
+					ChannelID:   m.ChannelID,
+					SenderID:    m.SenderID,
+					Content:     m.Content,
+					CreatedAt:   m.CreatedAt,
+					Attachments: m.Attachments,
+				})
+			}
+		}
+		if after == 0 || len(msgs) < pageSize {
+			return nil
+		}
+		after = msgs[len(msgs)-1].ID
+	}
+}
+
+// markHistory records the newest message of each of the user's channels,
+// so a resume only delivers what was sent after the session started. It
+// returns the channels it marked.
+func (s *Session) markHistory(ctx context.Context) ([]int, error) {
+	channels, err := s.c.UserChannels(ctx, s.userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(channels))
+	for _, ch := range channels {
+		msgs, err := s.c.MessagesPage(ctx, ch.ID, models.MessagePage{Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			s.markSeen(ch.ID, msgs[len(msgs)-1].ID)
+		}
+		ids = append(ids, ch.ID)
+	}
+	return ids, nil
+}
+
+// stopContext is cancelled by Close.
+func (s *Session) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *Session) finish() {
+	deliver(s, s.states, Closed)
+	close(s.messages)
+	close(s.presence)
+	close(s.typing)
+	close(s.attachments)
+	close(s.errs)
+	close(s.states)
+	close(s.done)
+}
+
+// jitter picks a delay between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,130 @@
+// Command chat-tui is a terminal client for the chat server, built on the
+// Go SDK. It reads commands and messages line by line from stdin and prints
+// live events as they arrive, so it works interactively and in scripts:
+//
+//	chat-tui -user alice
+//	printf '/open 3\ndeploy finished\n' | chat-tui -user deploy-bot -wait 2s
+//
+// Lines starting with / are commands (see /help); anything else is sent to
+// the open channel.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"chat-app/backend/client"
+)
+
+func main() {
+	server := flag.String("server", envOr("CHAT_SERVER", "http://localhost:8080"), "server base URL (CHAT_SERVER)")
+	username := flag.String("user", os.Getenv("CHAT_USER"), "username to log in as (CHAT_USER)")
+	create := flag.Bool("create", false, "create the user if it doesn't exist")
+	channel := flag.Int("channel", 0, "channel to open at start")
+	pageSize := flag.Int("page", 20, "messages per history page")
+	wait := flag.Duration("wait", 0, "keep printing events this long after stdin ends")
+	flag.Parse()
+
+	if *username == "" {
+		fmt.Fprintln(os.Stderr, "chat-tui: -user is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*server, *username, *create, *channel, *pageSize, *wait); err != nil {
+		fmt.Fprintln(os.Stderr, "chat-tui:", err)
+		os.Exit(1)
+	}
+}
+
+func run(server, username string, create bool, channel, pageSize int, wait time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c, err := client.New(server, client.WithUserAgent("chat-tui"))
+	if err != nil {
+		return err
+	}
+	userID, err := login(ctx, c, username, create)
+	if err != nil {
+		return err
+	}
+	s, err := c.Connect(ctx, userID, client.SessionConfig{})
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer s.Close()
+
+	ui := newUI(os.Stdout, c, s, username, pageSize)
+	go ui.printEvents()
+	if err := ui.loadChannels(ctx); err != nil {
+		return err
+	}
+	ui.printf("Logged in as %s (user %d). /help lists the commands.", username, userID)
+	ui.listChannels()
+	if channel > 0 {
+		ui.open(ctx, channel)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Done():
+			return s.Err()
+		case line, ok := <-lines:
+			if !ok {
+				// Scripts end here; give the last messages time to arrive.
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+				return nil
+			}
+			if err := ui.handle(ctx, line); errors.Is(err, errQuit) {
+				return nil
+			}
+		}
+	}
+}
+
+// login finds the user, creating it if asked to. The server has no
+// passwords: logging in only picks the user id.
+func login(ctx context.Context, c *client.Client, username string, create bool) (int, error) {
+	found, err := c.LookupUser(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("log in: %w", err)
+	}
+	if found.Exists {
+		return found.ID, nil
+	}
+	if !create {
+		return 0, fmt.Errorf("no user %q; pass -create to create it", username)
+	}
+	id, err := c.CreateUser(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("create user: %w", err)
+	}
+	return id, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"chat-app/backend/client"
+	"chat-app/backend/models"
+)
+
+var errQuit = errors.New("quit")
+
+const helpText = `Commands:
+  /channels               list your channels
+  /open <id>              open a channel and show its latest messages
+  /more                   show older messages of the open channel
+  /dm <username>          open a direct channel with a user
+  /group <name> <user>... create a group channel with you and the users
+  /invite <username>      add a user to the open channel
+  /upload <path> [text]   send a file to the open channel
+  /search <query>         search your channels (from:, in:, before:, after:, has:link)
+  /status online|away|dnd set your presence
+  /quit                   leave
+Any other line is sent to the open channel.`
+
+// ui holds the terminal state. Output from the command loop and the event
+// printer is serialised by mu.
+type ui struct {
+	out      io.Writer
+	c        *client.Client
+	s        *client.Session
+	pageSize int
+
+	mu       sync.Mutex
+	channels map[int]models.Channel
+	order    []int
+	current  int
+	oldest   map[int]int    // oldest message id shown per channel, for /more
+	names    map[int]string // usernames learned so far
+}
+
+func newUI(out io.Writer, c *client.Client, s *client.Session, me string, pageSize int) *ui {
+	return &ui{
+		out:      out,
+		c:        c,
+		s:        s,
+		pageSize: pageSize,
+		channels: map[int]models.Channel{},
+		oldest:   map[int]int{},
+		names:    map[int]string{s.UserID(): me},
+	}
+}
+
+func (u *ui) printf(format string, args ...interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fmt.Fprintf(u.out, format+"\n", args...)
+}
+
+// handle runs one input line.
+func (u *ui) handle(ctx context.Context, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if !strings.HasPrefix(line, "/") {
+		u.send(line)
+		return nil
+	}
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "/help":
+		u.printf("%s", helpText)
+	case "/quit", "/exit":
+		return errQuit
+	case "/channels":
+		if err := u.loadChannels(ctx); err != nil {
+			u.printf("! %v", err)
+			return nil
+		}
+		u.listChannels()
+	case "/open":
+		id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+		if err != nil {
+			u.printf("! usage: /open <channel id>")
+			return nil
+		}
+		u.open(ctx, id)
+	case "/more":
+		u.more(ctx)
+	case "/dm":
+		u.createChannel(ctx, "DIRECT", "", strings.Fields(arg))
+	case "/group":
+		fields := strings.Fields(arg)
+		if len(fields) < 2 {
+			u.printf("! usage: /group <name> <username>...")
+			return nil
+		}
+		u.createChannel(ctx, "GROUP", fields[0], fields[1:])
+	case "/invite":
+		u.invite(ctx, arg)
+	case "/upload":
+		path, text, _ := strings.Cut(arg, " ")
+		u.upload(ctx, path, text)
+	case "/search":
+		u.search(ctx, arg)
+	case "/status":
+		if err := u.s.SetStatus(arg); err != nil {
+			u.printf("! %v", err)
+		}
+	default:
+		u.printf("! unknown command %s, see /help", cmd)
+	}
+	return nil
+}
+
+func (u *ui) loadChannels(ctx context.Context) error {
+	channels, err := u.c.UserChannels(ctx, u.s.UserID())
+	if err != nil {
+		return fmt.Errorf("list channels: %w", err)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.order = u.order[:0]
+	for _, ch := range channels {
+		u.channels[ch.ID] = ch
+		u.order = append(u.order, ch.ID)
+	}
+	return nil
+}
+
+func (u *ui) listChannels() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.order) == 0 {
+		fmt.Fprintln(u.out, "You are in no channels yet. Start one with /dm or /group.")
+		return
+	}
+	fmt.Fprintln(u.out, "Channels:")
+	for _, id := range u.order {
+		mark := " "
+		if id == u.current {
+			mark = "*"
+		}
+		ch := u.channels[id]
+		fmt.Fprintf(u.out, " %s #%-4d %-6s %s\n", mark, id, strings.ToLower(ch.ChannelType), ch.ChannelName)
+	}
+}
+
+// open makes a channel current and prints its latest page.
+func (u *ui) open(ctx context.Context, id int) {
+	u.mu.Lock()
+	_, member := u.channels[id]
+	u.mu.Unlock()
+	if !member {
+		u.loadChannels(ctx)
+		u.mu.Lock()
+		_, member = u.channels[id]
+		u.mu.Unlock()
+		if !member {
+			u.printf("! you are not in channel #%d", id)
+			return
+		}
+	}
+	u.mu.Lock()
+	u.current = id
+	delete(u.oldest, id)
+	u.mu.Unlock()
+	u.printf("-- #%d %s --", id, u.label(id))
+	u.more(ctx)
+}
+
+// more prints the page of the open channel before the oldest message shown.
+func (u *ui) more(ctx context.Context) {
+	u.mu.Lock()
+	id, before := u.current, u.oldest[u.current]
+	u.mu.Unlock()
+	if id == 0 {
+		u.printf("! open a channel first")
+		return
+	}
+	if before == 1 {
+		u.printf("-- beginning of #%d --", id)
+		return
+	}
+	msgs, err := u.c.MessagesPage(ctx, id, models.MessagePage{BeforeID: before, Limit: u.pageSize})
+	if err != nil {
+		u.printf("! history: %v", err)
+		return
+	}
+	if len(msgs) == 0 {
+		u.printf("-- beginning of #%d --", id)
+		u.mu.Lock()
+		u.oldest[id] = 1
+		u.mu.Unlock()
+		return
+	}
+	for _, m := range msgs {
+		u.printMessage(models.WSOutgoing{ID: m.ID, ChannelID: m.ChannelID, SenderID: m.SenderID,
+			Content: m.Content, CreatedAt: m.CreatedAt, Attachments: m.Attachments})
+	}
+	if len(msgs) == u.pageSize {
+		u.printf("-- /more for older messages --")
+	}
+	u.mu.Lock()
+	u.oldest[id] = msgs[0].ID
+	u.mu.Unlock()
+}
+
+func (u *ui) send(text string) {
+	u.mu.Lock()
+	id := u.current
+	u.mu.Unlock()
+	if id == 0 {
+		u.printf("! open a channel first, see /channels")
+		return
+	}
+	if err := u.s.Send(id, text); err != nil {
+		u.printf("! %v", err)
+	}
+}
+
+// lookup resolves usernames to ids, remembering them for display.
+func (u *ui) lookup(ctx context.Context, usernames []string) ([]int, bool) {
+	var ids []int
+	for _, name := range usernames {
+		found, err := u.c.LookupUser(ctx, name)
+		if err != nil {
+			u.printf("! %v", err)
+			return nil, false
+		}
+		if !found.Exists {
+			u.printf("! no user %q", name)
+			return nil, false
+		}
+		u.mu.Lock()
+		u.names[found.ID] = name
+		u.mu.Unlock()
+		ids = append(ids, found.ID)
+	}
+	return ids, true
+}
+
+func (u *ui) createChannel(ctx context.Context, channelType, name string, usernames []string) {
+	if channelType == "DIRECT" && len(usernames) != 1 {
+		u.printf("! usage: /dm <username>")
+		return
+	}
+	ids, ok := u.lookup(ctx, usernames)
+	if !ok {
+		return
+	}
+	id, err := u.c.CreateChannel(ctx, models.CreateChannelRequest{
+		ChannelType: channelType,
+		ChannelName: name,
+		UserIDs:     append([]int{u.s.UserID()}, ids...),
+	})
+	if err != nil {
+		u.printf("! %v", err)
+		return
+	}
+	// The server subscribes sessions to their channels only on connect.
+	if err := u.s.Subscribe(id); err != nil {
+		u.printf("! %v", err)
+	}
+	u.open(ctx, id)
+}
+
+func (u *ui) invite(ctx context.Context, username string) {
+	u.mu.Lock()
+	id := u.current
+	u.mu.Unlock()
+	if id == 0 || username == "" {
+		u.printf("! usage: /invite <username> with a channel open")
+		return
+	}
+	ids, ok := u.lookup(ctx, []string{username})
+	if !ok {
+		return
+	}
+	if err := u.c.AddMember(ctx, id, ids[0]); err != nil {
+		u.printf("! %v", err)
+		return
+	}
+	u.printf("-- added %s to #%d --", username, id)
+}
+
+func (u *ui) upload(ctx context.Context, path, text string) {
+	u.mu.Lock()
+	id := u.current
+	u.mu.Unlock()
+	if id == 0 || path == "" {
+		u.printf("! usage: /upload <path> [text] with a channel open")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		u.printf("! %v", err)
+		return
+	}
+	defer f.Close()
+	att, err := u.c.UploadAttachment(ctx, id, u.s.UserID(), filepath.Base(path), f)
+	if err != nil {
+		u.printf("! upload: %v", err)
+		return
+	}
+	if err := u.s.Send(id, text, att.ID); err != nil {
+		u.printf("! %v", err)
+	}
+}
+
+func (u *ui) search(ctx context.Context, q string) {
+	if q == "" {
+		u.printf("! usage: /search <query>")
+		return
+	}
+	page, err := u.c.Search(ctx, u.s.UserID(), q, u.pageSize, 0)
+	if err != nil {
+		u.printf("! search: %v", err)
+		return
+	}
+	if len(page.Results) == 0 {
+		u.printf("-- no matches --")
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, r := range page.Results {
+		u.names[r.SenderID] = r.SenderUsername
+		snippet := html.UnescapeString(strings.NewReplacer("<mark>", "*", "</mark>", "*").Replace(r.Snippet))
+		fmt.Fprintf(u.out, "[%s] #%d %s: %s\n", r.CreatedAt.Local().Format("Jan 2 15:04"), r.ChannelID, r.SenderUsername, snippet)
+	}
+	if page.NextOffset != nil {
+		fmt.Fprintln(u.out, "-- more matches; narrow the query --")
+	}
+}
+
+// printEvents prints live events until the session ends.
+func (u *ui) printEvents() {
+	messages, presence, typing, errs, states :=
+		u.s.Messages(), u.s.Presence(), u.s.Typing(), u.s.Errors(), u.s.States()
+	for messages != nil || states != nil {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			u.printMessage(m)
+		case p, ok := <-presence:
+			if !ok {
+				presence = nil
+				continue
+			}
+			if p.UserID != u.s.UserID() {
+				u.printf("-- %s is %s --", u.name(p.UserID), p.Status)
+			}
+		case t, ok := <-typing:
+			if !ok {
+				typing = nil
+				continue
+			}
+			u.mu.Lock()
+			current := t.ChannelID == u.current
+			u.mu.Unlock()
+			if current {
+				u.printf("-- %s is typing --", u.name(t.UserID))
+			}
+		case e, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			u.printf("! %s: %s", e.Code, e.Message)
+		case s, ok := <-states:
+			if !ok {
+				states = nil
+				continue
+			}
+			if s != client.Connected {
+				u.printf("-- %s --", s)
+			}
+		}
+	}
+}
+
+func (u *ui) printMessage(m models.WSOutgoing) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	where := ""
+	if m.ChannelID != u.current {
+		where = fmt.Sprintf("#%d ", m.ChannelID)
+	}
+	line := fmt.Sprintf("[%s] %s%s: %s", m.CreatedAt.Local().Format("15:04"), where, u.nameLocked(m.SenderID), m.Content)
+	for _, a := range m.Attachments {
+		line += fmt.Sprintf(" [%s %s]", a.Filename, a.URL)
+	}
+	fmt.Fprintln(u.out, line)
+}
+
+func (u *ui) label(id int) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ch := u.channels[id]
+	if ch.ChannelType == "DIRECT" {
+		return "(direct)"
+	}
+	return ch.ChannelName
+}
+
+func (u *ui) name(userID int) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.nameLocked(userID)
+}
+
+func (u *ui) nameLocked(userID int) string {
+	if name, ok := u.names[userID]; ok {
+		return name
+	}
+	return "user " + strconv.Itoa(userID)
+}
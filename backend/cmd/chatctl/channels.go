@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+func channelsList(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 1 {
+		return errUsage{}
+	}
+	db, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+	u, err := resolveUser(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+	channels, err := db.FetchUserChannels(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	rows := make([]string, len(channels))
+	for i, ch := range channels {
+		rows[i] = fmt.Sprintf("%d\t%s\t%s\t%s", ch.ID, ch.ChannelType, ch.ChannelName, ch.CreatedAt.Local().Format(timeFormat))
+	}
+	c.table("ID\tTYPE\tNAME\tCREATED", rows)
+	return nil
+}
+
+func channelsShow(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 1 {
+		return errUsage{}
+	}
+	db, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+	ch, err := resolveChannel(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+	members, err := db.FetchChannelMembers(ctx, ch.ID)
+	if err != nil {
+		return err
+	}
+	c.printf("channel %d: %s %q, created %s", ch.ID, ch.ChannelType, ch.ChannelName, ch.CreatedAt.Local().Format(timeFormat))
+	c.printf("%d member(s):", len(members))
+	rows := make([]string, len(members))
+	for i, u := range members {
+		rows[i] = fmt.Sprintf("%d\t%s\t%s", u.ID, u.Username, userState(u))
+	}
+	c.table("ID\tUSERNAME\tSTATE", rows)
+	return nil
+}
+
+func channelsAdd(ctx context.Context, c *ctl, args []string) error {
+	db, ch, users, err := membershipArgs(ctx, c, args)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := db.AddChannelMembers(ctx, ch.ID, []int{u.ID}); err != nil {
+			return fmt.Errorf("add %s: %w", u.Username, err)
+		}
+		c.printf("added %s to channel %d", u.Username, ch.ID)
+	}
+	// Like POST /api/v1/channels/{id}/members, this doesn't touch live
+	// sessions: the server subscribes them on their next connect.
+	return nil
+}
+
+func channelsRemove(ctx context.Context, c *ctl, args []string) error {
+	db, ch, users, err := membershipArgs(ctx, c, args)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		err := db.RemoveChannelMember(ctx, ch.ID, u.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("%s is not a member of channel %d", u.Username, ch.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("remove %s: %w", u.Username, err)
+		}
+		c.printf("removed %s from channel %d", u.Username, ch.ID)
+		// Open sessions stay subscribed until they reconnect.
+		if err := c.kick(ctx, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// membershipArgs resolves `<channel> <user>...`.
+func membershipArgs(ctx context.Context, c *ctl, args []string) (store.Store, models.Channel, []models.User, error) {
+	if len(args) < 2 {
+		return nil, models.Channel{}, nil, errUsage{}
+	}
+	db, err := c.store(ctx)
+	if err != nil {
+		return nil, models.Channel{}, nil, err
+	}
+	ch, err := resolveChannel(ctx, db, args[0])
+	if err != nil {
+		return nil, models.Channel{}, nil, err
+	}
+	var users []models.User
+	for _, arg := range args[1:] {
+		u, err := resolveUser(ctx, db, arg)
+		if err != nil {
+			return nil, models.Channel{}, nil, err
+		}
+		users = append(users, u)
+	}
+	return db, ch, users, nil
+}
+
+// resolveChannel finds a channel by ID, written as 12 or #12.
+func resolveChannel(ctx context.Context, db store.Store, arg string) (models.Channel, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return models.Channel{}, fmt.Errorf("invalid channel ID %q", arg)
+	}
+	ch, err := db.GetChannel(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return ch, fmt.Errorf("no channel %d", id)
+	}
+	return ch, err
+}
@@ -0,0 +1,225 @@
+// Command chatctl is the operator tool of the chat server. It works on the
+// database through the same store package as the server, so data can be
+// fixed without hand-written SQL, and asks a running server's admin API
+// about live connections:
+//
+//	chatctl users disable alice
+//	chatctl channels show 12
+//	chatctl messages purge -before 2160h -yes
+//	chatctl sessions list
+//	chatctl migrate status
+//
+// The database is chosen with the server's own variables: DB_DRIVER,
+// DATABASE_URL, SQLITE_PATH and UPLOAD_DIR. CHAT_SERVER and ADMIN_TOKEN
+// point at the running server.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"chat-app/backend/client"
+	"chat-app/backend/migrations"
+	"chat-app/backend/store"
+)
+
+// command is one `chatctl <group> <name>` command.
+type command struct {
+	name string // "users disable"
+	args string // shown after the name in the usage
+	help string
+	run  func(ctx context.Context, c *ctl, args []string) error
+}
+
+var commands = []command{
+	{name: "users list", help: "list users", run: usersList},
+	{name: "users create", args: "<username>", help: "create a user", run: usersCreate},
+	{name: "users disable", args: "<user>", help: "lock a user out and disconnect their sessions", run: usersDisable},
+	{name: "users enable", args: "<user>", help: "let a disabled user back in", run: usersEnable},
+	{name: "channels list", args: "<user>", help: "list a user's channels", run: channelsList},
+	{name: "channels show", args: "<channel>", help: "show a channel and its members", run: channelsShow},
+	{name: "channels add", args: "<channel> <user>...", help: "add users to a channel", run: channelsAdd},
+	{name: "channels remove", args: "<channel> <user>...", help: "remove users from a channel", run: channelsRemove},
+	{name: "messages export", args: "[-after id] [-o file] <channel>", help: "write a channel's messages as JSON lines", run: messagesExport},
+	{name: "messages purge", args: "[-channel id] [-before time] [-all] -yes", help: "delete messages and their attachment files", run: messagesPurge},
+	{name: "sessions list", args: "[user]", help: "list live connections", run: sessionsList},
+	{name: "sessions disconnect", args: "<user> [session]", help: "close a user's connections", run: sessionsDisconnect},
+	{name: "migrate up", help: "apply pending migrations", run: migrateUp},
+	{name: "migrate down", args: "[n]", help: "roll back the last n migrations (default 1)", run: migrateDown},
+	{name: "migrate status", help: "list migrations and whether they are applied", run: migrateStatus},
+}
+
+func main() {
+	c := &ctl{out: os.Stdout, errOut: os.Stderr}
+	flags := flag.NewFlagSet("chatctl", flag.ExitOnError)
+	flags.StringVar(&c.driver, "driver", envOr("DB_DRIVER", store.DialectPostgres), "database driver, postgres or sqlite (DB_DRIVER)")
+	flags.StringVar(&c.databaseURL, "database-url", os.Getenv("DATABASE_URL"), "Postgres connection string (DATABASE_URL)")
+	flags.StringVar(&c.sqlitePath, "sqlite-path", envOr("SQLITE_PATH", "chat.db"), "SQLite database file (SQLITE_PATH)")
+	flags.StringVar(&c.uploadDir, "upload-dir", envOr("UPLOAD_DIR", "uploads"), "attachment directory (UPLOAD_DIR)")
+	flags.StringVar(&c.server, "server", os.Getenv("CHAT_SERVER"), "running server's base URL (CHAT_SERVER)")
+	flags.StringVar(&c.adminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "server's admin token (ADMIN_TOKEN)")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) < 2 {
+		usage(flags)
+		os.Exit(2)
+	}
+	cmd, ok := lookup(args[0] + " " + args[1])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "chatctl: unknown command %q\n", args[0]+" "+args[1])
+		usage(flags)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := cmd.run(ctx, c, args[2:])
+	stop()
+	c.close()
+	var usageErr errUsage
+	switch {
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(os.Stderr, "usage: chatctl %s %s\n", cmd.name, cmd.args)
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "chatctl:", err)
+		os.Exit(1)
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintln(out, "usage: chatctl [flags] <command> [args]")
+	fmt.Fprintln(out, "\nCommands (<user> is a username or ID):")
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	tw.Flush()
+	fmt.Fprintln(out, "\nFlags:")
+	flags.PrintDefaults()
+}
+
+// errUsage reports wrong arguments; main prints the command's usage line.
+type errUsage struct{}
+
+func (errUsage) Error() string { return "usage" }
+
+// ctl holds the settings and the database connection shared by the
+// commands. The database is opened on first use, so commands only need the
+// settings they actually touch.
+type ctl struct {
+	out io.Writer
+	// errOut takes progress and problems, keeping out clean for data such
+	// as an export.
+	errOut io.Writer
+
+	driver, databaseURL, sqlitePath, uploadDir string
+	server, adminToken                         string
+
+	db store.SQLStore
+}
+
+// store opens the database and insists on an up-to-date schema, which the
+// queries of this build rely on. The migrate commands use open instead.
+func (c *ctl) store(ctx context.Context) (store.SQLStore, error) {
+	if c.db != nil {
+		return c.db, nil
+	}
+	db, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrations.New(db.DB(), db.Dialect())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("check migrations (has `chatctl migrate up` run?): %w", err)
+	}
+	if len(pending) > 0 {
+		db.Close()
+		return nil, fmt.Errorf("%d migration(s) pending, run `chatctl migrate up` first", len(pending))
+	}
+	c.db = db
+	return db, nil
+}
+
+// open connects to the database without checking the schema.
+func (c *ctl) open() (store.SQLStore, error) {
+	dsn := c.databaseURL
+	if c.driver == store.DialectSQLite {
+		dsn = c.sqlitePath
+	} else if dsn == "" {
+		return nil, errors.New("DATABASE_URL (-database-url) is required for the postgres driver")
+	}
+	return store.Open(c.driver, dsn)
+}
+
+// api returns a client of the running server, or nil if none is configured.
+func (c *ctl) api() (*client.Client, error) {
+	if c.server == "" || c.adminToken == "" {
+		return nil, nil
+	}
+	return client.New(c.server, client.WithAdminToken(c.adminToken), client.WithUserAgent("chatctl"))
+}
+
+// mustAPI is api for commands that can't work without the server.
+func (c *ctl) mustAPI() (*client.Client, error) {
+	api, err := c.api()
+	if err == nil && api == nil {
+		err = errors.New("CHAT_SERVER (-server) and ADMIN_TOKEN (-admin-token) are required")
+	}
+	return api, err
+}
+
+func (c *ctl) close() {
+	if c.db != nil {
+		c.db.Close()
+	}
+}
+
+func (c *ctl) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, format+"\n", args...)
+}
+
+func (c *ctl) warnf(format string, args ...interface{}) {
+	fmt.Fprintf(c.errOut, format+"\n", args...)
+}
+
+// table writes aligned columns; rows are tab-separated.
+func (c *ctl) table(header string, rows []string) {
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for _, row := range rows {
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+)
+
+// exportPage is how many messages export reads per query.
+const exportPage = 500
+
+// messagesExport writes a channel's history, oldest first, as one JSON
+// models.Message per line.
+func messagesExport(ctx context.Context, c *ctl, args []string) error {
+	flags := flag.NewFlagSet("messages export", flag.ContinueOnError)
+	after := flags.Int("after", 0, "start after this message ID")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage{}
+	}
+	db, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+	ch, err := resolveChannel(ctx, db, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = c.out
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	n := 0
+	for page := (models.MessagePage{AfterID: *after, Limit: exportPage}); ; {
+		msgs, err := db.FetchMessagesPage(ctx, ch.ID, page)
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+		n += len(msgs)
+		if len(msgs) < page.Limit {
+			break
+		}
+		page.AfterID = msgs[len(msgs)-1].ID
+	}
+	c.warnf("exported %d message(s) of channel %d", n, ch.ID)
+	return nil
+}
+
+// messagesPurge deletes messages, with the rows and files of their
+// attachments. It needs -yes, and a -channel or -before to bound it.
+func messagesPurge(ctx context.Context, c *ctl, args []string) error {
+	flags := flag.NewFlagSet("messages purge", flag.ContinueOnError)
+	channel := flags.String("channel", "", "only this channel")
+	before := flags.String("before", "", "only messages older than this: a date (2024-01-31), an RFC 3339 time or an age (720h)")
+	all := flags.Bool("all", false, "with neither -channel nor -before, purge every message")
+	yes := flags.Bool("yes", false, "really delete")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage{}
+	}
+	if *channel == "" && *before == "" && !*all {
+		return errors.New("refusing to purge every message; pass -channel, -before or -all")
+	}
+	var cutoff time.Time
+	if *before != "" {
+		t, err := parseCutoff(*before, time.Now())
+		if err != nil {
+			return err
+		}
+		cutoff = t
+	}
+	if !*yes {
+		return errors.New("purging can't be undone; pass -yes to go ahead")
+	}
+
+	db, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+	channelID := 0
+	if *channel != "" {
+		ch, err := resolveChannel(ctx, db, *channel)
+		if err != nil {
+			return err
+		}
+		channelID = ch.ID
+	}
+	n, keys, err := db.DeleteMessages(ctx, channelID, cutoff)
+	if err != nil {
+		return err
+	}
+	c.printf("deleted %d message(s)", n)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	files, err := storage.NewLocal(c.uploadDir)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, key := range keys {
+		err := files.Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			c.warnf("delete %s: %v", key, err)
+			failed++
+		}
+	}
+	c.printf("deleted %d attachment file(s) from %s", len(keys)-failed, c.uploadDir)
+	if failed > 0 {
+		return fmt.Errorf("%d attachment file(s) could not be deleted", failed)
+	}
+	return nil
+}
+
+// parseCutoff reads -before: an age relative to now, a date or a time.
+func parseCutoff(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -before %q: want a date, an RFC 3339 time or an age like 720h", s)
+}
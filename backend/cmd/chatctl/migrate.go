@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"chat-app/backend/migrations"
+)
+
+// These mirror `backend migrate`, for hosts that only have chatctl.
+
+func migrateUp(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage{}
+	}
+	m, err := c.migrator()
+	if err != nil {
+		return err
+	}
+	n, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+	c.printf("applied %d migration(s)", n)
+	return nil
+}
+
+func migrateDown(ctx context.Context, c *ctl, args []string) error {
+	steps := 1
+	switch len(args) {
+	case 0:
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return errUsage{}
+		}
+		steps = n
+	default:
+		return errUsage{}
+	}
+	m, err := c.migrator()
+	if err != nil {
+		return err
+	}
+	n, err := m.Down(ctx, steps)
+	if err != nil {
+		return err
+	}
+	c.printf("reverted %d migration(s)", n)
+	return nil
+}
+
+func migrateStatus(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage{}
+	}
+	m, err := c.migrator()
+	if err != nil {
+		return err
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	rows := make([]string, len(statuses))
+	for i, s := range statuses {
+		state := "pending"
+		if s.AppliedAt != nil {
+			state = "applied " + formatTime(s.AppliedAt)
+		}
+		rows[i] = strconv.Itoa(s.Version) + "\t" + s.Name + "\t" + state
+	}
+	c.table("VERSION\tNAME\tSTATE", rows)
+	return nil
+}
+
+// migrator opens the database without the schema check of store.
+func (c *ctl) migrator() (*migrations.Migrator, error) {
+	db, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	c.db = db
+	return migrations.New(db.DB(), db.Dialect())
+}
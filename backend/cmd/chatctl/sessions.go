@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"chat-app/backend/models"
+)
+
+// The sessions commands ask the running server: only its Hub knows who is
+// connected.
+
+func sessionsList(ctx context.Context, c *ctl, args []string) error {
+	if len(args) > 1 {
+		return errUsage{}
+	}
+	api, err := c.mustAPI()
+	if err != nil {
+		return err
+	}
+	var sessions []models.Session
+	if len(args) == 1 {
+		userID, err := c.userID(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		sessions, err = api.UserSessions(ctx, userID)
+		if err != nil {
+			return err
+		}
+	} else if sessions, err = api.AllSessions(ctx); err != nil {
+		return err
+	}
+
+	rows := make([]string, len(sessions))
+	for i, s := range sessions {
+		channels := make([]string, len(s.Channels))
+		for j, id := range s.Channels {
+			channels[j] = strconv.Itoa(id)
+		}
+		rows[i] = fmt.Sprintf("%s\t%d\t%s\t%s\t%s\t%s", s.ID, s.UserID, s.RemoteAddr,
+			s.ConnectedAt.Local().Format(timeFormat), strings.Join(channels, ","), s.UserAgent)
+	}
+	c.table("SESSION\tUSER\tREMOTE\tCONNECTED\tCHANNELS\tUSER AGENT", rows)
+	return nil
+}
+
+func sessionsDisconnect(ctx context.Context, c *ctl, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage{}
+	}
+	api, err := c.mustAPI()
+	if err != nil {
+		return err
+	}
+	userID, err := c.userID(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	sessionID := ""
+	if len(args) == 2 {
+		sessionID = args[1]
+	}
+	n, err := api.Disconnect(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	c.printf("closed %d session(s)", n)
+	return nil
+}
+
+// userID resolves a <user> argument for the admin API. IDs are used as
+// given, so listing sessions doesn't need the database.
+func (c *ctl) userID(ctx context.Context, arg string) (int, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		return id, nil
+	}
+	db, err := c.store(ctx)
+	if err != nil {
+		return 0, err
+	}
+	u, err := resolveUser(ctx, db, arg)
+	return u.ID, err
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/backend/store"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration. Values are layered, each source
+// overriding the previous one: built-in defaults, the YAML or TOML file
+// given by -config or CONFIG_FILE, environment variables (a .env file is
+// loaded first), then command-line flags.
+type Config struct {
+	ListenAddr     string          `yaml:"listen_addr" toml:"listen_addr"`
+	TLS            TLSConfig       `yaml:"tls" toml:"tls"`
+	AllowedOrigins []string        `yaml:"allowed_origins" toml:"allowed_origins"`
+	RateLimit      RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Database       DatabaseConfig  `yaml:"database" toml:"database"`
+	WebSocket      WebSocketConfig `yaml:"websocket" toml:"websocket"`
+	Uploads        UploadsConfig   `yaml:"uploads" toml:"uploads"`
+	Shutdown       ShutdownConfig  `yaml:"shutdown" toml:"shutdown"`
+	Log            LogConfig       `yaml:"log" toml:"log"`
+	Tracing        TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Health         HealthConfig    `yaml:"health" toml:"health"`
+	// AdminToken is the bearer token of the /admin routes; empty disables
+	// them.
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+}
+
+// TLSConfig enables HTTPS when both files are set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+}
+
+// Enabled reports whether the server should serve TLS.
+func (t TLSConfig) Enabled() bool { return t.CertFile != "" }
+
+// RateLimitConfig sets the HTTP request budgets. Each client IP address
+// gets a separate bucket per route class.
+type RateLimitConfig struct {
+	Auth   RateLimitRule `yaml:"auth" toml:"auth"`
+	Writes RateLimitRule `yaml:"writes" toml:"writes"`
+	Reads  RateLimitRule `yaml:"reads" toml:"reads"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header is believed.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// IdleTimeout is how long an unused bucket is kept.
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// Store keeps the counters: memory (per instance) or database (shared
+	// by every instance using the same database).
+	Store string `yaml:"store" toml:"store"`
+}
+
+// RateLimitRule is a token bucket: a sustained rate and a burst size.
+type RateLimitRule struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second" toml:"requests_per_second"`
+	Burst             int     `yaml:"burst" toml:"burst"`
+}
+
+// DatabaseConfig selects the store and sizes its connection pool.
+type DatabaseConfig struct {
+	Driver          string        `yaml:"driver" toml:"driver"` // postgres or sqlite
+	URL             string        `yaml:"url" toml:"url"`
+	SQLitePath      string        `yaml:"sqlite_path" toml:"sqlite_path"`
+	AutoMigrate     bool          `yaml:"auto_migrate" toml:"auto_migrate"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+}
+
+// WebSocketConfig bounds what a single connection may use.
+type WebSocketConfig struct {
+	// MaxMessageBytes is the largest frame a client may send.
+	MaxMessageBytes int64 `yaml:"max_message_bytes" toml:"max_message_bytes"`
+	// SendBufferSize is how many outgoing frames are queued before the hub
+	// drops a slow connection.
+	SendBufferSize int `yaml:"send_buffer_size" toml:"send_buffer_size"`
+	// PongWait is how long a connection may stay silent; pings are sent at
+	// nine tenths of it.
+	PongWait time.Duration `yaml:"pong_wait" toml:"pong_wait"`
+	// MaxConnectionsPerUser limits concurrent tabs/devices; 0 means no limit.
+	MaxConnectionsPerUser int `yaml:"max_connections_per_user" toml:"max_connections_per_user"`
+	// Flood limits the frames a connection may send.
+	Flood FloodConfig `yaml:"flood" toml:"flood"`
+}
+
+// FloodConfig sets the WebSocket frame budgets and how repeated violations
+// escalate: StrikesBeforeMute refused frames within StrikeWindow mute the
+// connection for MuteDuration, and a connection muted more than MaxMutes
+// times is closed.
+type FloodConfig struct {
+	// ChannelTypes holds the budgets per channel type (DIRECT, GROUP).
+	ChannelTypes      map[string]FrameLimits `yaml:"channel_types" toml:"channel_types"`
+	StrikesBeforeMute int                    `yaml:"strikes_before_mute" toml:"strikes_before_mute"`
+	StrikeWindow      time.Duration          `yaml:"strike_window" toml:"strike_window"`
+	MuteDuration      time.Duration          `yaml:"mute_duration" toml:"mute_duration"`
+	MaxMutes          int                    `yaml:"max_mutes" toml:"max_mutes"`
+}
+
+// FrameLimits are the budgets for the frame kinds in one channel type.
+// Subscribe also covers unsubscribe, set_status and unknown frames.
+type FrameLimits struct {
+	Message   FrameLimit `yaml:"message" toml:"message"`
+	Subscribe FrameLimit `yaml:"subscribe" toml:"subscribe"`
+	Typing    FrameLimit `yaml:"typing" toml:"typing"`
+}
+
+// FrameLimit is charged twice per frame: to the connection's bucket and to
+// the bucket shared by all of the user's connections.
+type FrameLimit struct {
+	PerConnection RateLimitRule `yaml:"per_connection" toml:"per_connection"`
+	PerUser       RateLimitRule `yaml:"per_user" toml:"per_user"`
+}
+
+// UploadsConfig controls attachment storage.
+type UploadsConfig struct {
+	Dir      string `yaml:"dir" toml:"dir"`
+	MaxBytes int64  `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// ShutdownConfig controls how the server drains on SIGTERM.
+type ShutdownConfig struct {
+	// Timeout bounds the whole drain: HTTP requests, in-flight WebSocket
+	// frames and closing every connection.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// ReconnectAfter and ReconnectJitter are the hint sent to clients in
+	// the server_shutdown frame.
+	ReconnectAfter  time.Duration `yaml:"reconnect_after" toml:"reconnect_after"`
+	ReconnectJitter time.Duration `yaml:"reconnect_jitter" toml:"reconnect_jitter"`
+}
+
+// HealthConfig tunes the /livez and /readyz probes.
+type HealthConfig struct {
+	// CheckTimeout bounds each dependency check.
+	CheckTimeout time.Duration `yaml:"check_timeout" toml:"check_timeout"`
+}
+
+// defaultConfig is what the server runs with when nothing is configured.
+func defaultConfig() Config {
+	return Config{
+		ListenAddr:     ":8080",
+		AllowedOrigins: []string{"http://localhost:3000"},
+		RateLimit: RateLimitConfig{
+			Auth:        RateLimitRule{RequestsPerSecond: 1, Burst: 5},
+			Writes:      RateLimitRule{RequestsPerSecond: 5, Burst: 10},
+			Reads:       RateLimitRule{RequestsPerSecond: 20, Burst: 40},
+			IdleTimeout: 10 * time.Minute,
+			Store:       limiterStoreMemory,
+		},
+		Database: DatabaseConfig{
+			Driver:          store.DialectPostgres,
+			SQLitePath:      "chat.db",
+			AutoMigrate:     true,
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		WebSocket: WebSocketConfig{
+			MaxMessageBytes: 64 << 10,
+			SendBufferSize:  256,
+			PongWait:        60 * time.Second,
+			Flood: FloodConfig{
+				ChannelTypes: map[string]FrameLimits{
+					"DIRECT": defaultFrameLimits(),
+					"GROUP":  defaultFrameLimits(),
+				},
+				StrikesBeforeMute: 10,
+				StrikeWindow:      10 * time.Second,
+				MuteDuration:      30 * time.Second,
+				MaxMutes:          3,
+			},
+		},
+		Uploads: UploadsConfig{Dir: "uploads", MaxBytes: 10 << 20},
+		Shutdown: ShutdownConfig{
+			Timeout:         25 * time.Second,
+			ReconnectAfter:  2 * time.Second,
+			ReconnectJitter: 5 * time.Second,
+		},
+		Log: LogConfig{Format: "text", Level: "info"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			SampleRatio: 1,
+			ServiceName: "chat-backend",
+		},
+		Health: HealthConfig{CheckTimeout: 2 * time.Second},
+	}
+}
+
+// defaultFrameLimits leaves room for a fast typist and for the burst of
+// subscribes a client sends right after connecting.
+func defaultFrameLimits() FrameLimits {
+	return FrameLimits{
+		Message: FrameLimit{
+			PerConnection: RateLimitRule{RequestsPerSecond: 5, Burst: 10},
+			PerUser:       RateLimitRule{RequestsPerSecond: 10, Burst: 20},
+		},
+		Subscribe: FrameLimit{
+			PerConnection: RateLimitRule{RequestsPerSecond: 5, Burst: 50},
+			PerUser:       RateLimitRule{RequestsPerSecond: 10, Burst: 100},
+		},
+		Typing: FrameLimit{
+			PerConnection: RateLimitRule{RequestsPerSecond: 2, Burst: 5},
+			PerUser:       RateLimitRule{RequestsPerSecond: 4, Burst: 10},
+		},
+	}
+}
+
+// LoadConfig builds and validates the configuration. args are the
+// command-line arguments without the program name; positional arguments
+// left after the flags are returned.
+func LoadConfig(args []string) (Config, []string, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("backend", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML (.toml) config file")
+	listen := fs.String("listen", "", "address to listen on, e.g. :8080")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	origins := fs.String("allowed-origins", "", "comma-separated origins allowed by CORS and WebSocket upgrades")
+	dbDriver := fs.String("db-driver", "", "database driver: postgres or sqlite")
+	logFormat := fs.String("log-format", "", "log output format: text or json")
+	logLevel := fs.String("log-level", "", "minimum log level: debug, info, warn or error")
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	// It's best practice to load the connection string from an environment variable.
+	godotenv.Load()
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, nil, err
+	}
+
+	// Only flags given on the command line override the other sources.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.ListenAddr = *listen
+		case "tls-cert":
+			cfg.TLS.CertFile = *tlsCert
+		case "tls-key":
+			cfg.TLS.KeyFile = *tlsKey
+		case "allowed-origins":
+			cfg.AllowedOrigins = splitList(*origins)
+		case "db-driver":
+			cfg.Database.Driver = *dbDriver
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		}
+	})
+
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+// loadFile overrides cfg with the settings in a config file, read as TOML
+// when its name ends in .toml and as YAML otherwise.
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with any environment variables that are set.
+func (cfg *Config) applyEnv() error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	num := func(name string, set func(string) error) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			if err := set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	intVar := func(name string, dst *int) {
+		num(name, func(v string) (err error) { *dst, err = strconv.Atoi(v); return })
+	}
+	int64Var := func(name string, dst *int64) {
+		num(name, func(v string) (err error) { *dst, err = strconv.ParseInt(v, 10, 64); return })
+	}
+	durationVar := func(name string, dst *time.Duration) {
+		num(name, func(v string) (err error) { *dst, err = time.ParseDuration(v); return })
+	}
+
+	str("LISTEN_ADDR", &cfg.ListenAddr)
+	str("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	str("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	if v, ok := os.LookupEnv("ALLOWED_ORIGINS"); ok {
+		cfg.AllowedOrigins = splitList(v)
+	}
+
+	rl := &cfg.RateLimit
+	classes := []*RateLimitRule{&rl.Auth, &rl.Writes, &rl.Reads}
+	// RATE_LIMIT_RPS and RATE_LIMIT_BURST set every class at once.
+	num("RATE_LIMIT_RPS", func(v string) error {
+		rps, err := strconv.ParseFloat(v, 64)
+		for _, rule := range classes {
+			rule.RequestsPerSecond = rps
+		}
+		return err
+	})
+	num("RATE_LIMIT_BURST", func(v string) error {
+		burst, err := strconv.Atoi(v)
+		for _, rule := range classes {
+			rule.Burst = burst
+		}
+		return err
+	})
+	for i, name := range []string{"AUTH", "WRITES", "READS"} {
+		rule := classes[i]
+		num("RATE_LIMIT_"+name+"_RPS", func(v string) (err error) {
+			rule.RequestsPerSecond, err = strconv.ParseFloat(v, 64)
+			return
+		})
+		intVar("RATE_LIMIT_"+name+"_BURST", &rule.Burst)
+	}
+	if v, ok := os.LookupEnv("RATE_LIMIT_TRUSTED_PROXIES"); ok {
+		rl.TrustedProxies = splitList(v)
+	}
+	durationVar("RATE_LIMIT_IDLE_TIMEOUT", &rl.IdleTimeout)
+	str("RATE_LIMIT_STORE", &rl.Store)
+
+	str("DB_DRIVER", &cfg.Database.Driver)
+	str("DATABASE_URL", &cfg.Database.URL)
+	str("SQLITE_PATH", &cfg.Database.SQLitePath)
+	num("AUTO_MIGRATE", func(v string) (err error) {
+		cfg.Database.AutoMigrate, err = strconv.ParseBool(v)
+		return
+	})
+	intVar("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	intVar("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	durationVar("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+
+	int64Var("WS_MAX_MESSAGE_BYTES", &cfg.WebSocket.MaxMessageBytes)
+	intVar("WS_SEND_BUFFER_SIZE", &cfg.WebSocket.SendBufferSize)
+	durationVar("WS_PONG_WAIT", &cfg.WebSocket.PongWait)
+	intVar("WS_MAX_CONNECTIONS_PER_USER", &cfg.WebSocket.MaxConnectionsPerUser)
+	intVar("WS_FLOOD_STRIKES_BEFORE_MUTE", &cfg.WebSocket.Flood.StrikesBeforeMute)
+	durationVar("WS_FLOOD_STRIKE_WINDOW", &cfg.WebSocket.Flood.StrikeWindow)
+	durationVar("WS_FLOOD_MUTE_DURATION", &cfg.WebSocket.Flood.MuteDuration)
+	intVar("WS_FLOOD_MAX_MUTES", &cfg.WebSocket.Flood.MaxMutes)
+
+	str("UPLOAD_DIR", &cfg.Uploads.Dir)
+	int64Var("MAX_UPLOAD_BYTES", &cfg.Uploads.MaxBytes)
+
+	durationVar("SHUTDOWN_TIMEOUT", &cfg.Shutdown.Timeout)
+	durationVar("SHUTDOWN_RECONNECT_AFTER", &cfg.Shutdown.ReconnectAfter)
+	durationVar("SHUTDOWN_RECONNECT_JITTER", &cfg.Shutdown.ReconnectJitter)
+
+	str("LOG_FORMAT", &cfg.Log.Format)
+	str("LOG_LEVEL", &cfg.Log.Level)
+
+	str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	str("TRACING_OTLP_ENDPOINT", &cfg.Tracing.OTLPEndpoint)
+	str("TRACING_FILE", &cfg.Tracing.File)
+	num("TRACING_SAMPLE_RATIO", func(v string) (err error) {
+		cfg.Tracing.SampleRatio, err = strconv.ParseFloat(v, 64)
+		return
+	})
+	str("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+
+	durationVar("HEALTH_CHECK_TIMEOUT", &cfg.Health.CheckTimeout)
+
+	str("ADMIN_TOKEN", &cfg.AdminToken)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once.
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.ListenAddr != "", "listen_addr is required")
+	check((cfg.TLS.CertFile == "") == (cfg.TLS.KeyFile == ""), "tls: cert_file and key_file must be set together")
+	for _, f := range []string{cfg.TLS.CertFile, cfg.TLS.KeyFile} {
+		if f != "" {
+			_, err := os.Stat(f)
+			check(err == nil, "tls: %v", err)
+		}
+	}
+
+	check(len(cfg.AllowedOrigins) > 0, "allowed_origins must list at least one origin")
+	for _, o := range cfg.AllowedOrigins {
+		u, err := url.Parse(o)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "",
+			"allowed_origins: %q is not an origin like https://chat.example.com", o)
+	}
+
+	rateClasses := []struct {
+		name string
+		rule RateLimitRule
+	}{{classAuth, cfg.RateLimit.Auth}, {classWrites, cfg.RateLimit.Writes}, {classReads, cfg.RateLimit.Reads}}
+	for _, c := range rateClasses {
+		name, rule := c.name, c.rule
+		check(rule.RequestsPerSecond > 0, "rate_limit.%s.requests_per_second must be positive", name)
+		check(rule.Burst >= 1, "rate_limit.%s.burst must be at least 1", name)
+	}
+	for _, p := range cfg.RateLimit.TrustedProxies {
+		_, err := parseProxyRange(p)
+		check(err == nil, "rate_limit.trusted_proxies: %q is not an IP address or CIDR range", p)
+	}
+	check(cfg.RateLimit.IdleTimeout >= time.Second, "rate_limit.idle_timeout must be at least 1s")
+	switch cfg.RateLimit.Store {
+	case limiterStoreMemory:
+	case limiterStoreDatabase:
+		// Windows older than the idle timeout are deleted; a longer window
+		// would lose the previous one it is weighted against.
+		for _, c := range rateClasses {
+			if c.rule.RequestsPerSecond > 0 {
+				w := slidingWindow(c.rule)
+				check(2*w <= cfg.RateLimit.IdleTimeout,
+					"rate_limit.%s: window of %v (burst / requests_per_second) must be at most half of idle_timeout with the database store", c.name, w)
+			}
+		}
+	default:
+		check(false, "rate_limit.store must be memory or database, got %q", cfg.RateLimit.Store)
+	}
+
+	db := cfg.Database
+	switch db.Driver {
+	case store.DialectPostgres:
+		check(db.URL != "", "database.url (DATABASE_URL) is required for the postgres driver")
+	case store.DialectSQLite:
+		check(db.SQLitePath != "", "database.sqlite_path is required for the sqlite driver")
+	default:
+		check(false, "database.driver must be postgres or sqlite, got %q", db.Driver)
+	}
+	check(db.MaxOpenConns >= 0, "database.max_open_conns must not be negative")
+	check(db.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check(db.MaxOpenConns == 0 || db.MaxIdleConns <= db.MaxOpenConns,
+		"database.max_idle_conns (%d) exceeds max_open_conns (%d)", db.MaxIdleConns, db.MaxOpenConns)
+	check(db.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+
+	ws := cfg.WebSocket
+	check(ws.MaxMessageBytes > 0, "websocket.max_message_bytes must be positive")
+	check(ws.SendBufferSize > 0, "websocket.send_buffer_size must be positive")
+	check(ws.PongWait >= time.Second, "websocket.pong_wait must be at least 1s")
+	check(ws.MaxConnectionsPerUser >= 0, "websocket.max_connections_per_user must not be negative")
+	for _, channelType := range []string{"DIRECT", "GROUP"} {
+		limits, ok := ws.Flood.ChannelTypes[channelType]
+		check(ok, "websocket.flood.channel_types.%s is required", channelType)
+		if !ok {
+			continue
+		}
+		kinds := []string{"message", "subscribe", "typing"}
+		for i, fl := range []FrameLimit{limits.Message, limits.Subscribe, limits.Typing} {
+			for j, rule := range []RateLimitRule{fl.PerConnection, fl.PerUser} {
+				check(rule.RequestsPerSecond > 0 && rule.Burst >= 1,
+					"websocket.flood.channel_types.%s.%s.%s needs a positive rate and a burst of at least 1",
+					channelType, kinds[i], []string{"per_connection", "per_user"}[j])
+			}
+		}
+	}
+	check(ws.Flood.StrikesBeforeMute >= 1, "websocket.flood.strikes_before_mute must be at least 1")
+	check(ws.Flood.StrikeWindow > 0, "websocket.flood.strike_window must be positive")
+	check(ws.Flood.MuteDuration > 0, "websocket.flood.mute_duration must be positive")
+	check(ws.Flood.MaxMutes >= 0, "websocket.flood.max_mutes must not be negative")
+
+	check(cfg.Uploads.Dir != "", "uploads.dir is required")
+	check(cfg.Uploads.MaxBytes > 0, "uploads.max_bytes must be positive")
+
+	check(cfg.Shutdown.Timeout > 0, "shutdown.timeout must be positive")
+	check(cfg.Shutdown.ReconnectAfter >= 0, "shutdown.reconnect_after must not be negative")
+	check(cfg.Shutdown.ReconnectJitter >= 0, "shutdown.reconnect_jitter must not be negative")
+
+	_, err := newLogger(cfg.Log, io.Discard)
+	check(err == nil, "%v", err)
+
+	tr := cfg.Tracing
+	switch tr.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if tr.OTLPEndpoint != "" {
+			u, err := url.Parse(tr.OTLPEndpoint)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"tracing.otlp_endpoint: %q is not a URL like http://localhost:4318", tr.OTLPEndpoint)
+		}
+	case "file":
+		check(tr.File != "", "tracing.file is required for the file exporter")
+	default:
+		check(false, "tracing.exporter must be none, otlp, stdout or file, got %q", tr.Exporter)
+	}
+	check(tr.SampleRatio >= 0 && tr.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	check(tr.ServiceName != "", "tracing.service_name is required")
+
+	check(cfg.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping blanks.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigExamplesMatchDefaults(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/chat")
+	want := defaultConfig()
+	want.Database.URL = "postgres://localhost/chat"
+	want.RateLimit.TrustedProxies = []string{}
+
+	for _, file := range []string{"config.example.yaml", "config.example.toml"} {
+		cfg, _, err := LoadConfig([]string{"-config", file})
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s loads as\n%+v\nwant the defaults\n%+v", file, cfg, want)
+		}
+	}
+}
+
+func TestConfigTOMLLayering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.toml")
+	err := os.WriteFile(path, []byte(`
+admin_token = "from-file"
+allowed_origins = ["https://chat.example.com"]
+
+[database]
+driver = "sqlite"
+sqlite_path = "other.db"
+
+[websocket]
+pong_wait = "90s"
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	cfg, _, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AdminToken != "from-file" || cfg.Database.SQLitePath != "other.db" ||
+		cfg.WebSocket.PongWait != 90*time.Second || cfg.AllowedOrigins[0] != "https://chat.example.com" {
+		t.Errorf("file settings not applied: %+v", cfg)
+	}
+	if cfg.WebSocket.SendBufferSize != 256 {
+		t.Errorf("unset send_buffer_size = %d, want the default", cfg.WebSocket.SendBufferSize)
+	}
+
+	t.Setenv("ADMIN_TOKEN", "from-env")
+	if cfg, _, err = LoadConfig(nil); err != nil || cfg.AdminToken != "from-env" {
+		t.Errorf("ADMIN_TOKEN over the file: %q, %v", cfg.AdminToken, err)
+	}
+
+	if err := os.WriteFile(path, []byte("[websocket]\npong_wait = \"soon\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadConfig(nil); err == nil {
+		t.Error("an invalid duration in a TOML file was accepted")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMessageBroadcastToChannelMembers(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	carol := ts.createUser(t, "carol")
+	channel := ts.createChannel(t, "GROUP", "general", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+	carolWS := ts.dial(t, carol)
+
+	aliceWS.sendMessage(t, channel, "hello bob")
+
+	for _, ws := range []*wsClient{bobWS, aliceWS} {
+		got := ws.nextMessage(t)
+		if got.ChannelID != channel || got.SenderID != alice || got.Content != "hello bob" || got.ID == 0 {
+			t.Errorf("user %d received %+v", ws.userID, got)
+		}
+	}
+	carolWS.expectNoMessage(t, 100*time.Millisecond)
+
+	var history []models.Message
+	ts.do(t, "GET", fmt.Sprintf("/fetch_messages?channel_id=%d", channel), nil, &history)
+	if len(history) != 1 || history[0].Content != "hello bob" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestSubscribeAfterJoiningChannel(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	carol := ts.createUser(t, "carol")
+	channel := ts.createChannel(t, "GROUP", "general", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	carolWS := ts.dial(t, carol)
+	if carolWS.subscribed(channel) {
+		t.Fatal("carol auto-subscribed to a channel she is not in")
+	}
+
+	ts.do(t, "POST", fmt.Sprintf("/channels/%d/members", channel), map[string]int{"user_id": carol}, nil)
+	carolWS.subscribe(t, channel)
+
+	aliceWS.sendMessage(t, channel, "welcome carol")
+	if got := carolWS.nextMessage(t); got.Content != "welcome carol" {
+		t.Errorf("carol received %+v", got)
+	}
+}
+
+func TestSubscribeRejectsNonMembers(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	mallory := ts.createUser(t, "mallory")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+	own := ts.createChannel(t, "DIRECT", "", mallory, bob)
+
+	aliceWS := ts.dial(t, alice)
+	malloryWS := ts.dial(t, mallory)
+
+	// Frames are handled in order, so once the later unsubscribe has been
+	// applied the rejected subscribe has been processed too.
+	malloryWS.send(t, models.WSIncoming{Type: "subscribe", ChannelID: channel})
+	malloryWS.send(t, models.WSIncoming{Type: "unsubscribe", ChannelID: own})
+	waitFor(t, "mallory unsubscribed", func() bool { return !malloryWS.subscribed(own) })
+	if malloryWS.subscribed(channel) {
+		t.Fatal("non-member was subscribed")
+	}
+
+	aliceWS.sendMessage(t, channel, "private")
+	malloryWS.expectNoMessage(t, 100*time.Millisecond)
+}
+
+func TestUnsubscribeStopsDelivery(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+
+	bobWS.send(t, models.WSIncoming{Type: "unsubscribe", ChannelID: channel})
+	waitFor(t, "bob unsubscribed", func() bool { return !bobWS.subscribed(channel) })
+
+	aliceWS.sendMessage(t, channel, "anyone there?")
+	aliceWS.nextMessage(t)
+	bobWS.expectNoMessage(t, 100*time.Millisecond)
+}
+
+func TestMessageEchoedToSendersOtherDevices(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	phone := ts.dial(t, alice)
+	laptop := ts.dial(t, alice)
+	// Even a device that left the channel sees what its user sent.
+	laptop.send(t, models.WSIncoming{Type: "unsubscribe", ChannelID: channel})
+	waitFor(t, "laptop unsubscribed", func() bool { return !laptop.subscribed(channel) })
+
+	phone.sendMessage(t, channel, "sent from my phone")
+	if got := laptop.nextMessage(t); got.Content != "sent from my phone" || got.SenderID != alice {
+		t.Errorf("laptop received %+v", got)
+	}
+}
+
+// failInsert refuses to store messages with the given text.
+type failInsert struct {
+	store.Store
+	text string
+}
+
+func (s failInsert) InsertMessage(ctx context.Context, channelID, senderID int, content string) (models.Message, error) {
+	if content == s.text {
+		return models.Message{}, errors.New("disk full")
+	}
+	return s.Store.InsertMessage(ctx, channelID, senderID, content)
+}
+
+func TestMessageRefusedIsNotBroadcast(t *testing.T) {
+	ts := newTestServerWithStore(t, failInsert{Store: store.NewMemory(), text: "lost"})
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	mallory := ts.createUser(t, "mallory")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+	malloryWS := ts.dial(t, mallory)
+
+	malloryWS.sendMessage(t, channel, "let me in")
+	var refused models.WSError
+	malloryWS.next(t, "error", &refused)
+	if refused.Code != models.ErrCodeForbidden || refused.Frame != "message" {
+		t.Errorf("non-member message: %+v, want a forbidden error", refused)
+	}
+
+	aliceWS.sendMessage(t, channel, "lost")
+	var failed models.WSError
+	aliceWS.next(t, "error", &failed)
+	if failed.Code != models.ErrCodeInternal || failed.Frame != "message" {
+		t.Errorf("unstored message: %+v, want an internal error", failed)
+	}
+
+	// Neither reached bob, and neither was stored.
+	aliceWS.sendMessage(t, channel, "kept")
+	if got := bobWS.nextMessage(t); got.Content != "kept" || got.ID == 0 {
+		t.Errorf("bob got %+v, want only the stored message", got)
+	}
+	var history []models.Message
+	ts.do(t, "GET", fmt.Sprintf("/api/v1/channels/%d/messages?user_id=%d", channel, alice), nil, &history)
+	if len(history) != 1 || history[0].Content != "kept" {
+		t.Errorf("history = %+v, want only the stored message", history)
+	}
+}
+
+func TestSearchFindsSentMessages(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	aliceWS.sendMessage(t, channel, "the deploy is done")
+	aliceWS.sendMessage(t, channel, "lunch?")
+	aliceWS.nextMessage(t)
+	aliceWS.nextMessage(t)
+
+	var page models.SearchPage
+	ts.do(t, "GET", fmt.Sprintf("/search?user_id=%d&q=deploy", bob), nil, &page)
+	if len(page.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(page.Results))
+	}
+	if got := page.Results[0].Snippet; got != "the <mark>deploy</mark> is done" {
+		t.Errorf("snippet = %q", got)
+	}
+}
+
+func TestWebSocketRejectsForeignOrigins(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + fmt.Sprintf("/ws?user_id=%d", alice)
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil {
+		t.Fatal("upgrade from a foreign origin succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got %v, want 403", resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://localhost:3000"}})
+	if err != nil {
+		t.Fatalf("upgrade from an allowed origin: %v", err)
+	}
+	conn.Close()
+
+	if got := ts.origins.Violations().WebSocket; got != 1 {
+		t.Errorf("websocket violations = %d, want 1", got)
+	}
+}
+
+func TestCSRFProtectsStateChangingRequests(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"foreign origin", http.Header{"Origin": {"https://evil.example"}}, http.StatusForbidden},
+		{"cross-site fetch", http.Header{"Sec-Fetch-Site": {"cross-site"}, "Origin": {"https://evil.example"}}, http.StatusForbidden},
+		{"allowed origin", http.Header{"Sec-Fetch-Site": {"same-site"}, "Origin": {"http://localhost:3000"}}, http.StatusOK},
+		{"same origin", http.Header{"Sec-Fetch-Site": {"same-origin"}}, http.StatusOK},
+		// Curl, bots and SDKs send no browser headers at all.
+		{"non-browser client", http.Header{}, http.StatusOK},
+	}
+	for i, tt := range tests {
+		body := fmt.Sprintf(`{"username":"user%d"}`, i)
+		req, err := http.NewRequest("POST", ts.URL+"/users", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = tt.header
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+	if got := ts.origins.Violations().CSRF; got != 2 {
+		t.Errorf("csrf violations = %d, want 2", got)
+	}
+}
+
+func TestRequestIDEchoedOrGenerated(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name, sent string
+		keep       bool
+	}{
+		{"upstream id", "req-123.abc", true},
+		{"missing", "", false},
+		{"unsafe", "bad id \"quoted\"", false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", ts.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.sent != "" {
+			req.Header.Set("X-Request-ID", tt.sent)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got := resp.Header.Get("X-Request-ID")
+		if tt.keep && got != tt.sent {
+			t.Errorf("%s: X-Request-ID = %q, want %q", tt.name, got, tt.sent)
+		}
+		if !tt.keep && (got == "" || got == tt.sent) {
+			t.Errorf("%s: X-Request-ID = %q, want a generated id", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"chat-app/backend/models"
+)
+
+// Frame kinds with separate budgets.
+const (
+	frameMessage   = "message"
+	frameSubscribe = "subscribe" // also unsubscribe, set_status and unknown frames
+	frameTyping    = "typing"
+)
+
+// frameUnknown is the kind label of rejected frames that aren't JSON, whose
+// type is unknown.
+const frameUnknown = "unknown"
+
+// frameKind maps a frame type to the budget it is charged to.
+func frameKind(frameType string) string {
+	switch frameType {
+	case frameMessage, frameTyping:
+		return frameType
+	default:
+		return frameSubscribe
+	}
+}
+
+// defaultChannelType is used for frames about channels whose type the
+// connection doesn't know, and for frames without a channel.
+const defaultChannelType = "GROUP"
+
+// FrameLimiter holds the WebSocket frame buckets of every connection and
+// every user, so a user opening more tabs doesn't get more throughput.
+// Frame budgets stay in memory even with a shared HTTP limiter store: a
+// database round trip per frame would cost more than the flood it stops.
+type FrameLimiter struct {
+	buckets *bucketSet
+	rules   map[string]RateLimitRule
+	cfg     FloodConfig
+}
+
+// NewFrameLimiter builds the buckets from the configuration. Buckets of
+// closed connections and inactive users are evicted once idle.
+func NewFrameLimiter(cfg FloodConfig) *FrameLimiter {
+	rules := make(map[string]RateLimitRule)
+	for channelType, limits := range cfg.ChannelTypes {
+		for kind, fl := range map[string]FrameLimit{
+			frameMessage:   limits.Message,
+			frameSubscribe: limits.Subscribe,
+			frameTyping:    limits.Typing,
+		} {
+			rules[frameClass(kind, channelType, "conn")] = fl.PerConnection
+			rules[frameClass(kind, channelType, "user")] = fl.PerUser
+		}
+	}
+	return &FrameLimiter{buckets: newBucketSet(10 * time.Minute), rules: rules, cfg: cfg}
+}
+
+func frameClass(kind, channelType, scope string) string {
+	return kind + "/" + channelType + "/" + scope
+}
+
+// Allow charges a frame to the connection's bucket and, if that has room,
+// to the user's.
+func (fl *FrameLimiter) Allow(c *Client, kind, channelType string, now time.Time) rateDecision {
+	if _, ok := fl.cfg.ChannelTypes[channelType]; !ok {
+		channelType = defaultChannelType
+	}
+	class := frameClass(kind, channelType, "conn")
+	d := fl.buckets.Take(class+"|"+c.id, fl.rules[class], now)
+	if !d.Allowed {
+		return d
+	}
+	class = frameClass(kind, channelType, "user")
+	return fl.buckets.Take(class+"|"+strconv.Itoa(c.userID), fl.rules[class], now)
+}
+
+// floodState tracks a connection's violations. Only ReadPump touches it.
+type floodState struct {
+	windowStart time.Time
+	strikes     int
+	mutedUntil  time.Time
+	mutes       int
+}
+
+// admit applies the frame budgets to an incoming frame. A refused frame is
+// answered with an error frame; repeated violations mute the connection and
+// finally close it.
+func (c *Client) admit(incoming models.WSIncoming, now time.Time) bool {
+	kind := frameKind(incoming.Type)
+	if now.Before(c.flood.mutedUntil) {
+		wsFramesRejected.WithLabelValues(kind, "muted").Inc()
+		c.sendError("muted", "Too many frames, muted", incoming.Type, c.flood.mutedUntil.Sub(now))
+		return false
+	}
+
+	channelType, ok := c.channelTypes[incoming.ChannelID]
+	if !ok {
+		channelType = defaultChannelType
+	}
+	d := c.frames.Allow(c, kind, channelType, now)
+	if d.Allowed {
+		return true
+	}
+	wsFramesRejected.WithLabelValues(kind, "rate_limited").Inc()
+
+	cfg := c.frames.cfg
+	if now.Sub(c.flood.windowStart) > cfg.StrikeWindow {
+		c.flood.windowStart, c.flood.strikes = now, 0
+	}
+	c.flood.strikes++
+	if c.flood.strikes < cfg.StrikesBeforeMute {
+		c.sendError("rate_limited", "Too many "+kind+" frames", incoming.Type, d.RetryAfter)
+		return false
+	}
+
+	c.flood.strikes = 0
+	c.flood.mutes++
+	if c.flood.mutes > cfg.MaxMutes {
+		c.log.Warn("flooding connection disconnected", "mutes", c.flood.mutes-1)
+		wsFloodDisconnects.Inc()
+		c.sendError("flood_disconnect", "Too many frames, disconnecting", incoming.Type, 0)
+		c.hub.Disconnect(c.userID, c.id)
+		// Frames still in flight before the socket closes are dropped quietly.
+		c.flood.mutedUntil = now.Add(cfg.MuteDuration)
+		return false
+	}
+	c.flood.mutedUntil = now.Add(cfg.MuteDuration)
+	c.log.Warn("flooding connection muted", "until", c.flood.mutedUntil, "mutes", c.flood.mutes)
+	c.sendError("muted", "Too many frames, muted", incoming.Type, cfg.MuteDuration)
+	return false
+}
+
+// sendError queues an error frame for this connection.
+func (c *Client) sendError(code, message, frame string, retryAfter time.Duration) {
+	data, _ := json.Marshal(models.WSError{
+		Type:         "error",
+		Code:         code,
+		Message:      message,
+		Frame:        frame,
+		RetryAfterMs: retryAfter.Milliseconds(),
+	})
+	c.hub.Send(c, data)
+}
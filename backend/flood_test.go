@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+func TestTypingReachesOtherMembersOnly(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "GROUP", "general", alice, bob)
+	aliceWS := ts.dial(t, alice)
+	alicePhone := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+
+	aliceWS.send(t, models.WSIncoming{Type: "typing", ChannelID: channel})
+	var typing models.WSTyping
+	bobWS.next(t, "typing", &typing)
+	if typing.ChannelID != channel || typing.UserID != alice {
+		t.Errorf("typing = %+v, want alice in channel %d", typing, channel)
+	}
+
+	// Alice's devices don't hear about her own typing. A message sent
+	// afterwards arrives first, proving nothing else was queued.
+	bobWS.sendMessage(t, channel, "hi")
+	for _, ws := range []*wsClient{aliceWS, alicePhone} {
+		var head struct{ Type string }
+		ws.next(t, "message", &head)
+	}
+}
+
+// floodConfig allows two messages per connection and then refills so slowly
+// that every further message is a violation.
+func floodConfig() Config {
+	cfg := defaultConfig()
+	flood := &cfg.WebSocket.Flood
+	limits := defaultFrameLimits()
+	limits.Message = FrameLimit{
+		PerConnection: RateLimitRule{RequestsPerSecond: 0.001, Burst: 2},
+		PerUser:       RateLimitRule{RequestsPerSecond: 0.001, Burst: 3},
+	}
+	flood.ChannelTypes = map[string]FrameLimits{"DIRECT": limits, "GROUP": limits}
+	flood.StrikesBeforeMute = 2
+	flood.MuteDuration = 100 * time.Millisecond
+	flood.MaxMutes = 1
+	return cfg
+}
+
+func TestFloodingEscalatesToMuteThenDisconnect(t *testing.T) {
+	ts := newTestServerWithConfig(t, floodConfig(), store.NewMemory())
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "GROUP", "general", alice, bob)
+	ws := ts.dial(t, alice)
+
+	expectError := func(code string) {
+		t.Helper()
+		var e models.WSError
+		ws.next(t, "error", &e)
+		if e.Code != code || e.Frame != "message" {
+			t.Fatalf("error frame = %+v, want %s for a message frame", e, code)
+		}
+	}
+
+	ws.sendMessage(t, channel, "one")
+	ws.sendMessage(t, channel, "two")
+	ws.nextMessage(t)
+	ws.nextMessage(t)
+
+	ws.sendMessage(t, channel, "three")
+	expectError("rate_limited")
+	ws.sendMessage(t, channel, "four")
+	expectError("muted")
+	ws.sendMessage(t, channel, "five")
+	expectError("muted")
+
+	time.Sleep(150 * time.Millisecond)
+	ws.sendMessage(t, channel, "six")
+	expectError("rate_limited")
+	ws.sendMessage(t, channel, "seven")
+	expectError("flood_disconnect")
+	ws.expectClosed(t)
+}
+
+func TestFrameBudgetSharedAcrossUserConnections(t *testing.T) {
+	ts := newTestServerWithConfig(t, floodConfig(), store.NewMemory())
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "GROUP", "general", alice, bob)
+	laptop := ts.dial(t, alice)
+	phone := ts.dial(t, alice)
+
+	laptop.sendMessage(t, channel, "one")
+	laptop.sendMessage(t, channel, "two")
+	phone.sendMessage(t, channel, "three")
+	for i := 0; i < 3; i++ {
+		phone.nextMessage(t)
+	}
+
+	// The phone's own bucket has room, but alice's is empty.
+	phone.sendMessage(t, channel, "four")
+	var e models.WSError
+	phone.next(t, "error", &e)
+	if e.Code != "rate_limited" {
+		t.Errorf("error frame = %+v, want rate_limited", e)
+	}
+}
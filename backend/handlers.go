@@ -1,298 +1,445 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// NeonDB is a simple wrapper implementing DBInterface for the client.
-type NeonDB struct {
-	DB *sql.DB
-}
-
-func (n *NeonDB) InsertMessage(channelID, senderID int, content string) error {
-	return InsertMessage(n.DB, channelID, senderID, content)
-}
-
-func (n *NeonDB) CheckMembership(channelID, userID int) (bool, error) {
-	return CheckChannelMembership(n.DB, channelID, userID)
-}
-
-// Upgrader handles HTTP -> WebSocket upgrade.
-var Upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// In production, you'd restrict origins as needed.
-		return true
-	},
-}
-
 // ServeWS automatically subscribes the user to all their channels when they connect.
-func ServeWS(h *Hub, db *sql.DB) http.HandlerFunc {
+func ServeWS(h *Hub, db store.Store, limits WebSocketConfig, origins *OriginPolicy, frames *FrameLimiter) http.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: origins.CheckWebSocketOrigin}
 	return func(w http.ResponseWriter, r *http.Request) {
-		userIDStr := r.URL.Query().Get("user_id")
-		if userIDStr == "" {
-			http.Error(w, "Missing user_id query param", http.StatusBadRequest)
+		userID, ok := idParam(w, r, "user_id")
+		if !ok {
+			return
+		}
+
+		// Only existing users connect, and not those disabled with chatctl.
+		user, err := db.GetUser(r.Context(), userID)
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "User not found")
 			return
 		}
-		userID, err := strconv.Atoi(userIDStr)
 		if err != nil {
-			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			loggerFrom(r.Context()).Error("GetUser failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user.DisabledAt != nil {
+			writeError(w, http.StatusForbidden, "User is disabled")
+			return
+		}
+
+		if limits.MaxConnectionsPerUser > 0 && len(h.Sessions(userID)) >= limits.MaxConnectionsPerUser {
+			writeError(w, http.StatusTooManyRequests, "Too many connections")
 			return
 		}
 
-		conn, err := Upgrader.Upgrade(w, r, nil)
+		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Println("Upgrade error:", err)
+			loggerFrom(r.Context()).Warn("websocket upgrade failed", "err", err)
 			return
 		}
 
 		// Build the client object
+		sessionID := newSessionID()
 		client := &Client{
 			hub:         h,
 			conn:        conn,
-			db:          &NeonDB{DB: db},
+			db:          db,
+			id:          sessionID,
 			userID:      userID,
+			remoteAddr:  r.RemoteAddr,
+			userAgent:   r.UserAgent(),
+			connectedAt: time.Now().UTC(),
+			send:        make(chan []byte, limits.SendBufferSize),
+			writerDone:  make(chan struct{}),
+			limits:      limits,
+			log:         loggerFrom(r.Context()).With("conn_id", sessionID, "user_id", userID),
+			upgradeSpan: trace.SpanContextFromContext(r.Context()),
+			frames:      frames,
 			messageType: websocket.TextMessage,
 		}
 
 		// Fetch all channels for this user and auto-subscribe in the Hub
-		channels, err := FetchUserChannels(db, userID)
+		channels, err := db.FetchUserChannels(r.Context(), userID)
 		if err != nil {
-			log.Println("Failed to fetch user channels:", err)
-		} else {
-			for _, ch := range channels {
-				h.subscribe <- Subscription{
-					ChannelID: ch.ID,
-					Client:    client,
-				}
-			}
+			client.log.Error("FetchUserChannels failed", "err", err)
+		}
+		client.channelTypes = channelTypeMap(channels)
+		reg := Registration{Client: client}
+		for _, ch := range channels {
+			reg.ChannelIDs = append(reg.ChannelIDs, ch.ID)
+		}
+		if !h.Register(reg) {
+			// The server is shutting down.
+			conn.Close()
+			return
 		}
 
-		// Start reading messages in a separate goroutine
+		// Start the write and read pumps in separate goroutines
+		go client.WritePump()
 		go client.ReadPump()
 
-		log.Printf("User %d connected. Auto-subscribed to %d channel(s)\n", userID, len(channels))
+		client.log.Info("websocket connected", "channels", len(channels), "remote", r.RemoteAddr)
 	}
 }
 
-// HandleCreateUser (POST /users) creates a new user in the database.
-func HandleCreateUser(db *sql.DB) http.HandlerFunc {
+// HandleCreateUser (POST /api/v1/users) creates a new user in the database.
+func HandleCreateUser(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		var body struct {
-			Username string `json:"username"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		var body models.CreateUserRequest
+		if !decodeJSON(w, r, &body) || !validRequest(w, &body) {
 			return
 		}
-		userID, err := CreateUser(db, body.Username)
+		userID, err := db.CreateUser(r.Context(), body.Username)
 		if err != nil {
-			log.Println("CreateUser error:", err)
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("CreateUser failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-		resp := map[string]interface{}{
-			"user_id": userID,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+		writeJSON(w, http.StatusOK, models.CreateUserResponse{UserID: userID})
 	}
 }
 
-func HandleCheckIfUserExists(db *sql.DB) http.HandlerFunc {
+// HandleCheckIfUserExists (GET /api/v1/users?username=alice) looks a user up by name.
+func HandleCheckIfUserExists(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 
 		username := r.URL.Query().Get("username")
 		if username == "" {
-			http.Error(w, "Username is required", http.StatusBadRequest)
+			writeErrorDetails(w, http.StatusBadRequest, "", "Username is required", map[string]string{"username": "is required"})
 			return
 		}
 
-		var id int
-		err := db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
-		if err == sql.ErrNoRows {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]bool{"exists": false})
+		user, err := db.GetUserByUsername(r.Context(), username)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSON(w, http.StatusOK, models.UserLookup{Exists: false})
 			return
 		}
 		if err != nil {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("GetUserByUsername failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"exists": true,
-			"id":     id,
-		})
+		writeJSON(w, http.StatusOK, models.UserLookup{Exists: true, ID: user.ID})
 	}
 }
 
-// HandleGetMyChannels (GET /my_channels?user_id=123) returns channels that user belongs to.
-func HandleGetMyChannels(db *sql.DB) http.HandlerFunc {
+// HandleGetMyChannels (GET /api/v1/users/{user_id}/channels) returns channels that user belongs to.
+func HandleGetMyChannels(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		userIDStr := r.URL.Query().Get("user_id")
-		if userIDStr == "" {
-			http.Error(w, "Missing user_id", http.StatusBadRequest)
+		userID, ok := idParam(w, r, "user_id")
+		if !ok {
 			return
 		}
-		userID, err := strconv.Atoi(userIDStr)
+		channels, err := db.FetchUserChannels(r.Context(), userID)
 		if err != nil {
-			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			loggerFrom(r.Context()).Error("FetchUserChannels failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-		channels, err := FetchUserChannels(db, userID)
-		if err != nil {
-			log.Println("FetchUserChannels error:", err)
-			http.Error(w, "Database error", http.StatusInternalServerError)
-			return
+		if channels == nil {
+			channels = []models.Channel{}
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(channels)
+		writeJSON(w, http.StatusOK, channels)
 	}
 }
 
-// HandleCreateChannel (POST /create_channel) for creating DIRECT or GROUP channels.
-func HandleCreateChannel(db *sql.DB) http.HandlerFunc {
+// HandleCreateChannel (POST /api/v1/channels) for creating DIRECT or GROUP channels.
+func HandleCreateChannel(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 
 		// e.g. { "channel_type":"DIRECT", "channel_name":"", "user_ids":[1,2] }
-		var req struct {
-			ChannelType string `json:"channel_type"`
-			ChannelName string `json:"channel_name"`
-			UserIDs     []int  `json:"user_ids"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-			return
-		}
-		if strings.ToUpper(req.ChannelType) == "DIRECT" && len(req.UserIDs) != 2 {
-			http.Error(w, "DIRECT channel requires exactly two user IDs", http.StatusBadRequest)
+		var req models.CreateChannelRequest
+		if !decodeJSON(w, r, &req) {
 			return
 		}
-		if strings.ToUpper(req.ChannelType) == "GROUP" && (req.ChannelName == "" || len(req.UserIDs) < 2) {
-			http.Error(w, "GROUP channel requires a channel_name and at least 2 user IDs", http.StatusBadRequest)
+		req.ChannelType = strings.ToUpper(req.ChannelType)
+		if !validRequest(w, &req) {
 			return
 		}
 
-		channelType := strings.ToUpper(req.ChannelType)
 		channelName := req.ChannelName
-		if channelType == "DIRECT" {
+		if req.ChannelType == "DIRECT" {
 			channelName = "direct"
 		}
 
-		channelID, err := CreateChannel(db, channelName, channelType)
+		channelID, err := db.CreateChannel(r.Context(), channelName, req.ChannelType)
 		if err != nil {
-			log.Println("CreateChannel error:", err)
-			http.Error(w, "DB error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("CreateChannel failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-		if err := AddChannelMembers(db, channelID, req.UserIDs); err != nil {
-			log.Println("AddChannelMembers error:", err)
-			http.Error(w, "DB error", http.StatusInternalServerError)
+		if err := db.AddChannelMembers(r.Context(), channelID, req.UserIDs); err != nil {
+			loggerFrom(r.Context()).Error("AddChannelMembers failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
+		writeJSON(w, http.StatusOK, models.CreateChannelResponse{ChannelID: channelID})
+	}
+}
+
+// Page sizes of GET /api/v1/channels/{channel_id}/messages when paging.
+const (
+	defaultMessagePage = 50
+	maxMessagePage     = 200
+)
 
-		resp := map[string]interface{}{
-			"channel_id": channelID,
+// HandleFetchMessages (GET /api/v1/channels/{channel_id}/messages?before=&after=&limit=)
+// returns messages for a channel in chronological order. Without paging parameters it
+// returns the whole history; with them, the page described by models.MessagePage.
+func HandleFetchMessages(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+		channelID, ok := idParam(w, r, "channel_id")
+		if !ok {
+			return
+		}
+		var page models.MessagePage
+		for _, p := range []struct {
+			name string
+			dst  *int
+		}{{"before", &page.BeforeID}, {"after", &page.AfterID}, {"limit", &page.Limit}} {
+			if !queryInt(w, r, p.name, p.dst) {
+				return
+			}
+		}
+		if page.BeforeID > 0 && page.AfterID > 0 {
+			writeErrorDetails(w, http.StatusBadRequest, "", "Use before or after, not both",
+				map[string]string{"after": "cannot be combined with before"})
+			return
+		}
+
+		var messages []models.Message
+		var err error
+		if page == (models.MessagePage{}) {
+			messages, err = db.FetchChannelMessages(r.Context(), channelID)
+		} else {
+			if page.Limit == 0 {
+				page.Limit = defaultMessagePage
+			}
+			page.Limit = min(page.Limit, maxMessagePage)
+			messages, err = db.FetchMessagesPage(r.Context(), channelID, page)
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Error("FetchChannelMessages failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if messages == nil {
+			messages = []models.Message{}
+		}
+		writeJSON(w, http.StatusOK, messages)
 	}
 }
 
-// HandleFetchMessages (GET /fetch_messages?channel_id=123) returns messages for a channel.
-func HandleFetchMessages(db *sql.DB) http.HandlerFunc {
+// HandleSearch (GET /api/v1/search?user_id=1&q=deploy+from:alice&limit=20&offset=0) searches
+// messages in the channels the user belongs to.
+func HandleSearch(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		channelIDStr := r.URL.Query().Get("channel_id")
-		if channelIDStr == "" {
-			http.Error(w, "Missing channel_id", http.StatusBadRequest)
+		userID, ok := idParam(w, r, "user_id")
+		if !ok {
 			return
 		}
-		channelID, err := strconv.Atoi(channelIDStr)
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			writeErrorDetails(w, http.StatusBadRequest, "", "Missing q", map[string]string{"q": "is required"})
+			return
+		}
+		query, err := ParseSearchQuery(q)
 		if err != nil {
-			http.Error(w, "Invalid channel_id", http.StatusBadRequest)
+			writeErrorDetails(w, http.StatusBadRequest, "", err.Error(), map[string]string{"q": err.Error()})
 			return
 		}
-		messages, err := FetchChannelMessages(db, channelID)
+
+		query.Limit = defaultSearchLimit
+		if s := r.URL.Query().Get("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil || limit < 1 {
+				writeErrorDetails(w, http.StatusBadRequest, "", "Invalid limit", map[string]string{"limit": "must be a positive integer"})
+				return
+			}
+			query.Limit = min(limit, maxSearchLimit)
+		}
+		if s := r.URL.Query().Get("offset"); s != "" {
+			offset, err := strconv.Atoi(s)
+			if err != nil || offset < 0 {
+				writeErrorDetails(w, http.StatusBadRequest, "", "Invalid offset", map[string]string{"offset": "must be a non-negative integer"})
+				return
+			}
+			query.Offset = offset
+		}
+
+		results, err := db.SearchMessages(r.Context(), userID, query)
 		if err != nil {
-			log.Println("FetchChannelMessages error:", err)
-			http.Error(w, "DB error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("SearchMessages failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(messages)
+
+		page := models.SearchPage{Results: results}
+		if len(results) > query.Limit {
+			page.Results = results[:query.Limit]
+			next := query.Offset + query.Limit
+			page.NextOffset = &next
+		}
+		if page.Results == nil {
+			page.Results = []models.SearchResult{}
+		}
+		writeJSON(w, http.StatusOK, page)
 	}
 }
 
-// HandleAddMemberToChannel (POST /channels/{channel_id}/members) - add user to a channel.
-func HandleAddMemberToChannel(db *sql.DB) http.HandlerFunc {
+// HandleAddMemberToChannel (POST /api/v1/channels/{channel_id}/members) - add user to a channel.
+func HandleAddMemberToChannel(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		vars := mux.Vars(r)
-		channelIDStr, ok := vars["channel_id"]
+		channelID, ok := idParam(w, r, "channel_id")
 		if !ok {
-			http.Error(w, "Missing channel_id in path", http.StatusBadRequest)
 			return
 		}
-		channelID, err := strconv.Atoi(channelIDStr)
-		if err != nil {
-			http.Error(w, "Invalid channel_id", http.StatusBadRequest)
+
+		var body models.AddMemberRequest
+		if !decodeJSON(w, r, &body) || !validRequest(w, &body) {
 			return
 		}
 
-		var body struct {
-			UserID int `json:"user_id"`
+		if err := db.AddChannelMembers(r.Context(), channelID, []int{body.UserID}); err != nil {
+			loggerFrom(r.Context()).Error("AddChannelMembers failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
 		}
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeJSON(w, http.StatusOK, models.AddMemberResponse{
+			Message:   "User added to channel",
+			ChannelID: channelID,
+			AddedUser: body.UserID,
+		})
+	}
+}
+
+// HandleGetPresence (GET /api/v1/presence?user_ids=1,2,3) returns the presence of each user.
+// Live status comes from the Hub; offline users fall back to the persisted last_seen_at.
+func HandleGetPresence(h *Hub, db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		idsStr := r.URL.Query().Get("user_ids")
+		if idsStr == "" {
+			writeErrorDetails(w, http.StatusBadRequest, "", "Missing user_ids", map[string]string{"user_ids": "is required"})
 			return
 		}
+		var userIDs []int
+		for _, part := range strings.Split(idsStr, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				writeErrorDetails(w, http.StatusBadRequest, "", "Invalid user_ids", map[string]string{"user_ids": "must be comma-separated integers"})
+				return
+			}
+			userIDs = append(userIDs, id)
+		}
 
-		if err := AddChannelMembers(db, channelID, []int{body.UserID}); err != nil {
-			log.Println("AddChannelMembers error:", err)
-			http.Error(w, "Database error", http.StatusInternalServerError)
+		presences := make([]models.Presence, 0, len(userIDs))
+		var offline []int
+		for _, id := range userIDs {
+			p, _ := h.Presence(id)
+			if p.Status == models.PresenceOffline && p.LastSeenAt == nil {
+				offline = append(offline, id)
+			}
+			presences = append(presences, p)
+		}
+
+		if len(offline) > 0 {
+			seen, err := db.FetchLastSeen(r.Context(), offline)
+			if err != nil {
+				loggerFrom(r.Context()).Error("FetchLastSeen failed", "err", err)
+				writeError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			for i := range presences {
+				if t, ok := seen[presences[i].UserID]; ok && presences[i].LastSeenAt == nil {
+					presences[i].LastSeenAt = &t
+				}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, presences)
+	}
+}
+
+// HandleListSessions (GET /api/v1/admin/users/{user_id}/sessions) lists a user's live connections.
+func HandleListSessions(h *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := idParam(w, r, "user_id")
+		if !ok {
 			return
 		}
-		resp := map[string]interface{}{
-			"message":    "User added to channel",
-			"channel_id": channelID,
-			"added_user": body.UserID,
+		writeJSON(w, http.StatusOK, h.Sessions(userID))
+	}
+}
+
+// HandleListAllSessions (GET /api/v1/admin/sessions) lists every live connection on this server.
+func HandleListAllSessions(h *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.AllSessions())
+	}
+}
+
+// HandleDisconnectSessions (DELETE /api/v1/admin/users/{user_id}/sessions[/{session_id}])
+// force-closes one session of a user, or all of them when no session id is given.
+func HandleDisconnectSessions(h *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := idParam(w, r, "user_id")
+		if !ok {
+			return
+		}
+		sessionID := mux.Vars(r)["session_id"]
+
+		closed := h.Disconnect(userID, sessionID)
+		if sessionID != "" && closed == 0 {
+			writeError(w, http.StatusNotFound, "Session not found")
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+		writeJSON(w, http.StatusOK, models.DisconnectResponse{UserID: userID, Disconnected: closed})
 	}
 }
 
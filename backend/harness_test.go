@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+	"chat-app/backend/store"
+
+	"github.com/gorilla/websocket"
+)
+
+// End-to-end test harness: the real router and Hub served by httptest, on
+// top of the in-memory Store and a temporary upload directory.
+
+// waitTimeout bounds every wait for a frame or a hub state change.
+const waitTimeout = 2 * time.Second
+
+type testServer struct {
+	*httptest.Server
+	db      store.Store
+	hub     *Hub
+	origins *OriginPolicy
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWithStore(t, store.NewMemory())
+}
+
+// newTestServerWithStore lets a test wrap the store, e.g. to slow it down.
+func newTestServerWithStore(t *testing.T, db store.Store) *testServer {
+	t.Helper()
+	return newTestServerWithConfig(t, defaultConfig(), db)
+}
+
+// newTestServerWithConfig runs the server with a modified configuration.
+func newTestServerWithConfig(t *testing.T, cfg Config, db store.Store) *testServer {
+	t.Helper()
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub()
+	go hub.Run()
+	images := NewImageProcessor(db, files, hub, 1)
+	origins := NewOriginPolicy(cfg.AllowedOrigins)
+
+	srv := httptest.NewServer(newRouter(cfg, origins, hub, db, files, images))
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, db: db, hub: hub, origins: origins}
+}
+
+// do sends a request with an optional JSON body and decodes a 2xx JSON
+// response into out. Any other status fails the test.
+func (ts *testServer) do(t *testing.T, method, path string, body, out interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		t.Fatalf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(msg.String()))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+}
+
+func (ts *testServer) createUser(t *testing.T, username string) int {
+	t.Helper()
+	var resp struct {
+		UserID int `json:"user_id"`
+	}
+	ts.do(t, "POST", "/api/v1/users", map[string]string{"username": username}, &resp)
+	return resp.UserID
+}
+
+func (ts *testServer) createChannel(t *testing.T, channelType, name string, userIDs ...int) int {
+	t.Helper()
+	var resp struct {
+		ChannelID int `json:"channel_id"`
+	}
+	ts.do(t, "POST", "/api/v1/channels", map[string]interface{}{
+		"channel_type": channelType,
+		"channel_name": name,
+		"user_ids":     userIDs,
+	}, &resp)
+	return resp.ChannelID
+}
+
+// testConns numbers test connections; the number is sent as the User-Agent
+// so a connection can be found among the hub's sessions.
+var testConns atomic.Int64
+
+// wsClient is a WebSocket connection to the test server.
+type wsClient struct {
+	conn      *websocket.Conn
+	ts        *testServer
+	userID    int
+	userAgent string
+}
+
+// dial connects as userID and waits until the hub has registered the
+// connection, so frames broadcast afterwards are guaranteed to reach it.
+func (ts *testServer) dial(t *testing.T, userID int) *wsClient {
+	t.Helper()
+	ua := fmt.Sprintf("ws-test-%d", testConns.Add(1))
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + fmt.Sprintf("/ws?user_id=%d", userID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"User-Agent": {ua}})
+	if err != nil {
+		t.Fatalf("dial as user %d: %v", userID, err)
+	}
+	c := &wsClient{conn: conn, ts: ts, userID: userID, userAgent: ua}
+	t.Cleanup(c.close)
+	waitFor(t, "connection registered", func() bool {
+		_, ok := c.session()
+		return ok
+	})
+	return c
+}
+
+// session looks this connection up in the hub.
+func (c *wsClient) session() (models.Session, bool) {
+	for _, s := range c.ts.hub.Sessions(c.userID) {
+		if s.UserAgent == c.userAgent {
+			return s, true
+		}
+	}
+	return models.Session{}, false
+}
+
+// subscribed reports whether the hub has this connection in the channel.
+func (c *wsClient) subscribed(channelID int) bool {
+	s, ok := c.session()
+	if !ok {
+		return false
+	}
+	for _, id := range s.Channels {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsClient) close() {
+	c.conn.Close()
+}
+
+// send writes v as a JSON text frame.
+func (c *wsClient) send(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := c.conn.WriteJSON(v); err != nil {
+		t.Fatalf("user %d: write: %v", c.userID, err)
+	}
+}
+
+// subscribe sends a subscribe frame and waits for the hub to apply it.
+func (c *wsClient) subscribe(t *testing.T, channelID int) {
+	t.Helper()
+	c.send(t, models.WSIncoming{Type: "subscribe", ChannelID: channelID})
+	waitFor(t, fmt.Sprintf("user %d subscribed to channel %d", c.userID, channelID), func() bool {
+		return c.subscribed(channelID)
+	})
+}
+
+// sendMessage sends a chat message to a channel.
+func (c *wsClient) sendMessage(t *testing.T, channelID int, text string) {
+	t.Helper()
+	c.send(t, models.WSIncoming{Type: "message", ChannelID: channelID, Text: text})
+}
+
+// next reads frames until one of the given type arrives, skipping others
+// such as presence updates, and decodes it into out.
+func (c *wsClient) next(t *testing.T, frameType string, out interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		c.conn.SetReadDeadline(deadline)
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("user %d: waiting for %q frame: %v", c.userID, frameType, err)
+		}
+		var head struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &head); err != nil {
+			t.Fatalf("user %d: bad frame %s: %v", c.userID, data, err)
+		}
+		if head.Type != frameType {
+			continue
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("user %d: decode %q frame: %v", c.userID, frameType, err)
+		}
+		return
+	}
+}
+
+// nextMessage waits for the next chat message.
+func (c *wsClient) nextMessage(t *testing.T) models.WSOutgoing {
+	t.Helper()
+	var msg models.WSOutgoing
+	c.next(t, "message", &msg)
+	return msg
+}
+
+// expectNoMessage fails if a chat message arrives within d.
+func (c *wsClient) expectNoMessage(t *testing.T, d time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for {
+		c.conn.SetReadDeadline(deadline)
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			// A timeout is what we want; the connection is unusable afterwards.
+			return
+		}
+		if bytes.Contains(data, []byte(`"type":"message"`)) {
+			t.Fatalf("user %d: unexpected message %s", c.userID, data)
+		}
+	}
+}
+
+// expectClosed waits for the server to close the connection normally.
+func (c *wsClient) expectClosed(t *testing.T) {
+	t.Helper()
+	for {
+		_, _, err := c.conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Fatalf("user %d: want a normal close, got %v", c.userID, err)
+		}
+		return
+	}
+}
+
+// waitFor polls cond until it holds or waitTimeout passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
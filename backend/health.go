@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"chat-app/backend/migrations"
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+// HealthCheck probes one dependency. Check must return promptly once ctx
+// is done.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HandleHealth runs every check concurrently, each bounded by timeout, and
+// answers 200 when all pass or 503 with the failing components otherwise.
+func HandleHealth(timeout time.Duration, checks ...HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := models.HealthReport{Status: "ok", Checks: make(map[string]models.ComponentStatus, len(checks))}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, hc := range checks {
+			wg.Add(1)
+			go func(hc HealthCheck) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+
+				start := time.Now()
+				err := hc.Check(ctx)
+				st := models.ComponentStatus{Status: "ok", DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+				if err != nil {
+					st.Status, st.Error = "fail", err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				report.Checks[hc.Name] = st
+				if err != nil {
+					report.Status = "fail"
+				}
+			}(hc)
+		}
+		wg.Wait()
+
+		if report.Status != "ok" {
+			loggerFrom(r.Context()).Warn("health check failed", "path", r.URL.Path, "checks", report.Checks)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// hubLiveCheck fails when the hub loop no longer answers.
+func hubLiveCheck(hub *Hub) HealthCheck {
+	return HealthCheck{Name: "hub", Check: hub.Ping}
+}
+
+// hubReadyCheck also fails while the hub drains for shutdown, so load
+// balancers stop sending new connections here.
+func hubReadyCheck(hub *Hub) HealthCheck {
+	return HealthCheck{Name: "hub", Check: func(ctx context.Context) error {
+		if hub.Draining() {
+			return errors.New("shutting down")
+		}
+		return hub.Ping(ctx)
+	}}
+}
+
+// readinessChecks are the dependencies a request needs: the hub and, for
+// SQL stores, a reachable database with an up-to-date schema. The in-memory
+// store has neither a connection nor migrations to check.
+func readinessChecks(hub *Hub, db store.Store) []HealthCheck {
+	checks := []HealthCheck{hubReadyCheck(hub)}
+	sqlDB, ok := db.(store.SQLStore)
+	if !ok {
+		return checks
+	}
+	checks = append(checks,
+		HealthCheck{Name: "database", Check: func(ctx context.Context) error {
+			return sqlDB.DB().PingContext(ctx)
+		}},
+		HealthCheck{Name: "migrations", Check: func(ctx context.Context) error {
+			m, err := migrations.New(sqlDB.DB(), sqlDB.Dialect())
+			if err != nil {
+				return err
+			}
+			pending, err := m.Pending(ctx)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("%d pending, next %04d_%s", len(pending), pending[0].Version, pending[0].Name)
+			}
+			return nil
+		}},
+	)
+	return checks
+}
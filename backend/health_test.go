@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"chat-app/backend/migrations"
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+// probe fetches a health endpoint and decodes its report.
+func (ts *testServer) probe(t *testing.T, path string) (int, models.HealthReport) {
+	t.Helper()
+	resp, err := ts.Client().Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report models.HealthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("%s: decode: %v", path, err)
+	}
+	return resp.StatusCode, report
+}
+
+func TestReadyzChecksDatabaseAndMigrations(t *testing.T) {
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	ts := newTestServerWithStore(t, db)
+
+	code, report := ts.probe(t, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Checks["migrations"].Status != "fail" {
+		t.Fatalf("before migrating: %d %+v, want 503 with migrations failing", code, report)
+	}
+	if report.Checks["database"].Status != "ok" || report.Checks["hub"].Status != "ok" {
+		t.Errorf("before migrating: %+v, want database and hub ok", report.Checks)
+	}
+
+	m, err := migrations.New(db.DB(), db.Dialect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, report := ts.probe(t, "/readyz"); code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("after migrating: %d %+v, want 200", code, report)
+	}
+
+	db.Close()
+	code, report = ts.probe(t, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Checks["database"].Status != "fail" {
+		t.Errorf("database closed: %d %+v, want 503 with database failing", code, report)
+	}
+	// Liveness doesn't depend on the database.
+	if code, _ := ts.probe(t, "/livez"); code != http.StatusOK {
+		t.Errorf("livez with the database down: %d, want 200", code)
+	}
+}
+
+func TestHealthDuringShutdown(t *testing.T) {
+	ts := newTestServer(t)
+	if code, report := ts.probe(t, "/readyz"); code != http.StatusOK || len(report.Checks) != 1 {
+		t.Fatalf("readyz on the memory store: %d %+v, want 200 with only the hub checked", code, report)
+	}
+
+	if err := ts.hub.Shutdown(context.Background(), []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/readyz", "/livez"} {
+		if code, report := ts.probe(t, path); code != http.StatusServiceUnavailable || report.Checks["hub"].Status != "fail" {
+			t.Errorf("%s after shutdown: %d %+v, want 503 with hub failing", path, code, report)
+		}
+	}
+}
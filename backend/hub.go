@@ -1,95 +1,673 @@
 // it maintains everything the core logic of the chat messaging app system
 // channels is like map<int>map<*Client> which is like 1->{client1: client1, client2: client2}
 // 1 is channel id
+// users is the same idea keyed by user id, so every tab/device of a user can be found
 package main
 
 import (
-    "log"
-    "sync"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-app/backend/models"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Subscription is used for subscribe/unsubscribe events.
 type Subscription struct {
-    ChannelID int
-    Client    *Client
+	ChannelID int
+	Client    *Client
 }
 
 // BroadcastMessage is a message delivered to all clients in a channel.
+// When SenderID is set the message is also echoed to every other connection
+// of the sender, even ones that are not subscribed to the channel. Connections
+// of SkipUserID are left out, e.g. for a user's own typing indicator.
 type BroadcastMessage struct {
-    ChannelID int
-    Data      []byte
+	ChannelID  int
+	SenderID   int
+	SkipUserID int
+	Data       []byte
+	// Trace is the span that produced the message; the fan-out span is
+	// recorded as its child.
+	Trace trace.SpanContext
+}
+
+// Registration announces a new connection together with the channels it
+// should be subscribed to straight away.
+type Registration struct {
+	Client     *Client
+	ChannelIDs []int
+}
+
+// DirectMessage is a frame for a single connection.
+type DirectMessage struct {
+	Client *Client
+	Data   []byte
+}
+
+// StatusUpdate carries a manual presence status chosen by a user.
+type StatusUpdate struct {
+	UserID int
+	Status string
+}
+
+// DisconnectRequest asks the hub to close a user's sessions. An empty
+// SessionID closes all of them. The number of closed sessions is sent on Done.
+type DisconnectRequest struct {
+	UserID    int
+	SessionID string
+	Done      chan int
+}
+
+// userPresence is the hub's view of one user beyond their connections:
+// the status they picked manually, if any, and when they were last seen.
+type userPresence struct {
+	manualStatus string
+	lastSeenAt   time.Time
+}
+
+// status derives the effective presence status from the live connection count.
+func (p *userPresence) status(connections int) string {
+	if connections == 0 {
+		return models.PresenceOffline
+	}
+	if p.manualStatus != "" {
+		return p.manualStatus
+	}
+	return models.PresenceOnline
 }
 
 // Hub manages multiple channels with an in-memory map: channelID -> set of clients
 type Hub struct {
-    channels    map[int]map[*Client]bool
-    subscribe   chan Subscription
-    unsubscribe chan Subscription
-    broadcast   chan BroadcastMessage
+	channels    map[int]map[*Client]bool
+	users       map[int]map[*Client]bool
+	presence    map[int]*userPresence
+	subscribe   chan Subscription
+	unsubscribe chan Subscription
+	register    chan Registration
+	unregister  chan *Client
+	setStatus   chan StatusUpdate
+	disconnect  chan DisconnectRequest
+	broadcast   chan BroadcastMessage
+	announce    chan []byte
+	direct      chan DirectMessage
+	quit        chan chan []*Client
+	ping        chan chan struct{}
+	// done is closed once Run has returned.
+	done chan struct{}
+
+	mu sync.RWMutex
 
-    mu sync.RWMutex
+	// Frames are handled between beginWork and endWork so Shutdown can wait
+	// for in-flight inserts and broadcasts before stopping the loop.
+	workMu   sync.Mutex
+	draining bool
+	work     sync.WaitGroup
 }
 
 // NewHub creates and returns a new Hub instance.
 func NewHub() *Hub {
-    return &Hub{
-        channels:    make(map[int]map[*Client]bool),
-        subscribe:   make(chan Subscription),
-        unsubscribe: make(chan Subscription),
-        broadcast:   make(chan BroadcastMessage),
-    }
+	return &Hub{
+		channels:    make(map[int]map[*Client]bool),
+		users:       make(map[int]map[*Client]bool),
+		presence:    make(map[int]*userPresence),
+		subscribe:   make(chan Subscription),
+		unsubscribe: make(chan Subscription),
+		register:    make(chan Registration),
+		unregister:  make(chan *Client),
+		setStatus:   make(chan StatusUpdate),
+		disconnect:  make(chan DisconnectRequest),
+		broadcast:   make(chan BroadcastMessage),
+		announce:    make(chan []byte),
+		direct:      make(chan DirectMessage),
+		quit:        make(chan chan []*Client),
+		ping:        make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
 }
 
-// Run starts the hub's main loop.
+// Run starts the hub's main loop. It returns once Shutdown has closed every
+// connection.
 func (h *Hub) Run() {
-    for {
-        select {
-        case sub := <-h.subscribe:
-            h.handleSubscribe(sub)
-        case unsub := <-h.unsubscribe:
-            h.handleUnsubscribe(unsub)
-        case msg := <-h.broadcast:
-            h.handleBroadcast(msg)
-        }
-    }
+	defer close(h.done)
+	for {
+		select {
+		case sub := <-h.subscribe:
+			h.handleSubscribe(sub)
+		case unsub := <-h.unsubscribe:
+			h.handleUnsubscribe(unsub)
+		case reg := <-h.register:
+			h.handleRegister(reg)
+		case client := <-h.unregister:
+			h.handleUnregister(client)
+		case update := <-h.setStatus:
+			h.handleSetStatus(update)
+		case req := <-h.disconnect:
+			h.handleDisconnect(req)
+		case msg := <-h.broadcast:
+			h.handleBroadcast(msg)
+		case data := <-h.announce:
+			h.handleAnnounce(data)
+		case msg := <-h.direct:
+			h.handleDirect(msg)
+		case reply := <-h.quit:
+			h.handleQuit(reply)
+			return
+		case reply := <-h.ping:
+			reply <- struct{}{}
+		}
+	}
+}
+
+// Register hands a new connection to the hub. It reports false if the hub
+// has already stopped.
+func (h *Hub) Register(reg Registration) bool {
+	select {
+	case h.register <- reg:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Unregister removes a connection; it is a no-op once the hub has stopped.
+func (h *Hub) Unregister(c *Client) {
+	select {
+	case h.unregister <- c:
+	case <-h.done:
+	}
+}
+
+// Broadcast queues a message for delivery. Messages sent after the hub has
+// stopped are dropped.
+func (h *Hub) Broadcast(msg BroadcastMessage) {
+	select {
+	case h.broadcast <- msg:
+	case <-h.done:
+	}
+}
+
+// Subscribe adds a connection to a channel's recipients.
+func (h *Hub) Subscribe(sub Subscription) {
+	select {
+	case h.subscribe <- sub:
+	case <-h.done:
+	}
+}
+
+// Unsubscribe removes a connection from a channel's recipients.
+func (h *Hub) Unsubscribe(sub Subscription) {
+	select {
+	case h.unsubscribe <- sub:
+	case <-h.done:
+	}
+}
+
+// SetStatus applies a user's manual presence status.
+func (h *Hub) SetStatus(update StatusUpdate) {
+	select {
+	case h.setStatus <- update:
+	case <-h.done:
+	}
+}
+
+// Send queues a frame for one connection, unless it has already gone away.
+// Only the hub may write to a client's send channel, which it closes.
+func (h *Hub) Send(c *Client, data []byte) {
+	select {
+	case h.direct <- DirectMessage{Client: c, Data: data}:
+	case <-h.done:
+	}
+}
+
+// Subscribed reports whether the connection receives the channel's messages.
+func (h *Hub) Subscribed(c *Client, channelID int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.channels[channelID][c]
+}
+
+// beginWork reports whether a frame may still be processed. Every true
+// result must be paired with endWork.
+func (h *Hub) beginWork() bool {
+	h.workMu.Lock()
+	defer h.workMu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.work.Add(1)
+	return true
+}
+
+func (h *Hub) endWork() { h.work.Done() }
+
+// Draining reports whether Shutdown has started.
+func (h *Hub) Draining() bool {
+	h.workMu.Lock()
+	defer h.workMu.Unlock()
+	return h.draining
+}
+
+// Ping round-trips a request through Run, proving the loop is not stuck
+// behind a blocked handler.
+func (h *Hub) Ping(ctx context.Context) error {
+	reply := make(chan struct{}, 1)
+	select {
+	case h.ping <- reply:
+	case <-h.done:
+		return errors.New("hub stopped")
+	case <-ctx.Done():
+		return fmt.Errorf("hub loop unresponsive: %w", ctx.Err())
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("hub loop unresponsive: %w", ctx.Err())
+	}
+}
+
+// Shutdown drains the hub. It sends notice to every connection, stops
+// accepting new frames, waits for the frames already being handled (so
+// their messages are stored and delivered), then closes every connection
+// and waits for the close frames to be written. It gives up when ctx ends.
+func (h *Hub) Shutdown(ctx context.Context, notice []byte) error {
+	h.workMu.Lock()
+	h.draining = true
+	h.workMu.Unlock()
+
+	select {
+	case h.announce <- notice:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	idle := make(chan struct{})
+	go func() {
+		h.work.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	reply := make(chan []*Client, 1)
+	select {
+	case h.quit <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, c := range <-reply {
+		select {
+		case <-c.writerDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Presence returns the live presence of a user. The boolean is false when the
+// hub doesn't track the user, because it has never seen them or they went
+// offline without a manual status, in which case the caller should fall back
+// to the persisted last_seen_at.
+func (h *Hub) Presence(userID int) (models.Presence, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	p, ok := h.presence[userID]
+	if !ok {
+		return models.Presence{UserID: userID, Status: models.PresenceOffline}, false
+	}
+	connections := len(h.users[userID])
+	out := models.Presence{
+		UserID:      userID,
+		Status:      p.status(connections),
+		Connections: connections,
+	}
+	if !p.lastSeenAt.IsZero() {
+		seen := p.lastSeenAt
+		out.LastSeenAt = &seen
+	}
+	return out, true
+}
+
+// Sessions lists the live connections of a user, oldest first.
+func (h *Hub) Sessions(userID int) []models.Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.sessionsLocked(h.users[userID])
+}
+
+// AllSessions lists every live connection on this server, oldest first.
+func (h *Hub) AllSessions() []models.Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	all := make(map[*Client]bool)
+	for _, clients := range h.users {
+		for c := range clients {
+			all[c] = true
+		}
+	}
+	return h.sessionsLocked(all)
+}
+
+func (h *Hub) sessionsLocked(clients map[*Client]bool) []models.Session {
+	sessions := make([]models.Session, 0, len(clients))
+	for c := range clients {
+		s := models.Session{
+			ID:          c.id,
+			UserID:      c.userID,
+			RemoteAddr:  c.remoteAddr,
+			UserAgent:   c.userAgent,
+			ConnectedAt: c.connectedAt,
+			Channels:    []int{},
+		}
+		for channelID, subscribers := range h.channels {
+			if subscribers[c] {
+				s.Channels = append(s.Channels, channelID)
+			}
+		}
+		sort.Ints(s.Channels)
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	return sessions
+}
+
+// Disconnect force-closes one session of a user, or all of them when
+// sessionID is empty, and reports how many were closed. Once the hub has
+// stopped there is nothing left to close and it returns 0.
+func (h *Hub) Disconnect(userID int, sessionID string) int {
+	done := make(chan int, 1)
+	select {
+	case h.disconnect <- DisconnectRequest{UserID: userID, SessionID: sessionID, Done: done}:
+	case <-h.done:
+		return 0
+	}
+	select {
+	case n := <-done:
+		return n
+	case <-h.done:
+		return 0
+	}
 }
 
 func (h *Hub) handleSubscribe(sub Subscription) {
-    h.mu.Lock()
-    defer h.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-    if h.channels[sub.ChannelID] == nil {
-        h.channels[sub.ChannelID] = make(map[*Client]bool)
-    }
-    h.channels[sub.ChannelID][sub.Client] = true
-    log.Printf("Client subscribed to channel %d", sub.ChannelID)
+	// Ignore subscriptions for clients that already went away.
+	if !h.users[sub.Client.userID][sub.Client] {
+		return
+	}
+	if h.channels[sub.ChannelID] == nil {
+		h.channels[sub.ChannelID] = make(map[*Client]bool)
+	}
+	h.channels[sub.ChannelID][sub.Client] = true
+	sub.Client.log.Debug("subscribed", "channel_id", sub.ChannelID)
 }
 
 func (h *Hub) handleUnsubscribe(unsub Subscription) {
-    h.mu.Lock()
-    defer h.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if clients, ok := h.channels[unsub.ChannelID]; ok {
+		delete(clients, unsub.Client)
+		unsub.Client.log.Debug("unsubscribed", "channel_id", unsub.ChannelID)
+		if len(clients) == 0 {
+			delete(h.channels, unsub.ChannelID)
+		}
+	}
+}
+
+// handleRegister adds the client to its user's connection set and its
+// initial channels. The first connection brings the user back from offline.
+func (h *Hub) handleRegister(reg Registration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := reg.Client
+	if h.users[c.userID] == nil {
+		h.users[c.userID] = make(map[*Client]bool)
+	}
+	h.users[c.userID][c] = true
+	for _, channelID := range reg.ChannelIDs {
+		if h.channels[channelID] == nil {
+			h.channels[channelID] = make(map[*Client]bool)
+		}
+		h.channels[channelID][c] = true
+	}
+
+	p, ok := h.presence[c.userID]
+	if !ok {
+		p = &userPresence{}
+		h.presence[c.userID] = p
+	}
+	if len(h.users[c.userID]) == 1 {
+		// A manual away or dnd outlives the disconnect and applies again.
+		h.notifyPresence(c.userID, p)
+	}
+}
+
+func (h *Hub) handleUnregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.removeClient(c)
+}
+
+// removeClient drops the client from the user index and every channel and
+// closes its send channel. When it was the user's last connection the user
+// goes offline and last_seen_at is persisted; the presence entry is kept
+// only while it holds a manual status. Callers must hold h.mu.
+func (h *Hub) removeClient(c *Client) {
+	conns, ok := h.users[c.userID]
+	if !ok || !conns[c] {
+		return
+	}
+
+	if len(conns) == 1 {
+		// Notify before the client leaves its channels so the peers it
+		// shared them with are still reachable.
+		delete(h.users, c.userID)
+		p := h.presence[c.userID]
+		p.lastSeenAt = time.Now().UTC()
+		h.notifyPresence(c.userID, p)
+		if p.manualStatus == "" {
+			delete(h.presence, c.userID)
+		}
+
+		seenAt := p.lastSeenAt
+		go func() {
+			if err := c.db.UpdateLastSeen(context.Background(), c.userID, seenAt); err != nil {
+				c.log.Error("UpdateLastSeen failed", "err", err)
+			}
+		}()
+	} else {
+		delete(conns, c)
+	}
 
-    if clients, ok := h.channels[unsub.ChannelID]; ok {
-        delete(clients, unsub.Client)
-        log.Printf("Client unsubscribed from channel %d", unsub.ChannelID)
-        if len(clients) == 0 {
-            delete(h.channels, unsub.ChannelID)
-        }
-    }
+	for channelID, clients := range h.channels {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.channels, channelID)
+		}
+	}
+	close(c.send)
+}
+
+func (h *Hub) handleSetStatus(update StatusUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.presence[update.UserID]
+	if !ok || len(h.users[update.UserID]) == 0 {
+		return
+	}
+	manual := update.Status
+	if manual == models.PresenceOnline {
+		manual = ""
+	}
+	if p.manualStatus == manual {
+		return
+	}
+	p.manualStatus = manual
+	h.notifyPresence(update.UserID, p)
+}
+
+func (h *Hub) handleDisconnect(req DisconnectRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	closed := 0
+	for c := range h.users[req.UserID] {
+		if req.SessionID != "" && c.id != req.SessionID {
+			continue
+		}
+		h.removeClient(c)
+		closed++
+	}
+	slog.Info("disconnected sessions", "user_id", req.UserID, "session_id", req.SessionID, "closed", closed)
+	req.Done <- closed
+}
+
+// notifyPresence sends a presence_changed event to every client subscribed
+// to a channel the user is subscribed to. Callers must hold h.mu.
+func (h *Hub) notifyPresence(userID int, p *userPresence) {
+	event := models.WSPresenceChanged{
+		Type:   "presence_changed",
+		UserID: userID,
+		Status: p.status(len(h.users[userID])),
+	}
+	if !p.lastSeenAt.IsZero() {
+		seen := p.lastSeenAt
+		event.LastSeenAt = &seen
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("presence encode failed", "user_id", userID, "err", err)
+		return
+	}
+
+	recipients := make(map[*Client]bool)
+	for _, clients := range h.channels {
+		shared := false
+		for client := range clients {
+			if client.userID == userID {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			continue
+		}
+		for client := range clients {
+			recipients[client] = true
+		}
+	}
+
+	for client := range recipients {
+		h.deliver(client, data)
+	}
 }
 
 func (h *Hub) handleBroadcast(msg BroadcastMessage) {
-    h.mu.RLock()
-    defer h.mu.RUnlock()
-
-    if clients, ok := h.channels[msg.ChannelID]; ok {
-        for client := range clients {
-            err := client.conn.WriteMessage(client.messageType, msg.Data)
-            if err != nil {
-                log.Println("Broadcast error:", err)
-                client.conn.Close()
-                delete(clients, client)
-            }
-        }
-    }
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), msg.Trace), "hub.broadcast",
+		trace.WithAttributes(attribute.Int("chat.channel_id", msg.ChannelID)))
+	start := time.Now()
+	delivered := make(map[*Client]bool)
+	defer func() {
+		broadcastDuration.Observe(time.Since(start).Seconds())
+		broadcastRecipients.Observe(float64(len(delivered)))
+		span.SetAttributes(attribute.Int("chat.recipients", len(delivered)))
+		span.End()
+	}()
+	for client := range h.channels[msg.ChannelID] {
+		if msg.SkipUserID != 0 && client.userID == msg.SkipUserID {
+			continue
+		}
+		delivered[client] = true
+		h.deliver(client, msg.Data)
+	}
+	if msg.SenderID == 0 {
+		return
+	}
+	// Echo to the sender's other devices.
+	for client := range h.users[msg.SenderID] {
+		if !delivered[client] {
+			delivered[client] = true
+			h.deliver(client, msg.Data)
+		}
+	}
+}
+
+// handleAnnounce sends data to every connection.
+func (h *Hub) handleAnnounce(data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, clients := range h.users {
+		for client := range clients {
+			h.deliver(client, data)
+		}
+	}
+}
+
+// handleDirect delivers a frame to one connection if it is still registered.
+func (h *Hub) handleDirect(msg DirectMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.users[msg.Client.userID][msg.Client] {
+		h.deliver(msg.Client, msg.Data)
+	}
+}
+
+// handleQuit closes every connection without the usual presence updates:
+// the users are expected to reconnect to another instance shortly.
+func (h *Hub) handleQuit(reply chan []*Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var closed []*Client
+	for _, clients := range h.users {
+		for client := range clients {
+			close(client.send)
+			closed = append(closed, client)
+		}
+	}
+	h.users = make(map[int]map[*Client]bool)
+	h.channels = make(map[int]map[*Client]bool)
+	slog.Info("hub stopped", "closed", len(closed))
+	reply <- closed
+}
+
+// deliver queues data on the client's send buffer. A client that cannot keep
+// up is dropped rather than stalling the hub. Callers must hold h.mu.
+func (h *Hub) deliver(c *Client, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		c.log.Warn("send buffer full, dropping connection")
+		droppedConnections.Inc()
+		h.removeClient(c)
+	}
 }
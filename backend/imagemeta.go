@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+)
+
+// Metadata stripping for uploaded images. EXIF blocks can carry GPS
+// coordinates, device serials and the like, so they are removed before the
+// file reaches storage. JPEG, PNG, WebP and GIF are rewritten losslessly.
+
+var errBadImage = errors.New("malformed image")
+
+// stripImageMetadata returns data without EXIF, XMP, comment and text
+// metadata.
+func stripImageMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	case "image/gif":
+		return stripGIFMetadata(data)
+	default:
+		return data, nil
+	}
+}
+
+// stripJPEGMetadata drops APP1 (EXIF/XMP), APP13 (IPTC) and COM segments.
+// The EXIF orientation is applied to the pixels first, since dropping it
+// would otherwise show rotated photos sideways.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errBadImage
+	}
+	if o := jpegOrientation(data); o > 1 && o <= 8 {
+		if err := checkImageSize(data); err != nil {
+			return nil, err
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, applyOrientation(img, o), &jpeg.Options{Quality: 92}); err != nil {
+			return nil, err
+		}
+		// The encoder writes no metadata of its own.
+		return out.Bytes(), nil
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errBadImage
+		}
+		marker := data[i+1]
+		// Start of scan: everything after is entropy-coded image data.
+		if marker == 0xDA {
+			return append(out, data[i:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errBadImage
+		}
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return nil, errBadImage
+}
+
+// jpegOrientation reads the EXIF orientation tag, returning 0 if absent.
+func jpegOrientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF && data[i+1] != 0xDA {
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 {
+			return 0
+		}
+		seg := data[i+4 : min(i+2+length, len(data))]
+		if data[i+1] == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + length
+	}
+	return 0
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// applyOrientation rotates/flips img so it displays upright for EXIF orientation o.
+func applyOrientation(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// stripPNGMetadata drops eXIf, text and timestamp chunks.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return nil, errBadImage
+	}
+	out := append(make([]byte, 0, len(data)), sig...)
+	i := len(sig)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errBadImage
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		if string(data[i+4:i+8]) == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+	return nil, errBadImage
+}
+
+// stripWebPMetadata drops EXIF and XMP chunks and clears their VP8X flags.
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errBadImage
+	}
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			return nil, errBadImage
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// stripGIFMetadata drops comment extensions and application extensions
+// such as XMP, keeping only the ones that control animation looping.
+func stripGIFMetadata(data []byte) ([]byte, error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, errBadImage
+	}
+	i := 13 + gifColorTable(data[10])
+	out := append(make([]byte, 0, len(data)), data[:min(i, len(data))]...)
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errBadImage
+			}
+			end, ok := gifSubBlocks(data, i+2)
+			if !ok {
+				return nil, errBadImage
+			}
+			if !gifMetadataExtension(data[i+1], data[i+2:end]) {
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		case 0x2C: // image descriptor, local color table, LZW code size, data
+			if i+11 > len(data) {
+				return nil, errBadImage
+			}
+			start := i + 10 + gifColorTable(data[i+9]) + 1
+			end, ok := gifSubBlocks(data, start)
+			if !ok {
+				return nil, errBadImage
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		case 0x3B: // trailer
+			return append(out, 0x3B), nil
+		default:
+			return nil, errBadImage
+		}
+	}
+	return nil, errBadImage
+}
+
+// gifColorTable returns the size of the color table a packed field announces.
+func gifColorTable(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << (packed&0x07 + 1)
+}
+
+// gifSubBlocks returns the end of the data sub-blocks starting at i.
+func gifSubBlocks(data []byte, i int) (int, bool) {
+	for i < len(data) {
+		n := int(data[i])
+		i++
+		if n == 0 {
+			return i, true
+		}
+		i += n
+	}
+	return 0, false
+}
+
+// gifMetadataExtension reports whether an extension, given its label and
+// sub-blocks, is a comment or an application extension other than a
+// looping one.
+func gifMetadataExtension(label byte, blocks []byte) bool {
+	switch label {
+	case 0xFE:
+		return true
+	case 0xFF:
+		if len(blocks) < 12 {
+			return true
+		}
+		app := string(blocks[1:12])
+		return app != "NETSCAPE2.0" && app != "ANIMEXTS1.0"
+	}
+	return false
+}
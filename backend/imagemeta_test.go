@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// Metadata payloads planted in the fixtures; none may survive stripping.
+const (
+	secretComment = "shot at 51.5007N 0.1246W"
+	secretXMP     = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF/></x:xmpmeta>`
+)
+
+// exifBlock is a little-endian TIFF header with an orientation tag.
+func exifBlock(orientation uint16) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	b = binary.LittleEndian.AppendUint16(b, 0x0112)
+	b = binary.LittleEndian.AppendUint16(b, 3) // SHORT
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, orientation)
+	return append(b, 0, 0, 0, 0, 0, 0) // value padding, no next IFD
+}
+
+// halves is a w×h image, red on the left and blue on the right.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// jpegWithMetadata encodes a 16×8 image with EXIF, XMP and comment segments.
+func jpegWithMetadata(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, halves(16, 8), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	segment := func(marker byte, payload string) []byte {
+		s := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+		return append(s, payload...)
+	}
+	data := buf.Bytes()
+	out := append([]byte(nil), data[:2]...)
+	out = append(out, segment(0xE1, "Exif\x00\x00"+string(exifBlock(orientation)))...)
+	out = append(out, segment(0xE1, "http://ns.adobe.com/xap/1.0/\x00"+secretXMP)...)
+	out = append(out, segment(0xFE, secretComment)...)
+	return append(out, data[2:]...)
+}
+
+// pngWithMetadata encodes a 16×8 image with eXIf, tEXt and iTXt chunks.
+func pngWithMetadata(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, halves(16, 8)); err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(typ, payload string) []byte {
+		c := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+		c = append(c, typ+payload...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+	}
+	data := buf.Bytes()
+	iend := len(data) - 12
+	out := append([]byte(nil), data[:iend]...)
+	out = append(out, chunk("eXIf", string(exifBlock(6)))...)
+	out = append(out, chunk("tEXt", "Comment\x00"+secretComment)...)
+	out = append(out, chunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00"+secretXMP)...)
+	return append(out, data[iend:]...)
+}
+
+// webpWithMetadata wraps a 1×1 lossless WebP in a VP8X container with EXIF
+// and XMP chunks.
+func webpWithMetadata(t *testing.T) []byte {
+	t.Helper()
+	simple, err := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(fourCC, payload string) []byte {
+		c := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(payload)))
+		c = append(c, payload...)
+		if len(payload)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	out = append(out, chunk("VP8X", "\x0C\x00\x00\x00\x00\x00\x00\x00\x00\x00")...) // EXIF and XMP flags, 1×1
+	out = append(out, simple[12:]...)
+	out = append(out, chunk("EXIF", string(exifBlock(6)))...)
+	out = append(out, chunk("XMP ", secretXMP)...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// gifWithMetadata encodes a looping two-frame GIF with a comment and an XMP
+// application extension.
+func gifWithMetadata(t *testing.T) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	subBlocks := func(payload string) []byte {
+		var b []byte
+		for len(payload) > 0 {
+			n := min(len(payload), 255)
+			b = append(append(b, byte(n)), payload[:n]...)
+			payload = payload[n:]
+		}
+		return append(b, 0)
+	}
+	data := buf.Bytes()
+	trailer := len(data) - 1
+	out := append([]byte(nil), data[:trailer]...)
+	out = append(append(out, 0x21, 0xFE), subBlocks(secretComment)...)
+	out = append(append(out, 0x21, 0xFF, 11), "XMP DataXMP"...)
+	out = append(out, subBlocks(secretXMP)...)
+	return append(out, data[trailer:]...)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name, contentType string
+		data              []byte
+		gone              []string
+		check             func(t *testing.T, out []byte)
+	}{{
+		name:        "jpeg rotated",
+		contentType: "image/jpeg",
+		data:        jpegWithMetadata(t, 6),
+		gone:        []string{"Exif\x00\x00", "http://ns.adobe.com/xap/1.0/", secretXMP, secretComment},
+		check: func(t *testing.T, out []byte) {
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Orientation 6 turns the 16×8 image a quarter clockwise:
+			// the red left half ends up on top.
+			if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 16 {
+				t.Fatalf("size %v, want 8×16", b.Size())
+			}
+			if r, _, bl, _ := img.At(4, 3).RGBA(); r>>8 < 200 || bl>>8 > 60 {
+				t.Errorf("top is not red: %v", img.At(4, 3))
+			}
+			if r, _, bl, _ := img.At(4, 12).RGBA(); r>>8 > 60 || bl>>8 < 200 {
+				t.Errorf("bottom is not blue: %v", img.At(4, 12))
+			}
+		},
+	}, {
+		name:        "jpeg upright",
+		contentType: "image/jpeg",
+		data:        jpegWithMetadata(t, 1),
+		gone:        []string{"Exif\x00\x00", "http://ns.adobe.com/xap/1.0/", secretXMP, secretComment},
+		check: func(t *testing.T, out []byte) {
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != 16 || cfg.Height != 8 {
+				t.Errorf("size %d×%d, want 16×8", cfg.Width, cfg.Height)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Error(err)
+			}
+		},
+	}, {
+		name:        "png",
+		contentType: "image/png",
+		data:        pngWithMetadata(t),
+		gone:        []string{"eXIf", "tEXt", "iTXt", secretXMP, secretComment},
+		check: func(t *testing.T, out []byte) {
+			img, err := png.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := halves(16, 8)
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 16; x++ {
+					if got := color.RGBAModel.Convert(img.At(x, y)); got != want.At(x, y) {
+						t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, want.At(x, y))
+					}
+				}
+			}
+		},
+	}, {
+		name:        "webp",
+		contentType: "image/webp",
+		data:        webpWithMetadata(t),
+		gone:        []string{"EXIF", "XMP ", secretXMP},
+		check: func(t *testing.T, out []byte) {
+			if size := binary.LittleEndian.Uint32(out[4:]); int(size) != len(out)-8 {
+				t.Errorf("RIFF size %d, want %d", size, len(out)-8)
+			}
+			if flags := out[20]; flags&0x0C != 0 {
+				t.Errorf("VP8X flags %#x still announce EXIF or XMP", flags)
+			}
+			img, err := webp.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+				t.Errorf("size %v, want 1×1", b.Size())
+			}
+		},
+	}, {
+		name:        "gif",
+		contentType: "image/gif",
+		data:        gifWithMetadata(t),
+		gone:        []string{"XMP DataXMP", secretXMP, secretComment},
+		check: func(t *testing.T, out []byte) {
+			anim, err := gif.DecodeAll(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(anim.Image) != 2 || anim.LoopCount != 0 {
+				t.Errorf("%d frames, loop count %d; want 2 frames looping forever", len(anim.Image), anim.LoopCount)
+			}
+			if !bytes.Contains(out, []byte("NETSCAPE2.0")) {
+				t.Error("the looping extension was stripped")
+			}
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, s := range tc.gone {
+				if !bytes.Contains(tc.data, []byte(s)) {
+					t.Fatalf("fixture lacks %q", s)
+				}
+			}
+			out, err := stripImageMetadata(tc.contentType, tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tc.gone {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q survived", s)
+				}
+			}
+			tc.check(t, out)
+		})
+	}
+}
+
+func TestStripImageMetadataRejectsTruncated(t *testing.T) {
+	for contentType, data := range map[string][]byte{
+		"image/jpeg": jpegWithMetadata(t, 1),
+		"image/png":  pngWithMetadata(t),
+		"image/webp": webpWithMetadata(t),
+		"image/gif":  gifWithMetadata(t),
+	} {
+		if _, err := stripImageMetadata(contentType, data[:len(data)/2]); err == nil {
+			t.Errorf("%s cut in half: no error", contentType)
+		}
+	}
+}
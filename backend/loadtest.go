@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+	"chat-app/backend/store"
+
+	"github.com/gorilla/websocket"
+)
+
+// LoadTestConfig shapes the traffic of `backend loadtest`.
+type LoadTestConfig struct {
+	Clients           int           // simulated users, one connection each
+	Channels          int           // GROUP channels the clients are spread over
+	ChannelsPerClient int           // channels each client is a member of
+	Rate              float64       // messages per second, all clients together
+	Duration          time.Duration // how long to send
+	Report            time.Duration // progress line interval; 0 turns it off
+	Drain             time.Duration // how long to wait for deliveries after sending stops
+	MessageBytes      int           // size of each message's text
+}
+
+// loadTestMarker starts the text of every message the load test sends; the
+// send time in Unix nanoseconds follows it.
+const loadTestMarker = "lt "
+
+// runLoadTestCommand implements `backend loadtest [flags]`. It starts the
+// router and Hub in-process on an in-memory store, connects the simulated
+// clients through /ws over loopback and prints the report. It fails if any
+// message was lost or any error happened.
+func runLoadTestCommand(args []string) error {
+	cfg := LoadTestConfig{}
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.IntVar(&cfg.Clients, "clients", 100, "simulated clients, one user and connection each")
+	fs.IntVar(&cfg.Channels, "channels", 10, "channels the clients are spread over")
+	fs.IntVar(&cfg.ChannelsPerClient, "channels-per-client", 1, "channels each client is a member of")
+	fs.Float64Var(&cfg.Rate, "rate", 200, "messages per second, all clients together")
+	fs.DurationVar(&cfg.Duration, "duration", 30*time.Second, "how long to send; use hours for a soak test")
+	fs.DurationVar(&cfg.Report, "report", 10*time.Second, "progress report interval, 0 for none")
+	fs.DurationVar(&cfg.Drain, "drain", 5*time.Second, "how long to wait for deliveries once sending stops")
+	fs.IntVar(&cfg.MessageBytes, "size", 64, "bytes of text per message")
+	floodLimits := fs.Bool("flood-limits", false, "keep the default WebSocket frame limits instead of lifting them")
+	logLevel := fs.String("log-level", "warn", "server log level")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	logger, err := newLogger(LogConfig{Format: "text", Level: *logLevel}, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	serverCfg := defaultConfig()
+	if !*floodLimits {
+		serverCfg.WebSocket.Flood = unlimitedFlood()
+	}
+	db := store.NewMemory()
+	baseURL, stop, err := startLoadTestServer(serverCfg, db)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	report, err := RunLoadTest(ctx, cfg, baseURL, db, os.Stdout)
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+	if report.Lost() > 0 || len(report.Errors) > 0 {
+		return errors.New("load test saw lost messages or errors")
+	}
+	return nil
+}
+
+func (cfg LoadTestConfig) validate() error {
+	switch {
+	case cfg.Clients < 1, cfg.Channels < 1:
+		return errors.New("-clients and -channels must be at least 1")
+	case cfg.ChannelsPerClient < 1 || cfg.ChannelsPerClient > cfg.Channels:
+		return errors.New("-channels-per-client must be between 1 and -channels")
+	case cfg.Rate <= 0, cfg.Duration <= 0:
+		return errors.New("-rate and -duration must be positive")
+	case cfg.MessageBytes < len(loadTestMarker)+20 || cfg.MessageBytes > 4000:
+		return fmt.Errorf("-size must be between %d and 4000", len(loadTestMarker)+20)
+	}
+	return nil
+}
+
+// unlimitedFlood lifts the frame budgets, which are meant for people and
+// would throttle the generator long before the Hub.
+func unlimitedFlood() FloodConfig {
+	unlimited := RateLimitRule{RequestsPerSecond: math.Inf(1), Burst: 1}
+	limit := FrameLimit{PerConnection: unlimited, PerUser: unlimited}
+	flood := defaultConfig().WebSocket.Flood
+	for channelType := range flood.ChannelTypes {
+		flood.ChannelTypes[channelType] = FrameLimits{Message: limit, Subscribe: limit, Typing: limit}
+	}
+	return flood
+}
+
+// startLoadTestServer serves the router on a loopback port. Like in the
+// tests, there is no HTTP rate limiting.
+func startLoadTestServer(cfg Config, db store.Store) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "chat-loadtest-")
+	if err != nil {
+		return "", nil, err
+	}
+	files, err := storage.NewLocal(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	hub := NewHub()
+	go hub.Run()
+	origins := NewOriginPolicy(cfg.AllowedOrigins)
+	r := newRouter(cfg, origins, hub, db, files, NewImageProcessor(db, files, hub, 1))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: r}
+	go srv.Serve(ln)
+	stop := func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+	return "http://" + ln.Addr().String(), stop, nil
+}
+
+// LoadReport is what a load test observed from the clients' side.
+type LoadReport struct {
+	Config    LoadTestConfig
+	Connected int
+	Sent      int64 // messages written to a socket
+	Expected  int64 // deliveries the sent messages should cause, one per connected member
+	Delivered int64
+	Elapsed   time.Duration    // from the first send to the end of the drain
+	Errors    map[string]int64 // dial, write, disconnected, send_queue_full, frame:<code>
+	Latency   *latencyHistogram
+	Connect   *latencyHistogram
+}
+
+// Lost counts the expected deliveries that never arrived.
+func (r LoadReport) Lost() int64 {
+	return max(0, r.Expected-r.Delivered)
+}
+
+// Print writes the summary.
+func (r LoadReport) Print(w io.Writer) {
+	lossPct := 0.0
+	if r.Expected > 0 {
+		lossPct = 100 * float64(r.Lost()) / float64(r.Expected)
+	}
+	fmt.Fprintf(w, "clients     %d of %d connected, connect %s\n", r.Connected, r.Config.Clients, r.Connect.Summary())
+	fmt.Fprintf(w, "sent        %d messages (%.1f/s)\n", r.Sent, float64(r.Sent)/r.Config.Duration.Seconds())
+	fmt.Fprintf(w, "delivered   %d of %d expected (%.1f/s), %d lost (%.3f%%)\n",
+		r.Delivered, r.Expected, float64(r.Delivered)/r.Elapsed.Seconds(), r.Lost(), lossPct)
+	fmt.Fprintf(w, "latency     %s\n", r.Latency.Summary())
+	if len(r.Errors) == 0 {
+		fmt.Fprintln(w, "errors      none")
+		return
+	}
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for i, kind := range kinds {
+		label := ""
+		if i == 0 {
+			label = "errors"
+		}
+		fmt.Fprintf(w, "%-11s %s %d\n", label, kind, r.Errors[kind])
+	}
+}
+
+// loadTest is the state of one run.
+type loadTest struct {
+	cfg     LoadTestConfig
+	wsURL   string
+	members map[int]int // channel ID -> connected members
+
+	sent, expected, delivered atomic.Int64
+
+	mu      sync.Mutex
+	errors  map[string]int64
+	latency *latencyHistogram // whole run
+	window  *latencyHistogram // since the last progress line
+	stopped bool              // connections are being closed on purpose
+}
+
+// loadClient is one simulated user.
+type loadClient struct {
+	userID   int
+	channels []int
+	conn     *websocket.Conn
+	out      chan int // channels to send a message to
+	warm     chan struct{}
+	warmOnce sync.Once
+}
+
+// warmed marks the client as ready, or as never going to be.
+func (c *loadClient) warmed() {
+	c.warmOnce.Do(func() { close(c.warm) })
+}
+
+// RunLoadTest creates the users and channels in db, connects every client
+// to the server at baseURL and sends at cfg.Rate for cfg.Duration. Progress
+// lines go to progress.
+func RunLoadTest(ctx context.Context, cfg LoadTestConfig, baseURL string, db store.Store, progress io.Writer) (LoadReport, error) {
+	lt := &loadTest{
+		cfg:     cfg,
+		wsURL:   "ws" + strings.TrimPrefix(baseURL, "http") + "/ws",
+		members: make(map[int]int),
+		errors:  make(map[string]int64),
+		latency: &latencyHistogram{},
+		window:  &latencyHistogram{},
+	}
+	clients, err := lt.setup(ctx, db)
+	if err != nil {
+		return LoadReport{}, err
+	}
+	fmt.Fprintf(progress, "load test: %d clients, %d channels (%d per client), %.0f msg/s for %s\n",
+		cfg.Clients, cfg.Channels, cfg.ChannelsPerClient, cfg.Rate, cfg.Duration)
+
+	report := LoadReport{Config: cfg, Connect: &latencyHistogram{}}
+	clients = lt.connect(clients, report.Connect)
+	report.Connected = len(clients)
+	if len(clients) == 0 {
+		return report, errors.New("no client could connect")
+	}
+	defer lt.close(clients)
+	if err := lt.warmUp(ctx, clients); err != nil {
+		return report, err
+	}
+
+	var writers sync.WaitGroup
+	for _, c := range clients {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			lt.write(c)
+		}()
+	}
+	start := time.Now()
+	lt.schedule(ctx, clients, progress, start)
+	for _, c := range clients {
+		close(c.out)
+	}
+	writers.Wait()
+
+	// Wait for the last deliveries.
+	deadline := time.Now().Add(cfg.Drain)
+	for lt.delivered.Load() < lt.expected.Load() && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.stopped = true
+	report.Sent = lt.sent.Load()
+	report.Expected = lt.expected.Load()
+	report.Delivered = lt.delivered.Load()
+	report.Elapsed = time.Since(start)
+	report.Errors = lt.errors
+	report.Latency = lt.latency
+	return report, nil
+}
+
+// setup creates a user per client and the channels. Client i joins
+// channels i, i+1, ... (mod Channels), so members are spread evenly.
+func (lt *loadTest) setup(ctx context.Context, db store.Store) ([]*loadClient, error) {
+	channelIDs := make([]int, lt.cfg.Channels)
+	for i := range channelIDs {
+		id, err := db.CreateChannel(ctx, fmt.Sprintf("load-%d", i), "GROUP")
+		if err != nil {
+			return nil, fmt.Errorf("create channel: %w", err)
+		}
+		channelIDs[i] = id
+	}
+	clients := make([]*loadClient, lt.cfg.Clients)
+	for i := range clients {
+		userID, err := db.CreateUser(ctx, fmt.Sprintf("load-%d", i))
+		if err != nil {
+			return nil, fmt.Errorf("create user: %w", err)
+		}
+		c := &loadClient{userID: userID, out: make(chan int, 64), warm: make(chan struct{})}
+		for j := 0; j < lt.cfg.ChannelsPerClient; j++ {
+			channelID := channelIDs[(i+j)%lt.cfg.Channels]
+			if err := db.AddChannelMembers(ctx, channelID, []int{userID}); err != nil {
+				return nil, fmt.Errorf("add member: %w", err)
+			}
+			c.channels = append(c.channels, channelID)
+		}
+		clients[i] = c
+	}
+	return clients, nil
+}
+
+// connect dials every client, a few at a time, and starts their readers.
+// It returns the clients that connected.
+func (lt *loadTest) connect(clients []*loadClient, connectTimes *latencyHistogram) []*loadClient {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, 64)
+	var connected []*loadClient
+	for _, c := range clients {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			start := time.Now()
+			conn, _, err := websocket.DefaultDialer.Dial(lt.wsURL+"?user_id="+strconv.Itoa(c.userID), nil)
+			if err != nil {
+				lt.fail("dial")
+				return
+			}
+			c.conn = conn
+			mu.Lock()
+			defer mu.Unlock()
+			connectTimes.Record(time.Since(start))
+			connected = append(connected, c)
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range connected {
+		for _, channelID := range c.channels {
+			lt.members[channelID]++
+		}
+		go lt.read(c)
+	}
+	return connected
+}
+
+// warmUp has each client send one message and wait for its own copy. The
+// server registers a connection with the Hub only after the handshake, so
+// this is what proves every client will receive the measured traffic.
+func (lt *loadTest) warmUp(ctx context.Context, clients []*loadClient) error {
+	for _, c := range clients {
+		err := c.conn.WriteJSON(models.WSIncoming{Type: "message", ChannelID: c.channels[0], Text: "warm-up"})
+		if err != nil {
+			lt.fail("write")
+			c.warmed()
+		}
+	}
+	timeout := time.After(10 * time.Second)
+	for _, c := range clients {
+		select {
+		case <-c.warm:
+		case <-timeout:
+			return errors.New("clients didn't receive their warm-up messages within 10s")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// schedule hands messages to the clients round-robin at the configured
+// rate until the duration is over, printing progress on the way.
+func (lt *loadTest) schedule(ctx context.Context, clients []*loadClient, progress io.Writer, start time.Time) {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	var reportC <-chan time.Time
+	if lt.cfg.Report > 0 {
+		report := time.NewTicker(lt.cfg.Report)
+		defer report.Stop()
+		reportC = report.C
+	}
+	end := start.Add(lt.cfg.Duration)
+	last, budget := start, 0.0
+	next, lastDelivered := 0, int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-reportC:
+			lt.mu.Lock()
+			window := lt.window
+			lt.window = &latencyHistogram{}
+			lt.mu.Unlock()
+			delivered := lt.delivered.Load()
+			fmt.Fprintf(progress, "%8s  sent %d  delivered %d (%.0f/s)  %s\n",
+				now.Sub(start).Round(time.Second), lt.sent.Load(), delivered,
+				float64(delivered-lastDelivered)/lt.cfg.Report.Seconds(), window.Summary())
+			lastDelivered = delivered
+		case now := <-tick.C:
+			if now.After(end) {
+				now = end
+			}
+			budget += now.Sub(last).Seconds() * lt.cfg.Rate
+			last = now
+			for ; budget >= 1; budget-- {
+				c := clients[next%len(clients)]
+				channelID := c.channels[(next/len(clients))%len(c.channels)]
+				next++
+				select {
+				case c.out <- channelID:
+				default:
+					// The client is still writing earlier messages.
+					lt.fail("send_queue_full")
+				}
+			}
+			if !now.Before(end) {
+				return
+			}
+		}
+	}
+}
+
+// write sends the messages handed to a client, stamping each with the
+// time it goes out.
+func (lt *loadTest) write(c *loadClient) {
+	padding := strings.Repeat("x", lt.cfg.MessageBytes-len(loadTestMarker)-19)
+	for channelID := range c.out {
+		text := loadTestMarker + strconv.FormatInt(time.Now().UnixNano(), 10) + padding
+		if err := c.conn.WriteJSON(models.WSIncoming{Type: "message", ChannelID: channelID, Text: text}); err != nil {
+			lt.fail("write")
+			continue
+		}
+		lt.sent.Add(1)
+		lt.expected.Add(int64(lt.members[channelID]))
+	}
+}
+
+// read records the deliveries and error frames of a client.
+func (lt *loadTest) read(c *loadClient) {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			lt.mu.Lock()
+			if !lt.stopped {
+				lt.errors["disconnected"]++
+			}
+			lt.mu.Unlock()
+			c.warmed()
+			return
+		}
+		now := time.Now()
+		var frame struct {
+			Type     string `json:"type"`
+			SenderID int    `json:"senderID"`
+			Content  string `json:"content"`
+			Code     string `json:"code"`
+		}
+		if json.Unmarshal(data, &frame) != nil {
+			lt.fail("bad_frame")
+			continue
+		}
+		switch {
+		case frame.Type == "error":
+			lt.fail("frame:" + frame.Code)
+		case frame.Type != "message":
+		case strings.HasPrefix(frame.Content, loadTestMarker):
+			sentAt, err := strconv.ParseInt(frame.Content[len(loadTestMarker):len(loadTestMarker)+19], 10, 64)
+			if err != nil {
+				lt.fail("bad_frame")
+				continue
+			}
+			lt.delivered.Add(1)
+			lt.mu.Lock()
+			lt.latency.Record(now.Sub(time.Unix(0, sentAt)))
+			lt.window.Record(now.Sub(time.Unix(0, sentAt)))
+			lt.mu.Unlock()
+		case frame.SenderID == c.userID:
+			c.warmed()
+		}
+	}
+}
+
+func (lt *loadTest) fail(kind string) {
+	lt.mu.Lock()
+	lt.errors[kind]++
+	lt.mu.Unlock()
+}
+
+func (lt *loadTest) close(clients []*loadClient) {
+	lt.mu.Lock()
+	lt.stopped = true
+	lt.mu.Unlock()
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+// latencyHistogram counts durations in buckets about 3% wide, so
+// percentiles take constant memory however long a soak test runs. Values
+// are kept in microseconds: exact below 32µs, then 32 buckets per power of
+// two.
+type latencyHistogram struct {
+	counts [60 * subBuckets]int64
+	n      int64
+	max    time.Duration
+}
+
+const subBucketBits = 5
+const subBuckets = 1 << subBucketBits
+
+func (h *latencyHistogram) Record(d time.Duration) {
+	us := uint64(max(d, 0) / time.Microsecond)
+	h.counts[latencyBucket(us)]++
+	h.n++
+	h.max = max(h.max, d)
+}
+
+// Quantile returns the upper edge of the bucket holding the q-th value,
+// capped at the largest value recorded.
+func (h *latencyHistogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.n)))
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(time.Duration(bucketFloor(i+1))*time.Microsecond, h.max)
+		}
+	}
+	return h.max
+}
+
+// Summary is a one-line percentile digest.
+func (h *latencyHistogram) Summary() string {
+	if h.n == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %s  p90 %s  p99 %s  p99.9 %s  max %s",
+		roundLatency(h.Quantile(0.5)), roundLatency(h.Quantile(0.9)), roundLatency(h.Quantile(0.99)),
+		roundLatency(h.Quantile(0.999)), roundLatency(h.max))
+}
+
+func latencyBucket(us uint64) int {
+	if us < subBuckets {
+		return int(us)
+	}
+	shift := bits.Len64(us) - subBucketBits - 1
+	return (shift+1)*subBuckets + int((us>>shift)&(subBuckets-1))
+}
+
+// bucketFloor is the smallest value of bucket i, in microseconds.
+func bucketFloor(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := i/subBuckets - 1
+	return uint64(subBuckets+i%subBuckets) << shift
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
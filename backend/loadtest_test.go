@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"chat-app/backend/store"
+)
+
+func TestLoadTestDeliversEverything(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.WebSocket.Flood = unlimitedFlood()
+	db := store.NewMemory()
+	ts := newTestServerWithConfig(t, cfg, db)
+
+	lc := LoadTestConfig{Clients: 12, Channels: 3, ChannelsPerClient: 2, Rate: 300, Duration: 500 * time.Millisecond,
+		Drain: waitTimeout, MessageBytes: 64}
+	if err := lc.validate(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := RunLoadTest(context.Background(), lc, ts.URL, db, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Connected != 12 || len(report.Errors) != 0 {
+		t.Fatalf("connected %d, errors %v", report.Connected, report.Errors)
+	}
+	// Each client is in 2 of 3 channels, so every channel has 8 members.
+	if report.Sent < 100 || report.Expected != report.Sent*8 {
+		t.Errorf("sent %d, expected %d deliveries; want at least 100 and 8 per message", report.Sent, report.Expected)
+	}
+	if report.Lost() != 0 || report.Latency.n != report.Delivered {
+		t.Errorf("delivered %d of %d, %d latency samples", report.Delivered, report.Expected, report.Latency.n)
+	}
+}
+
+func TestLatencyHistogramQuantiles(t *testing.T) {
+	var h latencyHistogram
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * 100 * time.Microsecond) // 0.1ms .. 1s
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		want := q * float64(time.Second)
+		got := float64(h.Quantile(q))
+		if math.Abs(got-want)/want > 1.0/subBuckets {
+			t.Errorf("p%g = %v, want within 3%% of %v", q*100, h.Quantile(q), time.Duration(want))
+		}
+	}
+	if h.Quantile(1) != time.Second {
+		t.Errorf("p100 = %v, want the max", h.Quantile(1))
+	}
+	for us := uint64(0); us < 1<<20; us += 7 {
+		if i := latencyBucket(us); bucketFloor(i) > us || bucketFloor(i+1) <= us {
+			t.Fatalf("%dµs is in bucket %d, [%d, %d)", us, i, bucketFloor(i), bucketFloor(i+1))
+		}
+	}
+}
@@ -1,54 +1,236 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+	"chat-app/backend/store"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 )
 
 func main() {
-	// 1) Connect to Neon DB
-	db, err := ConnectDB()
+	// `backend migrate [flags] up|down [n]|status` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, args, err := LoadConfig(os.Args[2:])
+		if err != nil {
+			fatal("invalid configuration", err)
+		}
+		setupLogging(cfg.Log)
+		if err := runMigrateCommand(cfg, args); err != nil {
+			fatal("migrate failed", err)
+		}
+		return
+	}
+
+	// `backend loadtest [flags]` measures the Hub on an in-memory server and exits
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadTestCommand(os.Args[2:]); err != nil {
+			fatal("load test failed", err)
+		}
+		return
+	}
+
+	cfg, _, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+	setupLogging(cfg.Log)
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("tracing setup failed", err)
+	}
+
+	// 1) Connect to Neon DB and bring the schema up to date
+	db, err := ConnectDB(cfg.Database)
+	if err != nil {
+		fatal("database connection failed", err)
+	}
+	if err := runMigrations(db, cfg.Database); err != nil {
+		fatal("migration failed", err)
+	}
+
+	// Attachment storage on the local filesystem
+	files, err := storage.NewLocal(cfg.Uploads.Dir)
 	if err != nil {
-		log.Fatalf("DB connection error: %v", err)
+		fatal("storage setup failed", err)
 	}
-	defer db.Close()
 
 	// 2) Create our Hub and start its goroutine
 	hub := NewHub()
 	go hub.Run()
 
+	// Background thumbnailing for image attachments
+	images := NewImageProcessor(db, files, hub, 2)
+
 	// 3) Set up a gorilla/mux Router
+	origins := NewOriginPolicy(cfg.AllowedOrigins)
+	prometheus.MustRegister(
+		newHubCollector(hub),
+		newOriginCollector(origins),
+		collectors.NewDBStatsCollector(db.DB(), db.Dialect()),
+	)
+	r := newRouter(cfg, origins, hub, db, files, images)
+	r.Use(RateLimitMiddleware(NewClientLimiter(cfg.RateLimit, newLimiterStore(cfg.RateLimit, db))))
+
+	// 4) Set up CORS with the same origins the WebSocket upgrader accepts
+	c := cors.New(cors.Options{
+		AllowOriginFunc:  origins.Allowed,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "traceparent", "tracestate"},
+		ExposedHeaders:   []string{"X-Request-ID", "Deprecation", "Link", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: true,
+	})
+
+	// 5) Start the server with CORS middleware
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: c.Handler(r)}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "addr", cfg.ListenAddr, "tls", cfg.TLS.Enabled())
+		if cfg.TLS.Enabled() {
+			serveErr <- srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	// 6) Run until SIGINT/SIGTERM, then drain within the configured deadline
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		fatal("server failed", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+
+	slog.Info("shutting down", "deadline", cfg.Shutdown.Timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	// Stop accepting connections and let REST requests finish.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP shutdown failed", "err", err)
+	}
+	// Tell WebSocket clients, finish in-flight frames, close the sockets.
+	notice, _ := json.Marshal(models.WSServerShutdown{
+		Type:             "server_shutdown",
+		ReconnectAfterMs: cfg.Shutdown.ReconnectAfter.Milliseconds(),
+		JitterMs:         cfg.Shutdown.ReconnectJitter.Milliseconds(),
+	})
+	if err := hub.Shutdown(shutdownCtx, notice); err != nil {
+		slog.Error("hub shutdown failed", "err", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("database close failed", "err", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("trace flush failed", "err", err)
+	}
+	slog.Info("server stopped")
+}
+
+// newRouter registers every route. Middleware that depends on the deployment,
+// like rate limiting and CORS, is added by the caller.
+func newRouter(cfg Config, origins *OriginPolicy, hub *Hub, db store.Store, files storage.Storage, images *ImageProcessor) *mux.Router {
 	r := mux.NewRouter()
-	r.Use(RateLimitMiddleware)
-	// Health check
+	r.Use(TracingMiddleware(cfg.Tracing.ServiceName))
+	r.Use(RequestLogMiddleware)
+	r.Use(MetricsMiddleware)
+	r.Use(origins.CSRFMiddleware)
+	// Prometheus scrape endpoint
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	// Health checks: / for humans, /livez and /readyz for the orchestrator
 	r.HandleFunc("/", HealthCheckHandler).Methods("GET")
+	r.HandleFunc("/livez", HandleHealth(cfg.Health.CheckTimeout, hubLiveCheck(hub))).Methods("GET")
+	r.HandleFunc("/readyz", HandleHealth(cfg.Health.CheckTimeout, readinessChecks(hub, db)...)).Methods("GET")
 
 	// WebSocket
-	r.HandleFunc("/ws", ServeWS(hub, db)).Methods("GET")
+	r.HandleFunc("/ws", ServeWS(hub, db, cfg.WebSocket, origins, NewFrameLimiter(cfg.WebSocket.Flood))).Methods("GET")
+
+	// Users
+	r.HandleFunc(v1("/users"), HandleCreateUser(db)).Methods("POST")
+	r.HandleFunc(v1("/users"), HandleCheckIfUserExists(db)).Methods("GET")
+	r.HandleFunc(v1("/users/{user_id}/channels"), HandleGetMyChannels(db)).Methods("GET")
 
 	// Channels
-	r.HandleFunc("/create_channel", HandleCreateChannel(db)).Methods("POST")
-	r.HandleFunc("/fetch_messages", HandleFetchMessages(db)).Methods("GET")
-	r.HandleFunc("/channels/{channel_id}/members", HandleAddMemberToChannel(db)).Methods("POST")
+	r.HandleFunc(v1("/channels"), HandleCreateChannel(db)).Methods("POST")
+	r.HandleFunc(v1("/channels/{channel_id}/messages"), HandleFetchMessages(db)).Methods("GET")
+	r.HandleFunc(v1("/channels/{channel_id}/members"), HandleAddMemberToChannel(db)).Methods("POST")
 
-	// Users
-	r.HandleFunc("/users", HandleCreateUser(db)).Methods("POST")
-	r.HandleFunc("/my_channels", HandleGetMyChannels(db)).Methods("GET")
-	r.HandleFunc("/check_user", HandleCheckIfUserExists(db)).Methods("GET")
+	// Attachments
+	r.HandleFunc(v1("/channels/{channel_id}/attachments"), HandleUploadAttachment(db, files, images, cfg.Uploads.MaxBytes)).Methods("POST")
+	r.HandleFunc(v1("/attachments/{attachment_id}"), HandleDownloadAttachment(db, files)).Methods("GET")
+	r.HandleFunc(v1("/attachments/{attachment_id}/thumbnail"), HandleDownloadThumbnail(db, files)).Methods("GET")
 
-	// 4) Set up CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	})
+	// Search and presence
+	r.HandleFunc(v1("/search"), HandleSearch(db)).Methods("GET")
+	r.HandleFunc(v1("/presence"), HandleGetPresence(hub, db)).Methods("GET")
 
-	// 5) Start the server with CORS middleware
-	addr := ":8080"
-	log.Println("Server running on", addr)
-	log.Fatal(http.ListenAndServe(addr, c.Handler(r)))
+	// Admin (requires the admin token)
+	admin := AdminAuthMiddleware(cfg.AdminToken)
+	r.Handle(v1("/admin/sessions"), admin(HandleListAllSessions(hub))).Methods("GET")
+	r.Handle(v1("/admin/origin_violations"), admin(HandleOriginViolations(origins))).Methods("GET")
+	r.Handle(v1("/admin/users/{user_id}/sessions"), admin(HandleListSessions(hub))).Methods("GET")
+	r.Handle(v1("/admin/users/{user_id}/sessions"), admin(HandleDisconnectSessions(hub))).Methods("DELETE")
+	r.Handle(v1("/admin/users/{user_id}/sessions/{session_id}"), admin(HandleDisconnectSessions(hub))).Methods("DELETE")
+
+	// API documents
+	r.HandleFunc(v1("/openapi.json"), HandleSpec(openAPIDocument())).Methods("GET")
+	r.HandleFunc(v1("/asyncapi.json"), HandleSpec(asyncAPIDocument())).Methods("GET")
+
+	registerLegacyRoutes(r, cfg, origins, hub, db, files, images)
+
+	r.NotFoundHandler = notFoundHandler
+	r.MethodNotAllowedHandler = methodNotAllowedHandler
+	return r
+}
+
+// registerLegacyRoutes keeps the routes from before /api/v1 working. They
+// share the v1 handlers and mark their responses as deprecated.
+func registerLegacyRoutes(r *mux.Router, cfg Config, origins *OriginPolicy, hub *Hub, db store.Store, files storage.Storage, images *ImageProcessor) {
+	r.HandleFunc("/create_channel", deprecated(v1("/channels"), HandleCreateChannel(db))).Methods("POST")
+	r.HandleFunc("/fetch_messages", deprecated(v1("/channels/{channel_id}/messages"), HandleFetchMessages(db))).Methods("GET")
+	r.HandleFunc("/channels/{channel_id}/members", deprecated(v1("/channels/{channel_id}/members"), HandleAddMemberToChannel(db))).Methods("POST")
+
+	r.HandleFunc("/channels/{channel_id}/attachments", deprecated(v1("/channels/{channel_id}/attachments"),
+		HandleUploadAttachment(db, files, images, cfg.Uploads.MaxBytes))).Methods("POST")
+	r.HandleFunc("/attachments/{attachment_id}", deprecated(v1("/attachments/{attachment_id}"), HandleDownloadAttachment(db, files))).Methods("GET")
+	r.HandleFunc("/attachments/{attachment_id}/thumbnail", deprecated(v1("/attachments/{attachment_id}/thumbnail"), HandleDownloadThumbnail(db, files))).Methods("GET")
+
+	r.HandleFunc("/search", deprecated(v1("/search"), HandleSearch(db))).Methods("GET")
+
+	r.HandleFunc("/users", deprecated(v1("/users"), HandleCreateUser(db))).Methods("POST")
+	r.HandleFunc("/my_channels", deprecated(v1("/users/{user_id}/channels"), HandleGetMyChannels(db))).Methods("GET")
+	r.HandleFunc("/check_user", deprecated(v1("/users"), HandleCheckIfUserExists(db))).Methods("GET")
+
+	r.HandleFunc("/presence", deprecated(v1("/presence"), HandleGetPresence(hub, db))).Methods("GET")
+
+	admin := AdminAuthMiddleware(cfg.AdminToken)
+	r.Handle("/admin/sessions", admin(deprecated(v1("/admin/sessions"), HandleListAllSessions(hub)))).Methods("GET")
+	r.Handle("/admin/origin_violations", admin(deprecated(v1("/admin/origin_violations"), HandleOriginViolations(origins)))).Methods("GET")
+	r.Handle("/admin/users/{user_id}/sessions", admin(deprecated(v1("/admin/users/{user_id}/sessions"), HandleListSessions(hub)))).Methods("GET")
+	r.Handle("/admin/users/{user_id}/sessions", admin(deprecated(v1("/admin/users/{user_id}/sessions"), HandleDisconnectSessions(hub)))).Methods("DELETE")
+	r.Handle("/admin/users/{user_id}/sessions/{session_id}", admin(deprecated(v1("/admin/users/{user_id}/sessions/{session_id}"), HandleDisconnectSessions(hub)))).Methods("DELETE")
+}
+
+// setupLogging installs the configured logger as the slog default, which
+// also routes the standard log package through it.
+func setupLogging(cfg LogConfig) {
+	logger, err := newLogger(cfg, os.Stderr)
+	if err != nil {
+		fatal("invalid log configuration", err)
+	}
+	slog.SetDefault(logger)
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served at /metrics. Counters and histograms live on
+// the default registry; collectors that read the Hub, the origin policy or
+// the database pool are registered by main once those exist.
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_http_request_duration_seconds",
+		Help:    "HTTP request latency by mux route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_http_rate_limited_total",
+		Help: "Requests refused by RateLimitMiddleware.",
+	}, []string{"route"})
+
+	rateLimitStoreErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_rate_limit_store_errors_total",
+		Help: "Rate limit store failures; the requests were let through.",
+	})
+
+	messagesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_messages_total",
+		Help: "Chat messages stored and broadcast; use rate() for messages per second.",
+	})
+
+	broadcastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_hub_broadcast_duration_seconds",
+		Help:    "Time the hub spends fanning one broadcast out to its recipients.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10), // 10µs .. ~2.6s
+	})
+
+	broadcastRecipients = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_hub_broadcast_recipients",
+		Help:    "Connections each broadcast was queued for.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12), // 1 .. 2048
+	})
+
+	droppedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_ws_dropped_connections_total",
+		Help: "Connections dropped because their send buffer was full.",
+	})
+
+	writeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_ws_write_errors_total",
+		Help: "Frames that failed to be written to a WebSocket.",
+	})
+
+	wsFramesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ws_frames_rejected_total",
+		Help: "Incoming frames refused by the flood limits or validation, by frame kind and reason.",
+	}, []string{"kind", "reason"})
+
+	wsFloodDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_ws_flood_disconnects_total",
+		Help: "Connections closed for flooding after repeated mutes.",
+	})
+)
+
+// hubCollector reports the hub's live state at scrape time.
+type hubCollector struct {
+	hub *Hub
+
+	connections   *prometheus.Desc
+	usersOnline   *prometheus.Desc
+	subscriptions *prometheus.Desc
+}
+
+func newHubCollector(hub *Hub) *hubCollector {
+	return &hubCollector{
+		hub: hub,
+		connections: prometheus.NewDesc("chat_hub_connections",
+			"Open WebSocket connections.", nil, nil),
+		usersOnline: prometheus.NewDesc("chat_hub_users_online",
+			"Users with at least one open connection.", nil, nil),
+		subscriptions: prometheus.NewDesc("chat_hub_channel_subscriptions",
+			"Connections subscribed to each channel.", []string{"channel_id"}, nil),
+	}
+}
+
+func (c *hubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.usersOnline
+	ch <- c.subscriptions
+}
+
+func (c *hubCollector) Collect(ch chan<- prometheus.Metric) {
+	h := c.hub
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	connections := 0
+	for _, clients := range h.users {
+		connections += len(clients)
+	}
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(connections))
+	ch <- prometheus.MustNewConstMetric(c.usersOnline, prometheus.GaugeValue, float64(len(h.users)))
+	for channelID, clients := range h.channels {
+		ch <- prometheus.MustNewConstMetric(c.subscriptions, prometheus.GaugeValue,
+			float64(len(clients)), strconv.Itoa(channelID))
+	}
+}
+
+// newOriginCollector exports the OriginPolicy rejection counters.
+func newOriginCollector(p *OriginPolicy) prometheus.Collector {
+	return prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		v := p.Violations()
+		ch <- prometheus.MustNewConstMetric(originViolationsDesc, prometheus.CounterValue, float64(v.WebSocket), "websocket")
+		ch <- prometheus.MustNewConstMetric(originViolationsDesc, prometheus.CounterValue, float64(v.CSRF), "csrf")
+	})
+}
+
+var originViolationsDesc = prometheus.NewDesc("chat_origin_violations_total",
+	"Requests refused by the origin checks.", []string{"check"}, nil)
+
+// routeTemplate returns the mux path template of the matched route, which
+// keeps label cardinality bounded unlike the raw URL path.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
+
+// MetricsMiddleware records the latency and status of every routed request.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpRequestDuration.
+			WithLabelValues(routeTemplate(r), r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder captures the response status. It passes Hijack through so
+// WebSocket upgrades keep working; an upgrade is recorded as 101.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
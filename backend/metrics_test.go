@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"chat-app/backend/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrape reads a Prometheus text exposition into series -> value, where a
+// series is the name with its labels as exposed, e.g. `a_total{kind="x"}`.
+func scrape(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %d", url, resp.StatusCode)
+	}
+	series := make(map[string]float64)
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		series[line[:i]] = v
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+func TestMetricsFollowConnectSendDisconnect(t *testing.T) {
+	ts := newTestServer(t)
+	// The hub gauges are registered by main; serve them from a registry of
+	// their own here, since every test server has its own hub.
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newHubCollector(ts.hub))
+	gauges := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer gauges.Close()
+
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+	before := scrape(t, ts.URL+"/metrics")
+	delta := func(after map[string]float64, series string) float64 {
+		return after[series] - before[series]
+	}
+
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+	subscribed := fmt.Sprintf(`chat_hub_channel_subscriptions{channel_id="%d"}`, channel)
+	g := scrape(t, gauges.URL)
+	if g["chat_hub_connections"] != 2 || g["chat_hub_users_online"] != 2 || g[subscribed] != 2 {
+		t.Errorf("after connecting: connections %v, users %v, subscriptions %v; want 2 each",
+			g["chat_hub_connections"], g["chat_hub_users_online"], g[subscribed])
+	}
+
+	aliceWS.sendMessage(t, channel, "hello")
+	bobWS.nextMessage(t)
+	aliceWS.send(t, models.WSIncoming{Type: "message", ChannelID: channel})
+	aliceWS.next(t, "error", &models.WSError{})
+	if err := aliceWS.conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	aliceWS.next(t, "error", &models.WSError{})
+
+	after := scrape(t, ts.URL+"/metrics")
+	if d := delta(after, "chat_messages_total"); d != 1 {
+		t.Errorf("chat_messages_total moved by %v, want 1", d)
+	}
+	if d := delta(after, "chat_hub_broadcast_recipients_count"); d < 1 {
+		t.Errorf("chat_hub_broadcast_recipients_count moved by %v, want at least 1", d)
+	}
+	if d := delta(after, "chat_hub_broadcast_recipients_sum"); d < 2 {
+		t.Errorf("chat_hub_broadcast_recipients_sum moved by %v, want the 2 members", d)
+	}
+	if d := delta(after, `chat_ws_frames_rejected_total{kind="message",reason="invalid"}`); d != 1 {
+		t.Errorf("rejected invalid message frames moved by %v, want 1", d)
+	}
+	if d := delta(after, `chat_ws_frames_rejected_total{kind="unknown",reason="invalid"}`); d != 1 {
+		t.Errorf("rejected non-JSON frames moved by %v, want 1", d)
+	}
+	if d := delta(after, `chat_ws_frames_rejected_total{kind="subscribe",reason="invalid"}`); d != 0 {
+		t.Errorf("rejected subscribe frames moved by %v, want 0", d)
+	}
+
+	// Requests are labelled with the route template, not the raw path.
+	ts.do(t, "GET", fmt.Sprintf("/api/v1/channels/%d/messages", channel), nil, &[]models.Message{})
+	route := `chat_http_request_duration_seconds_count{code="200",method="GET",route="/api/v1/channels/{channel_id}/messages"}`
+	if d := delta(scrape(t, ts.URL+"/metrics"), route); d != 1 {
+		t.Errorf("%s moved by %v, want 1", route, d)
+	}
+
+	aliceWS.close()
+	waitFor(t, "alice's connection gone from the gauges", func() bool {
+		g := scrape(t, gauges.URL)
+		return g["chat_hub_connections"] == 1 && g["chat_hub_users_online"] == 1 && g[subscribed] == 1
+	})
+	upgrades := `chat_http_request_duration_seconds_count{code="101",method="GET",route="/ws"}`
+	waitFor(t, "upgrades recorded", func() bool {
+		return delta(scrape(t, ts.URL+"/metrics"), upgrades) >= 1
+	})
+}
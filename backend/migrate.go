@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"chat-app/backend/migrations"
+	"chat-app/backend/store"
+)
+
+// runMigrations applies pending migrations at startup unless auto_migrate
+// (AUTO_MIGRATE) is turned off.
+func runMigrations(db store.SQLStore, cfg DatabaseConfig) error {
+	if !cfg.AutoMigrate {
+		slog.Info("auto-migrate disabled, skipping migrations")
+		return nil
+	}
+	m, err := migrations.New(db.DB(), db.Dialect())
+	if err != nil {
+		return err
+	}
+	n, err := m.Up(context.Background())
+	if err != nil {
+		return err
+	}
+	slog.Info("migrations applied", "count", n)
+	return nil
+}
+
+// runMigrateCommand implements `backend migrate up|down [n]|status`.
+func runMigrateCommand(cfg Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [n] | status")
+	}
+
+	db, err := ConnectDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db.DB(), db.Dialect())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s)\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migration(s)\n", n)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-32s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	return nil
+}
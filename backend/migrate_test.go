@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"chat-app/backend/migrations"
+	"chat-app/backend/store"
+)
+
+// openSQLite opens a database file without migrating it.
+func openSQLite(t *testing.T, path string) (store.SQLStore, *migrations.Migrator) {
+	t.Helper()
+	db, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migrations.New(db.DB(), db.Dialect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, m
+}
+
+// applied counts the migrations Status reports as applied.
+func applied(t *testing.T, m *migrations.Migrator) (applied, total int) {
+	t.Helper()
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			applied++
+		}
+	}
+	return applied, len(statuses)
+}
+
+func TestMigrationsRoundTripOnSQLite(t *testing.T) {
+	ctx := context.Background()
+	db, m := openSQLite(t, filepath.Join(t.TempDir(), "chat.db"))
+
+	if n, total := applied(t, m); n != 0 || total < 3 {
+		t.Fatalf("fresh database: %d of %d applied", n, total)
+	}
+	_, total := applied(t, m)
+	if n, err := m.Up(ctx); err != nil || n != total {
+		t.Fatalf("Up = %d, %v; want %d", n, err, total)
+	}
+	if n, _ := applied(t, m); n != total {
+		t.Errorf("after Up: %d of %d applied", n, total)
+	}
+	if pending, err := m.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Errorf("Pending after Up = %v, %v", pending, err)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Errorf("second Up = %d, %v; want nothing to do", n, err)
+	}
+	if _, err := db.DB().ExecContext(ctx, `SELECT disabled_at FROM users`); err != nil {
+		t.Fatalf("schema after Up: %v", err)
+	}
+
+	if n, err := m.Down(ctx, 1); err != nil || n != 1 {
+		t.Fatalf("Down(1) = %d, %v", n, err)
+	}
+	if pending, err := m.Pending(ctx); err != nil || len(pending) != 1 || pending[0].Name != "add_users_disabled_at" {
+		t.Errorf("Pending after Down(1) = %v, %v", pending, err)
+	}
+	if _, err := db.DB().ExecContext(ctx, `SELECT disabled_at FROM users`); err == nil {
+		t.Error("users.disabled_at survived its down migration")
+	}
+
+	if n, err := m.Down(ctx, 100); err != nil || n != total-1 {
+		t.Fatalf("Down(100) = %d, %v; want %d", n, err, total-1)
+	}
+	if n, _ := applied(t, m); n != 0 {
+		t.Errorf("after rolling everything back: %d applied", n)
+	}
+	if _, err := db.DB().ExecContext(ctx, `SELECT 1 FROM users`); err == nil {
+		t.Error("users survived rolling everything back")
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != total {
+		t.Fatalf("Up after Down = %d, %v; want %d", n, err, total)
+	}
+}
+
+func TestMigrationsSerialiseOnSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	// Separate pools, as separate server instances would have.
+	var migrators []*migrations.Migrator
+	for i := 0; i < 4; i++ {
+		_, m := openSQLite(t, path)
+		migrators = append(migrators, m)
+	}
+	_, total := applied(t, migrators[0])
+
+	var wg sync.WaitGroup
+	counts := make([]int, len(migrators))
+	errs := make([]error, len(migrators))
+	for i, m := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[i], errs[i] = m.Up(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	sum := 0
+	for i := range migrators {
+		if errs[i] != nil {
+			t.Errorf("migrator %d: %v", i, errs[i])
+		}
+		sum += counts[i]
+	}
+	if sum != total {
+		t.Errorf("migrators applied %v, %d in all; want each migration once (%d)", counts, sum, total)
+	}
+}
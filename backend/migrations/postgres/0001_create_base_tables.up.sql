@@ -0,0 +1,30 @@
+-- IF NOT EXISTS so databases created by hand before migrations existed can adopt them.
+CREATE TABLE IF NOT EXISTS users (
+    id         SERIAL PRIMARY KEY,
+    username   TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+    id           SERIAL PRIMARY KEY,
+    channel_name TEXT NOT NULL,
+    channel_type TEXT NOT NULL CHECK (channel_type IN ('DIRECT', 'GROUP')),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS channel_members (
+    channel_id INT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (channel_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS channel_members_user_id_idx ON channel_members (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id         SERIAL PRIMARY KEY,
+    channel_id INT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    sender_id  INT NOT NULL REFERENCES users(id),
+    content    TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS messages_channel_id_created_at_idx ON messages (channel_id, created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_content_tsv_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS content_tsv;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    id           SERIAL PRIMARY KEY,
+    channel_id   INT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    message_id   INT REFERENCES messages(id) ON DELETE CASCADE,
+    uploader_id  INT NOT NULL REFERENCES users(id),
+    filename     TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes   BIGINT NOT NULL,
+    storage_key  TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments (message_id);
@@ -0,0 +1,6 @@
+ALTER TABLE attachments
+    DROP COLUMN IF EXISTS width,
+    DROP COLUMN IF EXISTS height,
+    DROP COLUMN IF EXISTS thumbnail_key,
+    DROP COLUMN IF EXISTS preview_key,
+    DROP COLUMN IF EXISTS processed_at;
@@ -0,0 +1,6 @@
+ALTER TABLE attachments
+    ADD COLUMN IF NOT EXISTS width         INT,
+    ADD COLUMN IF NOT EXISTS height        INT,
+    ADD COLUMN IF NOT EXISTS thumbnail_key TEXT,
+    ADD COLUMN IF NOT EXISTS preview_key   TEXT,
+    ADD COLUMN IF NOT EXISTS processed_at  TIMESTAMPTZ;
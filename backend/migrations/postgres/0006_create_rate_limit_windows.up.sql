@@ -0,0 +1,9 @@
+-- Request counters of the shared rate limiter, one row per key and window.
+-- window_start is in Unix milliseconds.
+CREATE TABLE IF NOT EXISTS rate_limit_windows (
+    key          TEXT   NOT NULL,
+    window_start BIGINT NOT NULL,
+    hits         INT    NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+CREATE INDEX IF NOT EXISTS rate_limit_windows_window_start_idx ON rate_limit_windows (window_start);
@@ -0,0 +1,2 @@
+-- Set by chatctl to lock a user out; NULL means the user is active.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
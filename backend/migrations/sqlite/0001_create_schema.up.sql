@@ -0,0 +1,50 @@
+-- SQLite schema for local development. It mirrors the Postgres migrations
+-- minus the tsvector search column; search uses LIKE here.
+CREATE TABLE users (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    username     TEXT NOT NULL UNIQUE,
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at DATETIME
+);
+
+CREATE TABLE channels (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel_name TEXT NOT NULL,
+    channel_type TEXT NOT NULL CHECK (channel_type IN ('DIRECT', 'GROUP')),
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE channel_members (
+    channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (channel_id, user_id)
+);
+CREATE INDEX channel_members_user_id_idx ON channel_members (user_id);
+
+CREATE TABLE messages (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    sender_id  INTEGER NOT NULL REFERENCES users(id),
+    content    TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX messages_channel_id_created_at_idx ON messages (channel_id, created_at);
+
+CREATE TABLE attachments (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel_id    INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    message_id    INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+    uploader_id   INTEGER NOT NULL REFERENCES users(id),
+    filename      TEXT NOT NULL,
+    content_type  TEXT NOT NULL,
+    size_bytes    INTEGER NOT NULL,
+    storage_key   TEXT NOT NULL UNIQUE,
+    width         INTEGER,
+    height        INTEGER,
+    thumbnail_key TEXT,
+    preview_key   TEXT,
+    processed_at  DATETIME,
+    created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX attachments_message_id_idx ON attachments (message_id);
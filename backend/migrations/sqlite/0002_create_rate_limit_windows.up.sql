@@ -0,0 +1,9 @@
+-- Request counters of the shared rate limiter, one row per key and window.
+-- window_start is in Unix milliseconds.
+CREATE TABLE rate_limit_windows (
+    key          TEXT    NOT NULL,
+    window_start INTEGER NOT NULL,
+    hits         INTEGER NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+CREATE INDEX rate_limit_windows_window_start_idx ON rate_limit_windows (window_start);
@@ -1,11 +1,17 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"chat-app/backend/validate"
+)
 
 // User represents a row in the "users" table.
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
+	ID         int        `json:"id"`
+	Username   string     `json:"username"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"` // set while the user is locked out
 }
 
 // Channel represents a row in the "channels" table.
@@ -18,25 +24,304 @@ type Channel struct {
 
 // Message represents a row in the "messages" table.
 type Message struct {
-	ID        int       `json:"id"`
-	ChannelID int       `json:"channel_id"`
-	SenderID  int       `json:"sender_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int          `json:"id"`
+	ChannelID   int          `json:"channel_id"`
+	SenderID    int          `json:"sender_id"`
+	Content     string       `json:"content"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment represents a row in the "attachments" table. It is uploaded
+// first and linked to a message when the message is sent.
+type Attachment struct {
+	ID          int       `json:"id"`
+	ChannelID   int       `json:"channel_id"`
+	MessageID   *int      `json:"message_id,omitempty"`
+	UploaderID  int       `json:"uploader_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	SizeBytes   int64     `json:"size_bytes"`
+	URL         string    `json:"url"`
+	StorageKey  string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Filled in by background processing for images.
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+	PreviewURL   string     `json:"preview_url,omitempty"`
+	ThumbnailKey string     `json:"-"`
+	PreviewKey   string     `json:"-"`
+	ProcessedAt  *time.Time `json:"processed_at,omitempty"`
+}
+
+// Presence statuses. Online and offline are derived from live connections,
+// away and dnd (do-not-disturb) are set manually by the user.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceDND     = "dnd"
+	PresenceOffline = "offline"
+)
+
+// Presence describes whether a user is currently connected.
+type Presence struct {
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Connections int        `json:"connections"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// Session describes one live WebSocket connection of a user.
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      int       `json:"user_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Channels    []int     `json:"channels"`
+}
+
+// OriginViolations counts requests refused because of their origin.
+type OriginViolations struct {
+	WebSocket int64 `json:"websocket"`
+	CSRF      int64 `json:"csrf"`
+}
+
+// ComponentStatus is one entry of a health report.
+type ComponentStatus struct {
+	Status     string  `json:"status"` // ok or fail
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// HealthReport is the body of /livez and /readyz.
+type HealthReport struct {
+	Status string                     `json:"status"` // ok or fail
+	Checks map[string]ComponentStatus `json:"checks"`
 }
 
 // For WebSocket incoming JSON
 type WSIncoming struct {
-	Type      string `json:"type"`      // "subscribe", "unsubscribe", "message"
-	ChannelID int    `json:"channelID"` // which channel
-	Text      string `json:"text"`      // the message content
+	Type          string `json:"type" validate:"required,oneof=subscribe unsubscribe message set_status typing"`
+	ChannelID     int    `json:"channelID,omitempty" validate:"min=1"`                          // which channel
+	Text          string `json:"text" validate:"max=4000,charset=text"`                         // the message content
+	Status        string `json:"status,omitempty" validate:"oneof=online away dnd"`             // for "set_status"
+	AttachmentIDs []int  `json:"attachmentIDs,omitempty" validate:"max=10,dive,required,min=1"` // uploaded attachments to link to the message
+}
+
+// Check applies the rules that depend on the frame type.
+func (in WSIncoming) Check() validate.Errors {
+	var errs validate.Errors
+	switch in.Type {
+	case "subscribe", "unsubscribe", "message", "typing":
+		if in.ChannelID == 0 {
+			errs.Add("channelID", "is required")
+		}
+	case "set_status":
+		if in.Status == "" {
+			errs.Add("status", "is required")
+		}
+	}
+	if in.Type == "message" && in.Text == "" && len(in.AttachmentIDs) == 0 {
+		errs.Add("text", "is required without attachments")
+	}
+	return errs
 }
 
 // For broadcasting out via WebSocket
 type WSOutgoing struct {
-	Type      string    `json:"type"` // "message"
-	ChannelID int       `json:"channelID"`
-	SenderID  int       `json:"senderID"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	Type        string       `json:"type"` // "message"
+	ID          int          `json:"id,omitempty"`
+	ChannelID   int          `json:"channelID"`
+	SenderID    int          `json:"senderID"`
+	Content     string       `json:"content"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// WSPresenceChanged is pushed to everyone sharing a channel with a user
+// whose presence status changed.
+type WSPresenceChanged struct {
+	Type       string     `json:"type"` // "presence_changed"
+	UserID     int        `json:"userID"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// WSAttachmentProcessed is pushed to a channel once an image attachment's
+// thumbnail and preview are ready.
+type WSAttachmentProcessed struct {
+	Type       string     `json:"type"` // "attachment_processed"
+	ChannelID  int        `json:"channelID"`
+	Attachment Attachment `json:"attachment"`
+}
+
+// WSTyping tells a channel's other members that a user is typing.
+type WSTyping struct {
+	Type      string `json:"type"` // "typing"
+	ChannelID int    `json:"channelID"`
+	UserID    int    `json:"userID"`
+}
+
+// WSError reports a refused frame back to the connection that sent it.
+// Code is "rate_limited" (retry after RetryAfterMs), "muted" (frames are
+// refused for RetryAfterMs after repeated violations), "flood_disconnect"
+// (the connection is about to be closed), "invalid_frame" (the frame broke
+// the rules in Fields, or wasn't JSON), "forbidden" (a message to a channel
+// the user isn't a member of) or "internal" (the message couldn't be
+// stored).
+type WSError struct {
+	Type         string            `json:"type"` // "error"
+	Code         string            `json:"code"`
+	Message      string            `json:"message"`
+	Frame        string            `json:"frame,omitempty"` // type of the refused frame
+	RetryAfterMs int64             `json:"retryAfterMs,omitempty"`
+	Fields       map[string]string `json:"fields,omitempty"` // field -> error, for invalid_frame
+}
+
+// WSServerShutdown is sent to every connection when the server is about to
+// stop. Clients should reconnect after ReconnectAfterMs plus a random delay
+// of up to JitterMs, so they don't all come back at once.
+type WSServerShutdown struct {
+	Type             string `json:"type"` // "server_shutdown"
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+	JitterMs         int64  `json:"jitterMs"`
+}
+
+// SearchQuery is a parsed message search, e.g.
+// `deploy from:alice in:3 after:2024-01-01 has:link`.
+type SearchQuery struct {
+	Terms        string     // free text, passed to websearch_to_tsquery
+	FromUserID   int        // from:42
+	FromUsername string     // from:alice
+	ChannelID    int        // in:3
+	Before       *time.Time // before:2024-01-31 (exclusive)
+	After        *time.Time // after:2024-01-01 (inclusive)
+	HasLink      bool       // has:link
+	Limit        int
+	Offset       int
+}
+
+// MessagePage selects a page of a channel's history by message id: with
+// AfterID the oldest Limit messages after it, otherwise the newest Limit
+// messages before BeforeID, or before the end when BeforeID is zero. A page
+// is in chronological order either way.
+type MessagePage struct {
+	BeforeID int
+	AfterID  int
+	Limit    int
+}
+
+// SearchResult is one matching message with a highlighted snippet.
+// Snippet is safe HTML: the message text is escaped and matches are wrapped
+// in <mark></mark>, so it can be rendered as is.
+type SearchResult struct {
+	Message
+	SenderUsername string  `json:"sender_username"`
+	Snippet        string  `json:"snippet"`
+	Rank           float64 `json:"rank"`
+}
+
+// SearchPage is one page of search results.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextOffset *int           `json:"next_offset"`
+}
+
+// Error codes of the REST error envelope. Clients branch on the code; the
+// message is meant for people and may change.
+const (
+	ErrCodeBadRequest           = "bad_request"
+	ErrCodeValidationFailed     = "validation_failed" // details map each field to its error
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeMethodNotAllowed     = "method_not_allowed"
+	ErrCodePayloadTooLarge      = "payload_too_large"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeRateLimited          = "rate_limited"
+	ErrCodeInternal             = "internal"
+	ErrCodeUnavailable          = "unavailable"
+)
+
+// APIError describes a failed REST request. Details carries extra context
+// keyed by name, e.g. the offending parameter or field.
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ErrorResponse is the body of every REST error response.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// CreateUserRequest is the body of POST /api/v1/users.
+type CreateUserRequest struct {
+	Username string `json:"username" validate:"required,max=32,charset=name"`
+}
+
+// CreateUserResponse is returned by POST /api/v1/users.
+type CreateUserResponse struct {
+	UserID int `json:"user_id"`
+}
+
+// UserLookup is returned by GET /api/v1/users?username=.
+type UserLookup struct {
+	Exists bool `json:"exists"`
+	ID     int  `json:"id,omitempty"`
+}
+
+// CreateChannelRequest is the body of POST /api/v1/channels. A DIRECT
+// channel has exactly two members and no name; a GROUP needs a name and at
+// least two members.
+type CreateChannelRequest struct {
+	ChannelType string `json:"channel_type" validate:"required,oneof=DIRECT GROUP"`
+	ChannelName string `json:"channel_name" validate:"max=64,charset=line"`
+	UserIDs     []int  `json:"user_ids" validate:"required,max=100,dive,required,min=1"`
+}
+
+// Check applies the rules that depend on the channel type.
+func (r CreateChannelRequest) Check() validate.Errors {
+	var errs validate.Errors
+	switch r.ChannelType {
+	case "DIRECT":
+		if len(r.UserIDs) != 2 {
+			errs.Add("user_ids", "must list exactly 2 users")
+		}
+	case "GROUP":
+		if r.ChannelName == "" {
+			errs.Add("channel_name", "is required")
+		}
+		if len(r.UserIDs) < 2 {
+			errs.Add("user_ids", "must list at least 2 users")
+		}
+	}
+	return errs
+}
+
+// CreateChannelResponse is returned by POST /api/v1/channels.
+type CreateChannelResponse struct {
+	ChannelID int `json:"channel_id"`
+}
+
+// AddMemberRequest is the body of POST /api/v1/channels/{id}/members.
+type AddMemberRequest struct {
+	UserID int `json:"user_id" validate:"required,min=1"`
+}
+
+// AddMemberResponse is returned by POST /api/v1/channels/{id}/members.
+type AddMemberResponse struct {
+	Message   string `json:"message"`
+	ChannelID int    `json:"channel_id"`
+	AddedUser int    `json:"added_user"`
+}
+
+// DisconnectResponse is returned by the admin session DELETE routes.
+type DisconnectResponse struct {
+	UserID       int `json:"user_id"`
+	Disconnected int `json:"disconnected"`
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"chat-app/backend/models"
+)
+
+// nextPresence waits for the next presence_changed event about userID.
+func (c *wsClient) nextPresence(t *testing.T, userID int) models.WSPresenceChanged {
+	t.Helper()
+	for {
+		var ev models.WSPresenceChanged
+		c.next(t, "presence_changed", &ev)
+		if ev.UserID == userID {
+			return ev
+		}
+	}
+}
+
+// presenceOf asks the REST endpoint for one user's presence.
+func (ts *testServer) presenceOf(t *testing.T, userID int) models.Presence {
+	t.Helper()
+	var out []models.Presence
+	ts.do(t, "GET", fmt.Sprintf("/api/v1/presence?user_ids=%d", userID), nil, &out)
+	if len(out) != 1 {
+		t.Fatalf("presence of %d: %+v", userID, out)
+	}
+	return out[0]
+}
+
+func TestPresenceGoesToChannelPeersOnly(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	carol := ts.createUser(t, "carol")
+	dave := ts.createUser(t, "dave")
+	ts.createChannel(t, "DIRECT", "", alice, bob)
+	elsewhere := ts.createChannel(t, "DIRECT", "", carol, dave)
+
+	bobWS := ts.dial(t, bob)
+	carolWS := ts.dial(t, carol)
+	daveWS := ts.dial(t, dave)
+
+	aliceWS := ts.dial(t, alice)
+	if ev := bobWS.nextPresence(t, alice); ev.Status != models.PresenceOnline {
+		t.Errorf("bob saw alice %q, want online", ev.Status)
+	}
+	aliceWS.send(t, models.WSIncoming{Type: "set_status", Status: models.PresenceDND})
+	if ev := bobWS.nextPresence(t, alice); ev.Status != models.PresenceDND {
+		t.Errorf("bob saw alice %q, want dnd", ev.Status)
+	}
+
+	// Carol shares no channel with alice: the first frame she gets after
+	// alice's changes is dave's message, not alice's presence.
+	daveWS.sendMessage(t, elsewhere, "ping")
+	for {
+		_, data, err := carolWS.conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev models.WSPresenceChanged
+		json.Unmarshal(data, &ev)
+		if ev.Type == "presence_changed" && ev.UserID == alice {
+			t.Fatalf("carol got alice's presence: %s", data)
+		}
+		if ev.Type == "message" {
+			break
+		}
+	}
+}
+
+func TestPresenceManualStatusSurvivesReconnect(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	ts.createChannel(t, "DIRECT", "", alice, bob)
+	bobWS := ts.dial(t, bob)
+
+	first, second := ts.dial(t, alice), ts.dial(t, alice)
+	first.send(t, models.WSIncoming{Type: "set_status", Status: models.PresenceAway})
+	if ev := bobWS.nextPresence(t, alice); ev.Status != models.PresenceOnline {
+		t.Fatalf("bob saw alice %q, want online first", ev.Status)
+	}
+	if ev := bobWS.nextPresence(t, alice); ev.Status != models.PresenceAway {
+		t.Fatalf("bob saw alice %q, want away", ev.Status)
+	}
+
+	// Closing one tab keeps her away; closing the last takes her offline.
+	first.close()
+	waitFor(t, "first tab gone", func() bool { return len(ts.hub.Sessions(alice)) == 1 })
+	if p := ts.presenceOf(t, alice); p.Status != models.PresenceAway || p.Connections != 1 {
+		t.Errorf("with one tab left: %+v, want away on 1 connection", p)
+	}
+	second.close()
+	if ev := bobWS.nextPresence(t, alice); ev.Status != models.PresenceOffline || ev.LastSeenAt == nil {
+		t.Fatalf("bob saw %+v, want offline with last_seen_at", ev)
+	}
+
+	ts.dial(t, alice)
+	if ev := bobWS.nextPresence(t, alice); ev.Status != models.PresenceAway {
+		t.Errorf("after reconnecting bob saw alice %q, want away", ev.Status)
+	}
+	if p := ts.presenceOf(t, alice); p.Status != models.PresenceAway {
+		t.Errorf("after reconnecting: %+v, want away", p)
+	}
+}
+
+func TestPresenceLastSeenOnDisconnect(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	ts.createChannel(t, "DIRECT", "", alice, bob)
+	bobWS := ts.dial(t, bob)
+
+	aliceWS := ts.dial(t, alice)
+	bobWS.nextPresence(t, alice)
+	aliceWS.close()
+	ev := bobWS.nextPresence(t, alice)
+	if ev.Status != models.PresenceOffline || ev.LastSeenAt == nil {
+		t.Fatalf("bob saw %+v, want offline with last_seen_at", ev)
+	}
+
+	waitFor(t, "last_seen_at persisted", func() bool {
+		seen, err := ts.db.FetchLastSeen(context.Background(), []int{alice})
+		return err == nil && seen[alice].Equal(*ev.LastSeenAt)
+	})
+	// Offline without a manual status, she is no longer held in memory; the
+	// endpoint answers from the database.
+	if _, tracked := ts.hub.Presence(alice); tracked {
+		t.Error("the hub still tracks alice after she went offline")
+	}
+	p := ts.presenceOf(t, alice)
+	if p.Status != models.PresenceOffline || p.LastSeenAt == nil || !p.LastSeenAt.Equal(*ev.LastSeenAt) {
+		t.Errorf("presence = %+v, want offline, last seen %v", p, ev.LastSeenAt)
+	}
+}
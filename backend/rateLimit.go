@@ -1,46 +1,299 @@
 package main
 
 import (
-    "net/http"
-    "sync"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-    "golang.org/x/time/rate"
+	"chat-app/backend/store"
+
+	"golang.org/x/time/rate"
+)
+
+// Route classes. Each has its own budget so that, for example, a client
+// polling for messages can't starve its own writes.
+const (
+	classAuth   = "auth"   // creating users, looking them up, opening the WebSocket
+	classWrites = "writes" // every other non-GET request
+	classReads  = "reads"  // every other GET request
 )
 
-// ClientLimiter manages rate limiting per client
+// authRoutes are the route templates in the auth class.
+var authRoutes = map[string]bool{
+	apiPrefix + "/users": true,
+	"/users":             true,
+	"/check_user":        true,
+	"/ws":                true,
+}
+
+// unlimitedRoutes are infrastructure endpoints that orchestrators and
+// scrapers poll; limiting them would only cause false alarms.
+var unlimitedRoutes = map[string]bool{
+	"/metrics": true,
+	"/livez":   true,
+	"/readyz":  true,
+}
+
+// routeClass returns the budget a request is charged to, or "" when it is
+// not rate limited.
+func routeClass(r *http.Request) string {
+	tpl := routeTemplate(r)
+	switch {
+	case unlimitedRoutes[tpl]:
+		return ""
+	case authRoutes[tpl]:
+		return classAuth
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return classReads
+	default:
+		return classWrites
+	}
+}
+
+// LimiterStore keeps the request counters. The in-memory store is private
+// to the process, so with several instances behind a load balancer a client
+// gets a budget on each; the database store shares one budget between all.
+type LimiterStore interface {
+	// Allow charges one request by key against rule.
+	Allow(ctx context.Context, key string, rule RateLimitRule, now time.Time) (rateDecision, error)
+}
+
+// Limiter stores.
+const (
+	limiterStoreMemory   = "memory"
+	limiterStoreDatabase = "database"
+)
+
+// newLimiterStore returns the store selected by the configuration.
+func newLimiterStore(cfg RateLimitConfig, db store.SQLStore) LimiterStore {
+	if cfg.Store == limiterStoreDatabase {
+		return newSQLLimiter(db.DB(), cfg.IdleTimeout)
+	}
+	return newBucketSet(cfg.IdleTimeout)
+}
+
+// rateDecision is the outcome of charging one request.
+type rateDecision struct {
+	Allowed    bool
+	Limit      int           // bucket size
+	Remaining  int           // whole requests left right now
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request would pass, when refused
+}
+
+// limiterEntry is one key's bucket.
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// bucketSet is the in-memory LimiterStore: a token bucket per key. Buckets
+// idle for longer than the idle timeout are evicted, so the map stays as
+// large as the set of recently active keys.
+type bucketSet struct {
+	mu        sync.Mutex
+	buckets   map[string]*limiterEntry
+	idle      time.Duration
+	lastSweep time.Time
+}
+
+func newBucketSet(idle time.Duration) *bucketSet {
+	return &bucketSet{
+		buckets:   make(map[string]*limiterEntry),
+		idle:      idle,
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow implements LimiterStore; it never fails.
+func (bs *bucketSet) Allow(_ context.Context, key string, rule RateLimitRule, now time.Time) (rateDecision, error) {
+	return bs.Take(key, rule, now), nil
+}
+
+// Take charges one request by key against rule. A key's bucket keeps the
+// rule it was created with, so callers must not share keys between rules.
+func (bs *bucketSet) Take(key string, rule RateLimitRule, now time.Time) rateDecision {
+	bs.mu.Lock()
+	bs.sweep(now)
+	e, ok := bs.buckets[key]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(rule.RequestsPerSecond), rule.Burst)}
+		bs.buckets[key] = e
+	}
+	e.lastSeen = now
+	bs.mu.Unlock()
+
+	d := rateDecision{Allowed: true, Limit: rule.Burst}
+	res := e.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		d.Allowed = false
+		d.RetryAfter = delay
+	}
+	tokens := e.limiter.TokensAt(now)
+	d.Remaining = int(math.Max(0, math.Floor(tokens)))
+	d.Reset = time.Duration(math.Max(0, float64(rule.Burst)-tokens) / rule.RequestsPerSecond * float64(time.Second))
+	return d
+}
+
+// sweep evicts idle buckets, at most twice per idle period. A bucket idle
+// that long has refilled completely, so dropping it changes nothing for the
+// client. Callers must hold bs.mu.
+func (bs *bucketSet) sweep(now time.Time) {
+	if now.Sub(bs.lastSweep) < bs.idle/2 {
+		return
+	}
+	bs.lastSweep = now
+	for id, e := range bs.buckets {
+		if now.Sub(e.lastSeen) > bs.idle {
+			delete(bs.buckets, id)
+		}
+	}
+}
+
+// Len returns how many buckets are held.
+func (bs *bucketSet) Len() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return len(bs.buckets)
+}
+
+// ClientLimiter is the HTTP rate limiter: a budget per client and route
+// class, plus the proxy ranges used to find the client's address.
 type ClientLimiter struct {
-    clients map[string]*rate.Limiter
-    mu      sync.Mutex
+	store   LimiterStore
+	rules   map[string]RateLimitRule
+	proxies []netip.Prefix
+}
+
+// NewClientLimiter builds a limiter from the configuration, keeping its
+// counters in limits. Config validation has already checked the rules and
+// proxy ranges.
+func NewClientLimiter(cfg RateLimitConfig, limits LimiterStore) *ClientLimiter {
+	cl := &ClientLimiter{
+		store: limits,
+		rules: map[string]RateLimitRule{
+			classAuth:   cfg.Auth,
+			classWrites: cfg.Writes,
+			classReads:  cfg.Reads,
+		},
+	}
+	for _, p := range cfg.TrustedProxies {
+		if prefix, err := parseProxyRange(p); err == nil {
+			cl.proxies = append(cl.proxies, prefix)
+		}
+	}
+	return cl
+}
+
+// Allow charges one request by key to class's budget. When the store fails
+// the request goes through: losing the shared counters must not take the
+// whole API down with them.
+func (cl *ClientLimiter) Allow(ctx context.Context, class, key string, now time.Time) rateDecision {
+	rule := cl.rules[class]
+	d, err := cl.store.Allow(ctx, class+"|"+key, rule, now)
+	if err != nil {
+		rateLimitStoreErrors.Inc()
+		loggerFrom(ctx).Warn("rate limit store failed, allowing request", "err", err)
+		return rateDecision{Allowed: true, Limit: rule.Burst, Remaining: rule.Burst}
+	}
+	return d
 }
 
-// NewClientLimiter initializes a rate limiter map
-func NewClientLimiter() *ClientLimiter {
-    return &ClientLimiter{
-        clients: make(map[string]*rate.Limiter),
-    }
+// parseProxyRange accepts a CIDR range or a single address.
+func parseProxyRange(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
 }
 
-// GetLimiter returns the rate limiter for a given IP
-func (cl *ClientLimiter) GetLimiter(ip string) *rate.Limiter {
-    cl.mu.Lock()
-    defer cl.mu.Unlock()
+// ClientKey identifies who a request is charged to: the client's IP. The
+// user_id parameter is not authenticated, so keying on it would let a client
+// rotate ids for fresh budgets, or drain someone else's.
+func (cl *ClientLimiter) ClientKey(r *http.Request) string {
+	return "ip:" + cl.ClientIP(r)
+}
+
+// ClientIP returns the address of the client. RemoteAddr carries the
+// ephemeral port, which is dropped. When the peer is a trusted proxy,
+// X-Forwarded-For is read from the right, skipping further trusted hops, so
+// a client can't spoof its address by sending the header itself.
+func (cl *ClientLimiter) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !cl.trusted(peer) {
+		return host
+	}
 
-    if _, exists := cl.clients[ip]; !exists {
-        cl.clients[ip] = rate.NewLimiter(10, 5) // 1 request per second, burst of 5
-    }
-    return cl.clients[ip]
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !cl.trusted(addr) {
+			return addr.Unmap().String()
+		}
+		peer = addr
+	}
+	// Every hop is a proxy: the leftmost one is as close to the client as we get.
+	return peer.Unmap().String()
 }
 
-var limiter = NewClientLimiter()
+func (cl *ClientLimiter) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range cl.proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware charges each request to its client's budget for the
+// route class and reports the budget in RateLimit-Limit, -Remaining and
+// -Reset headers. Refused requests get 429 with Retry-After.
+func RateLimitMiddleware(limiter *ClientLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := routeClass(r)
+			if class == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			d := limiter.Allow(r.Context(), class, limiter.ClientKey(r), time.Now())
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+			if !d.Allowed {
+				rateLimitRejections.WithLabelValues(routeTemplate(r)).Inc()
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+				writeErrorDetails(w, http.StatusTooManyRequests, "", "Too many requests",
+					map[string]string{"retry_after": strconv.Itoa(ceilSeconds(d.RetryAfter))})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-// RateLimitMiddleware applies rate limiting
-func RateLimitMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        ip := r.RemoteAddr
-        if !limiter.GetLimiter(ip).Allow() {
-            http.Error(w, "Too many requests", http.StatusTooManyRequests)
-            return
-        }
-        next.ServeHTTP(w, r)
-    })
+// ceilSeconds rounds d up to whole seconds, as the headers require.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
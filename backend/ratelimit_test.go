@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"chat-app/backend/migrations"
+	"chat-app/backend/store"
+
+	"github.com/gorilla/mux"
+)
+
+func testLimiterConfig() RateLimitConfig {
+	cfg := defaultConfig().RateLimit
+	cfg.Reads = RateLimitRule{RequestsPerSecond: 1, Burst: 2}
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	return cfg
+}
+
+// limitedRouter serves a read route and the auth route /users behind the
+// rate limiter.
+func limitedRouter(cl *ClientLimiter) http.Handler {
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/search", ok).Methods("GET")
+	r.HandleFunc("/users", ok).Methods("POST")
+	r.HandleFunc("/livez", ok).Methods("GET")
+	r.Use(RateLimitMiddleware(cl))
+	return r
+}
+
+func request(h http.Handler, method, target, remote string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remote
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitIgnoresEphemeralPorts(t *testing.T) {
+	h := limitedRouter(NewClientLimiter(testLimiterConfig(), newBucketSet(time.Minute)))
+
+	codes := []int{}
+	for port := 40000; port < 40003; port++ {
+		w := request(h, "GET", "/search", "203.0.113.7:"+strconv.Itoa(port), nil)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+		t.Errorf("statuses from one IP on new ports = %v, want [200 200 429]", codes)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	h := limitedRouter(NewClientLimiter(testLimiterConfig(), newBucketSet(time.Minute)))
+
+	w := request(h, "GET", "/search", "203.0.113.7:1", nil)
+	if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+		t.Errorf("RateLimit-Limit = %q, want 2", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Errorf("RateLimit-Remaining = %q, want 1", got)
+	}
+	request(h, "GET", "/search", "203.0.113.7:1", nil)
+	w = request(h, "GET", "/search", "203.0.113.7:1", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if got := w.Header().Get("RateLimit-Reset"); got != "2" {
+		t.Errorf("RateLimit-Reset = %q, want 2", got)
+	}
+}
+
+func TestRateLimitKeysAndClasses(t *testing.T) {
+	h := limitedRouter(NewClientLimiter(testLimiterConfig(), newBucketSet(time.Minute)))
+	drain := func(target, remote string, header http.Header) {
+		for i := 0; i < 2; i++ {
+			request(h, "GET", target, remote, header)
+		}
+	}
+
+	// user_id is not authenticated: rotating it from one IP gains nothing.
+	drain("/search?user_id=1", "198.51.100.1:1", nil)
+	if w := request(h, "GET", "/search?user_id=2", "198.51.100.1:1", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("rotated user_id on the same IP: %d, want 429", w.Code)
+	}
+	// Nor can another IP drain a user's budget by naming them.
+	if w := request(h, "GET", "/search?user_id=1", "198.51.100.3:1", nil); w.Code != 200 {
+		t.Errorf("same user_id from another IP: %d, want 200", w.Code)
+	}
+	// Reads don't eat into the auth budget, and probes are never limited.
+	drain("/search", "198.51.100.2:1", nil)
+	if w := request(h, "POST", "/users", "198.51.100.2:1", nil); w.Code != 200 {
+		t.Errorf("auth route after exhausting reads: %d, want 200", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := request(h, "GET", "/livez", "198.51.100.2:1", nil); w.Code != 200 {
+			t.Fatalf("probe %d: %d, want 200", i, w.Code)
+		}
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	cl := NewClientLimiter(testLimiterConfig(), newBucketSet(time.Minute))
+	tests := []struct {
+		name, remote, xff, want string
+	}{
+		{"direct client", "203.0.113.7:5555", "", "203.0.113.7"},
+		{"spoofed header from untrusted peer", "203.0.113.7:5555", "1.2.3.4", "203.0.113.7"},
+		{"behind proxy", "10.0.0.5:80", "203.0.113.9", "203.0.113.9"},
+		{"spoofed hop before proxy", "10.0.0.5:80", "1.2.3.4, 203.0.113.9", "203.0.113.9"},
+		{"proxy chain", "10.0.0.5:80", "203.0.113.9, 10.1.1.1", "203.0.113.9"},
+		{"ipv6 client", "[2001:db8::1]:443", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := cl.ClientIP(req); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitEvictsIdleBuckets(t *testing.T) {
+	cfg := testLimiterConfig()
+	cfg.IdleTimeout = time.Minute
+	buckets := newBucketSet(cfg.IdleTimeout)
+	cl := NewClientLimiter(cfg, buckets)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		cl.Allow(ctx, classReads, "ip:198.51.100."+strconv.Itoa(i), start)
+	}
+	cl.Allow(ctx, classReads, "ip:active", start.Add(50*time.Second))
+	if n := buckets.Len(); n != 101 {
+		t.Fatalf("buckets = %d, want 101", n)
+	}
+	cl.Allow(ctx, classReads, "ip:active", start.Add(2*time.Minute))
+	if n := buckets.Len(); n != 1 {
+		t.Errorf("buckets after the idle timeout = %d, want 1", n)
+	}
+}
+
+// migratedSQLite returns a fresh SQLite store with the schema applied.
+func migratedSQLite(t *testing.T) store.SQLStore {
+	t.Helper()
+	return migratedStore(t, store.DialectSQLite, filepath.Join(t.TempDir(), "chat.db"))
+}
+
+// migratedStore opens a database with store.Open and migrates it.
+func migratedStore(t *testing.T, driver, dsn string) store.SQLStore {
+	t.Helper()
+	db, err := store.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migrations.New(db.DB(), db.Dialect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLLimiterSharedBetweenInstances(t *testing.T) {
+	db := migratedSQLite(t)
+	cfg := testLimiterConfig()
+	cfg.Store = limiterStoreDatabase
+	// Two instances on the same database share one budget of 2 per 2s.
+	a := limitedRouter(NewClientLimiter(cfg, newLimiterStore(cfg, db)))
+	b := limitedRouter(NewClientLimiter(cfg, newLimiterStore(cfg, db)))
+
+	codes := []int{
+		request(a, "GET", "/search", "203.0.113.7:1", nil).Code,
+		request(b, "GET", "/search", "203.0.113.7:1", nil).Code,
+	}
+	w := request(a, "GET", "/search", "203.0.113.7:1", nil)
+	codes = append(codes, w.Code)
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+		t.Fatalf("statuses across instances = %v, want [200 200 429]", codes)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+	if got := w.Header().Get("Retry-After"); got == "" || got == "0" {
+		t.Errorf("Retry-After = %q, want a positive delay", got)
+	}
+	if code := request(b, "GET", "/search", "198.51.100.1:1", nil).Code; code != 200 {
+		t.Errorf("other client: %d, want 200", code)
+	}
+}
+
+func TestSQLLimiterSlidingWindow(t *testing.T) {
+	db := migratedSQLite(t)
+	l := newSQLLimiter(db.DB(), time.Minute)
+	rule := RateLimitRule{RequestsPerSecond: 1, Burst: 4} // 4 per 4s
+	ctx := context.Background()
+	start := time.UnixMilli(1_700_000_000_000 - 1_700_000_000_000%4000)
+
+	allow := func(at time.Duration) rateDecision {
+		t.Helper()
+		d, err := l.Allow(ctx, "k", rule, start.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	for i := 0; i < 4; i++ {
+		if d := allow(3 * time.Second); !d.Allowed {
+			t.Fatalf("request %d refused", i)
+		}
+	}
+	d := allow(3 * time.Second)
+	if d.Allowed || d.RetryAfter != 2*time.Second {
+		t.Fatalf("fifth request: %+v, want refused with RetryAfter 2s", d)
+	}
+	// Half-way into the next window the previous one still counts for 2.
+	for i := 0; i < 2; i++ {
+		if d := allow(6 * time.Second); !d.Allowed {
+			t.Fatalf("request %d in the next window refused", i)
+		}
+	}
+	if d := allow(6 * time.Second); d.Allowed {
+		t.Errorf("third request half-way into the next window allowed, want refused")
+	}
+}
+
+func TestLimiterStoreFailureLetsRequestsThrough(t *testing.T) {
+	db := migratedSQLite(t)
+	cfg := testLimiterConfig()
+	h := limitedRouter(NewClientLimiter(cfg, newSQLLimiter(db.DB(), time.Minute)))
+	db.Close()
+
+	for i := 0; i < 3; i++ {
+		if code := request(h, "GET", "/search", "203.0.113.7:1", nil).Code; code != 200 {
+			t.Fatalf("request %d with the store down: %d, want 200", i, code)
+		}
+	}
+}
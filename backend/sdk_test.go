@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"chat-app/backend/client"
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+// sdk returns a client of the test server.
+func (ts *testServer) sdk(t *testing.T, opts ...client.Option) *client.Client {
+	t.Helper()
+	c, err := client.New(ts.URL, append([]client.Option{client.WithHTTPClient(ts.Client())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// connect opens an SDK session that is closed with the test.
+func connect(t *testing.T, c *client.Client, userID int, cfg client.SessionConfig) *client.Session {
+	t.Helper()
+	s, err := c.Connect(context.Background(), userID, cfg)
+	if err != nil {
+		t.Fatalf("connect user %d: %v", userID, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// receive waits for the next event on ch.
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v, ok := <-ch:
+		if !ok {
+			t.Fatalf("%s: channel closed", what)
+		}
+		return v
+	case <-time.After(waitTimeout):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	panic("unreachable")
+}
+
+func TestClientREST(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AdminToken = testAdminToken
+	ts := newTestServerWithConfig(t, cfg, store.NewMemory())
+	c := ts.sdk(t, client.WithAdminToken(testAdminToken))
+	ctx := context.Background()
+
+	alice, err := c.CreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := c.CreateUser(ctx, "bob")
+	carol, _ := c.CreateUser(ctx, "carol")
+
+	_, err = c.CreateUser(ctx, "no spaces")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 || apiErr.Code != models.ErrCodeValidationFailed || apiErr.Details["username"] == "" {
+		t.Errorf("invalid username: %v, want a validation_failed *Error naming username", err)
+	}
+
+	if lookup, err := c.LookupUser(ctx, "bob"); err != nil || !lookup.Exists || lookup.ID != bob {
+		t.Errorf("LookupUser(bob) = %+v, %v", lookup, err)
+	}
+
+	channel, err := c.CreateChannel(ctx, models.CreateChannelRequest{ChannelType: "GROUP", ChannelName: "ops", UserIDs: []int{alice, bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddMember(ctx, channel, carol); err != nil {
+		t.Fatal(err)
+	}
+	if channels, err := c.UserChannels(ctx, carol); err != nil || len(channels) != 1 || channels[0].ID != channel {
+		t.Errorf("UserChannels(carol) = %+v, %v", channels, err)
+	}
+
+	att, err := c.UploadAttachment(ctx, channel, alice, "pixel.png", bytes.NewReader(testPNG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, contentType, err := c.DownloadAttachment(ctx, att.ID, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if contentType != "image/png" || len(data) == 0 {
+		t.Errorf("download: %q, %d bytes", contentType, len(data))
+	}
+	if _, _, err := c.DownloadAttachment(ctx, att.ID+1, bob); !client.IsNotFound(err) {
+		t.Errorf("download of a missing attachment: %v, want a 404", err)
+	}
+
+	ws := ts.dial(t, alice)
+	ws.sendMessage(t, channel, "deploy finished")
+	ws.nextMessage(t)
+	if msgs, err := c.Messages(ctx, channel); err != nil || len(msgs) != 1 || msgs[0].Content != "deploy finished" {
+		t.Errorf("Messages = %+v, %v", msgs, err)
+	}
+	if page, err := c.Search(ctx, bob, "deploy", 10, 0); err != nil || len(page.Results) != 1 {
+		t.Errorf("Search = %+v, %v", page, err)
+	}
+	if presence, err := c.Presence(ctx, alice, bob); err != nil || len(presence) != 2 || presence[0].Status != models.PresenceOnline {
+		t.Errorf("Presence = %+v, %v", presence, err)
+	}
+
+	if sessions, err := c.UserSessions(ctx, alice); err != nil || len(sessions) != 1 {
+		t.Errorf("UserSessions(alice) = %+v, %v", sessions, err)
+	}
+	if sessions, err := c.AllSessions(ctx); err != nil || len(sessions) != 1 {
+		t.Errorf("AllSessions = %+v, %v", sessions, err)
+	}
+	if _, err := c.OriginViolations(ctx); err != nil {
+		t.Errorf("OriginViolations: %v", err)
+	}
+	if n, err := c.Disconnect(ctx, alice, ""); err != nil || n != 1 {
+		t.Errorf("Disconnect(alice) = %d, %v, want 1", n, err)
+	}
+	if _, err := ts.sdk(t).AllSessions(ctx); !errors.As(err, &apiErr) || apiErr.Code != models.ErrCodeUnauthorized {
+		t.Errorf("admin call without a token: %v, want unauthorized", err)
+	}
+
+	if report, err := c.Ready(ctx); err != nil || report.Status != "ok" {
+		t.Errorf("Ready = %+v, %v", report, err)
+	}
+}
+
+func TestClientSessionEvents(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.sdk(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	aliceS := connect(t, c, alice, client.SessionConfig{})
+	bobS := connect(t, c, bob, client.SessionConfig{})
+	if s := receive(t, bobS.States(), "bob connected"); s != client.Connected {
+		t.Fatalf("first state %v, want connected", s)
+	}
+
+	if err := aliceS.Send(channel, "hello bob"); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, bobS.Messages(), "bob's message"); m.Content != "hello bob" || m.SenderID != alice || m.ID == 0 {
+		t.Errorf("bob got %+v", m)
+	}
+
+	aliceS.SendTyping(channel)
+	if ev := receive(t, bobS.Typing(), "typing"); ev.UserID != alice || ev.ChannelID != channel {
+		t.Errorf("typing = %+v", ev)
+	}
+
+	aliceS.SetStatus(models.PresenceAway)
+	for {
+		ev := receive(t, bobS.Presence(), "alice away")
+		if ev.UserID == alice && ev.Status == models.PresenceAway {
+			break
+		}
+	}
+
+	aliceS.Send(channel, "")
+	if ev := receive(t, aliceS.Errors(), "invalid frame error"); ev.Code != "invalid_frame" || ev.Fields["text"] == "" {
+		t.Errorf("error = %+v", ev)
+	}
+
+	aliceS.Close()
+	if _, ok := <-aliceS.Done(); ok {
+		t.Error("Done delivered a value")
+	}
+	if err := aliceS.Send(channel, "too late"); !errors.Is(err, client.ErrNotConnected) {
+		t.Errorf("Send after Close: %v, want ErrNotConnected", err)
+	}
+	if err := aliceS.Err(); err != nil {
+		t.Errorf("Err after Close = %v", err)
+	}
+}
+
+func TestClientSessionResumes(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.sdk(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+	bobWS := ts.dial(t, bob)
+	bobWS.sendMessage(t, channel, "before alice connected")
+	bobWS.nextMessage(t)
+
+	cfg := client.SessionConfig{MinBackoff: 300 * time.Millisecond, MaxBackoff: time.Second}
+	aliceS := connect(t, c, alice, cfg)
+	receive(t, aliceS.States(), "connected")
+	if err := aliceS.Unsubscribe(channel); err != nil {
+		t.Fatal(err)
+	}
+	if err := aliceS.Subscribe(channel); err != nil {
+		t.Fatal(err)
+	}
+	bobWS.sendMessage(t, channel, "one")
+	if m := receive(t, aliceS.Messages(), "first live message"); m.Content != "one" {
+		t.Fatalf("got %q, want one: history from before the session must not be delivered", m.Content)
+	}
+
+	// Drop alice's connection and send while she is away.
+	if ts.hub.Disconnect(alice, "") != 1 {
+		t.Fatal("alice's session wasn't connected")
+	}
+	if s := receive(t, aliceS.States(), "reconnecting"); s != client.Reconnecting {
+		t.Fatalf("state %v, want reconnecting", s)
+	}
+	bobWS.sendMessage(t, channel, "two")
+	bobWS.sendMessage(t, channel, "three")
+	if s := receive(t, aliceS.States(), "reconnected"); s != client.Connected {
+		t.Fatalf("state %v, want connected", s)
+	}
+	bobWS.sendMessage(t, channel, "four")
+
+	for _, want := range []string{"two", "three", "four"} {
+		if m := receive(t, aliceS.Messages(), want); m.Content != want {
+			t.Fatalf("got %q, want %q", m.Content, want)
+		}
+	}
+	select {
+	case m := <-aliceS.Messages():
+		t.Errorf("duplicate or extra message %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if aliceS.Dropped() != 0 {
+		t.Errorf("%d events dropped", aliceS.Dropped())
+	}
+}
+
+// sendOnConnect inserts a message when the WebSocket handshake looks the
+// user up, i.e. after an SDK session marked the history but before its
+// connection is subscribed.
+type sendOnConnect struct {
+	store.Store
+	channelID, senderID int
+	once                sync.Once
+}
+
+func (s *sendOnConnect) GetUser(ctx context.Context, userID int) (models.User, error) {
+	s.once.Do(func() { s.InsertMessage(ctx, s.channelID, s.senderID, "during the handshake") })
+	return s.Store.GetUser(ctx, userID)
+}
+
+func TestClientSessionConnectKeepsGapMessages(t *testing.T) {
+	db := &sendOnConnect{Store: store.NewMemory()}
+	ts := newTestServerWithStore(t, db)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	db.channelID = ts.createChannel(t, "DIRECT", "", alice, bob)
+	db.senderID = bob
+
+	aliceS := connect(t, ts.sdk(t), alice, client.SessionConfig{})
+	if m := receive(t, aliceS.Messages(), "message sent while connecting"); m.Content != "during the handshake" {
+		t.Fatalf("got %q, want the message sent during the handshake", m.Content)
+	}
+}
+
+// holdInsert stalls one sender's message between storing and broadcasting
+// it, so a later message from someone else goes out first.
+type holdInsert struct {
+	store.Store
+	senderID int
+	release  chan struct{}
+}
+
+func (s *holdInsert) InsertMessage(ctx context.Context, channelID, senderID int, content string) (models.Message, error) {
+	msg, err := s.Store.InsertMessage(ctx, channelID, senderID, content)
+	if senderID == s.senderID {
+		<-s.release
+	}
+	return msg, err
+}
+
+func TestClientSessionDeliversOutOfOrderIDs(t *testing.T) {
+	db := &holdInsert{Store: store.NewMemory(), release: make(chan struct{})}
+	ts := newTestServerWithStore(t, db)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	carol := ts.createUser(t, "carol")
+	channel := ts.createChannel(t, "GROUP", "team", alice, bob, carol)
+	db.senderID = alice
+
+	c := ts.sdk(t)
+	carolS := connect(t, c, carol, client.SessionConfig{})
+	aliceS := connect(t, c, alice, client.SessionConfig{NoResume: true})
+	bobS := connect(t, c, bob, client.SessionConfig{NoResume: true})
+
+	if err := aliceS.Send(channel, "first"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "alice's message stored", func() bool {
+		msgs, err := ts.db.FetchMessagesPage(context.Background(), channel, models.MessagePage{Limit: 10})
+		return err == nil && len(msgs) == 1
+	})
+	if err := bobS.Send(channel, "second"); err != nil {
+		t.Fatal(err)
+	}
+	second := receive(t, carolS.Messages(), "bob's message")
+	close(db.release)
+	first := receive(t, carolS.Messages(), "alice's message")
+	if second.Content != "second" || first.Content != "first" || first.ID >= second.ID {
+		t.Fatalf("got %q (id %d) then %q (id %d); want the later id first", second.Content, second.ID, first.Content, first.ID)
+	}
+}
+
+func TestClientSessionStopsOnRefusal(t *testing.T) {
+	ts := newTestServer(t)
+	_, err := ts.sdk(t).Connect(context.Background(), 0, client.SessionConfig{NoResume: true})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("Connect as user 0: %v, want a 400 *Error", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/backend/models"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// ParseSearchQuery splits a search string into free text and filters.
+// Supported filters are from:<user id or username>, in:<channel id>,
+// before:<date>, after:<date> and has:link. Dates are YYYY-MM-DD or RFC 3339.
+// Anything else, including quoted phrases, is kept as free text.
+func ParseSearchQuery(q string) (models.SearchQuery, error) {
+	var sq models.SearchQuery
+	var terms []string
+
+	for _, tok := range tokenizeSearch(q) {
+		key, value, ok := strings.Cut(tok, ":")
+		if !ok || value == "" {
+			terms = append(terms, tok)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "from":
+			if id, err := strconv.Atoi(value); err == nil {
+				sq.FromUserID = id
+			} else {
+				sq.FromUsername = value
+			}
+		case "in":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return sq, fmt.Errorf("invalid channel in %q", tok)
+			}
+			sq.ChannelID = id
+		case "before":
+			t, err := parseSearchDate(value)
+			if err != nil {
+				return sq, fmt.Errorf("invalid date in %q", tok)
+			}
+			sq.Before = &t
+		case "after":
+			t, err := parseSearchDate(value)
+			if err != nil {
+				return sq, fmt.Errorf("invalid date in %q", tok)
+			}
+			sq.After = &t
+		case "has":
+			if strings.ToLower(value) != "link" {
+				return sq, fmt.Errorf("unsupported filter %q", tok)
+			}
+			sq.HasLink = true
+		default:
+			// Not a filter we know, e.g. a URL or "ratio:2" - search it as text.
+			terms = append(terms, tok)
+		}
+	}
+
+	sq.Terms = strings.Join(terms, " ")
+	return sq, nil
+}
+
+// tokenizeSearch splits on whitespace but keeps double-quoted phrases,
+// quotes included, as a single token so websearch_to_tsquery sees them.
+func tokenizeSearch(q string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuote := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n'):
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
+
+func parseSearchDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
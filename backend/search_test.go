@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"html"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(s string) *time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+	for _, tc := range []struct {
+		q    string
+		want models.SearchQuery
+	}{
+		{"deploy failed", models.SearchQuery{Terms: "deploy failed"}},
+		{"  spaced\tout\n ", models.SearchQuery{Terms: "spaced out"}},
+		{"from:42 deploy", models.SearchQuery{Terms: "deploy", FromUserID: 42}},
+		{"from:alice", models.SearchQuery{FromUsername: "alice"}},
+		{"FROM:alice", models.SearchQuery{FromUsername: "alice"}},
+		{"in:3 release", models.SearchQuery{Terms: "release", ChannelID: 3}},
+		{"before:2024-01-31", models.SearchQuery{Before: day("2024-01-31T00:00:00Z")}},
+		{"after:2024-01-01T09:30:00+02:00", models.SearchQuery{After: day("2024-01-01T09:30:00+02:00")}},
+		{"after:2024-01-01 before:2024-02-01 report", models.SearchQuery{Terms: "report",
+			After: day("2024-01-01T00:00:00Z"), Before: day("2024-02-01T00:00:00Z")}},
+		{"has:link", models.SearchQuery{HasLink: true}},
+		{"has:LINK docs", models.SearchQuery{Terms: "docs", HasLink: true}},
+		// Quoted phrases stay whole, quotes included, and hide operators.
+		{`"deploy failed" from:bob`, models.SearchQuery{Terms: `"deploy failed"`, FromUsername: "bob"}},
+		{`"from:bob in:3"`, models.SearchQuery{Terms: `"from:bob in:3"`}},
+		{`"unterminated phrase`, models.SearchQuery{Terms: `"unterminated phrase`}},
+		// Unknown operators, URLs and bare colons are free text.
+		{"ratio:2 https://example.com/x", models.SearchQuery{Terms: "ratio:2 https://example.com/x"}},
+		{"from: in:", models.SearchQuery{Terms: "from: in:"}},
+		{"", models.SearchQuery{}},
+	} {
+		got, err := ParseSearchQuery(tc.q)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q): %v", tc.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want %+v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	for q, want := range map[string]string{
+		"before:yesterday":       "invalid date",
+		"after:2024-13-01":       "invalid date",
+		"before:2024-02-30":      "invalid date",
+		"after:31/01/2024 hello": "invalid date",
+		"in:general":             "invalid channel",
+		"has:image":              "unsupported filter",
+	} {
+		_, err := ParseSearchQuery(q)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseSearchQuery(%q): %v, want %q", q, err, want)
+		}
+	}
+}
+
+func TestSearchSnippetIsEscaped(t *testing.T) {
+	const content = `<script>alert("hi")</script> the <mark>deploy</mark> & co`
+	const want = `&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; the &lt;mark&gt;<mark>deploy</mark>&lt;/mark&gt; &amp; co`
+	for name, db := range map[string]store.Store{
+		"memory": store.NewMemory(),
+		"sqlite": migratedStore(t, store.DialectSQLite, ":memory:"),
+	} {
+		ctx := context.Background()
+		alice, err := db.CreateUser(ctx, "alice")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		channel, err := db.CreateChannel(ctx, "", "DIRECT")
+		if err == nil {
+			err = db.AddChannelMembers(ctx, channel, []int{alice})
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := db.InsertMessage(ctx, channel, alice, content); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		for q, want := range map[string]string{"deploy": want, "": html.EscapeString(content)} {
+			results, err := db.SearchMessages(ctx, alice, models.SearchQuery{Terms: q, Limit: 10})
+			if err != nil || len(results) != 1 {
+				t.Fatalf("%s: search %q: %v, %v", name, q, results, err)
+			}
+			if got := results[0].Snippet; got != want {
+				t.Errorf("%s: search %q: snippet %q, want %q", name, q, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+var testNotice = []byte(`{"type":"server_shutdown","reconnectAfterMs":1000,"jitterMs":500}`)
+
+func TestShutdownNotifiesAndClosesConnections(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	if err := ts.hub.Shutdown(ctx, testNotice); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	for _, ws := range []*wsClient{aliceWS, bobWS} {
+		var notice models.WSServerShutdown
+		ws.next(t, "server_shutdown", &notice)
+		if notice.ReconnectAfterMs != 1000 || notice.JitterMs != 500 {
+			t.Errorf("user %d: notice = %+v", ws.userID, notice)
+		}
+		ws.expectClosed(t)
+	}
+	if ts.hub.Register(Registration{Client: &Client{userID: alice}}) {
+		t.Error("stopped hub accepted a registration")
+	}
+
+	// Nothing sent to a stopped hub may block the caller, e.g. an admin
+	// disconnect or a frame still being handled.
+	returned := make(chan int)
+	go func() {
+		c := &Client{userID: alice}
+		ts.hub.Subscribe(Subscription{ChannelID: 1, Client: c})
+		ts.hub.Unsubscribe(Subscription{ChannelID: 1, Client: c})
+		ts.hub.SetStatus(StatusUpdate{UserID: alice, Status: models.PresenceAway})
+		ts.hub.Broadcast(BroadcastMessage{ChannelID: 1, Data: []byte(`{}`)})
+		returned <- ts.hub.Disconnect(alice, "")
+	}()
+	select {
+	case n := <-returned:
+		if n != 0 {
+			t.Errorf("Disconnect on a stopped hub = %d, want 0", n)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("a call to the stopped hub blocked")
+	}
+}
+
+// slowStore holds InsertMessage until release is closed.
+type slowStore struct {
+	store.Store
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) InsertMessage(ctx context.Context, channelID, senderID int, content string) (models.Message, error) {
+	close(s.entered)
+	<-s.release
+	return s.Store.InsertMessage(ctx, channelID, senderID, content)
+}
+
+func TestShutdownWaitsForInFlightMessages(t *testing.T) {
+	db := &slowStore{Store: store.NewMemory(), entered: make(chan struct{}), release: make(chan struct{})}
+	ts := newTestServerWithStore(t, db)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+
+	aliceWS.sendMessage(t, channel, "last words")
+	<-db.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- ts.hub.Shutdown(ctx, testNotice) }()
+
+	// Clients hear about the shutdown while the insert is still running...
+	var notice models.WSServerShutdown
+	bobWS.next(t, "server_shutdown", &notice)
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown returned before the insert finished: %v", err)
+	default:
+	}
+
+	// ...and still get the message before their connection is closed.
+	close(db.release)
+	if got := bobWS.nextMessage(t); got.Content != "last words" {
+		t.Errorf("bob received %+v", got)
+	}
+	bobWS.expectClosed(t)
+	if err := <-stopped; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	history, err := db.FetchChannelMessages(context.Background(), channel)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("history = %+v, %v", history, err)
+	}
+}
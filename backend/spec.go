@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"chat-app/backend/apispec"
+	"chat-app/backend/models"
+)
+
+// specVersion is the version of the published API documents. Bump it with
+// every change to the routes or payloads.
+const specVersion = "1.1.0"
+
+// Parameters shared by several operations.
+var (
+	userIDQuery = apispec.Param{Name: "user_id", In: "query", Required: true, Example: 0,
+		Description: "The user making the request."}
+	userIDPath       = apispec.Param{Name: "user_id", In: "path", Example: 0}
+	channelIDPath    = apispec.Param{Name: "channel_id", In: "path", Example: 0}
+	attachmentIDPath = apispec.Param{Name: "attachment_id", In: "path", Example: 0}
+)
+
+// adminSecurity names the bearer token scheme of the admin routes.
+var adminSecurity = []string{"adminToken"}
+
+// apiOperations lists every route of the REST API. The schemas come from
+// the types given here, so a route's entry has to change along with the
+// types its handler reads and writes; TestOpenAPIMatchesRouter and
+// TestOpenAPIMatchesResponses catch the ones that don't.
+func apiOperations() []apispec.Operation {
+	return []apispec.Operation{
+		{Method: "POST", Path: v1("/users"), ID: "createUser", Tag: "users",
+			Summary: "Create a user",
+			Body:    models.CreateUserRequest{}, Response: models.CreateUserResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge}},
+		{Method: "GET", Path: v1("/users"), ID: "lookUpUser", Tag: "users",
+			Summary:  "Look a user up by name",
+			Params:   []apispec.Param{{Name: "username", In: "query", Required: true}},
+			Response: models.UserLookup{},
+			Errors:   []int{http.StatusBadRequest}},
+		{Method: "GET", Path: v1("/users/{user_id}/channels"), ID: "listUserChannels", Tag: "users",
+			Summary:  "List the channels a user belongs to",
+			Params:   []apispec.Param{userIDPath},
+			Response: []models.Channel{},
+			Errors:   []int{http.StatusBadRequest}},
+
+		{Method: "POST", Path: v1("/channels"), ID: "createChannel", Tag: "channels",
+			Summary: "Create a channel",
+			Description: "A DIRECT channel has exactly two members and no name. " +
+				"A GROUP channel needs a name and at least two members.",
+			Body: models.CreateChannelRequest{}, Response: models.CreateChannelResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge}},
+		{Method: "GET", Path: v1("/channels/{channel_id}/messages"), ID: "listMessages", Tag: "channels",
+			Summary: "List the messages of a channel",
+			Description: "Messages come oldest first. Without paging parameters the whole history is " +
+				"returned. Otherwise the page holds up to limit messages (50 by default, at most 200): " +
+				"the newest ones before the message id before, or the oldest ones after the id after. " +
+				"A page shorter than limit is the last one in that direction.",
+			Params: []apispec.Param{channelIDPath,
+				{Name: "before", In: "query", Example: 0, Description: "Page backwards from this message id."},
+				{Name: "after", In: "query", Example: 0, Description: "Page forwards from this message id."},
+				{Name: "limit", In: "query", Example: 0}},
+			Response: []models.Message{},
+			Errors:   []int{http.StatusBadRequest}},
+		{Method: "POST", Path: v1("/channels/{channel_id}/members"), ID: "addMember", Tag: "channels",
+			Summary: "Add a user to a channel",
+			Params:  []apispec.Param{channelIDPath},
+			Body:    models.AddMemberRequest{}, Response: models.AddMemberResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge}},
+
+		{Method: "POST", Path: v1("/channels/{channel_id}/attachments"), ID: "uploadAttachment", Tag: "attachments",
+			Summary: "Upload an attachment",
+			Description: "Stores the file for members of the channel. Send the returned id in the " +
+				"attachmentIDs of a WebSocket message frame to link it to a message. Images " +
+				"get a thumbnail and a preview in the background, announced by an " +
+				"attachment_processed event.",
+			Params:   []apispec.Param{channelIDPath, userIDQuery},
+			FormFile: "file",
+			Status:   http.StatusCreated, Response: models.Attachment{},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden,
+				http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType}},
+		{Method: "GET", Path: v1("/attachments/{attachment_id}"), ID: "downloadAttachment", Tag: "attachments",
+			Summary:     "Download an attachment",
+			Params:      []apispec.Param{attachmentIDPath, userIDQuery},
+			ContentType: "*/*",
+			Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}},
+		{Method: "GET", Path: v1("/attachments/{attachment_id}/thumbnail"), ID: "downloadThumbnail", Tag: "attachments",
+			Summary: "Download a rendition of an image attachment",
+			Params: []apispec.Param{attachmentIDPath, userIDQuery,
+				{Name: "size", In: "query", Description: "thumb (200px, the default) or preview (800px)."}},
+			ContentType: "image/*",
+			Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}},
+
+		{Method: "GET", Path: v1("/search"), ID: "searchMessages", Tag: "messages",
+			Summary: "Search messages in the user's channels",
+			Description: "q is free text plus the filters from:<user id or username>, " +
+				"in:<channel id>, before:YYYY-MM-DD, after:YYYY-MM-DD and has:link.",
+			Params: []apispec.Param{userIDQuery,
+				{Name: "q", In: "query", Required: true},
+				{Name: "limit", In: "query", Example: 0},
+				{Name: "offset", In: "query", Example: 0}},
+			Response: models.SearchPage{},
+			Errors:   []int{http.StatusBadRequest}},
+		{Method: "GET", Path: v1("/presence"), ID: "getPresence", Tag: "users",
+			Summary:  "Get the presence of users",
+			Params:   []apispec.Param{{Name: "user_ids", In: "query", Required: true, Description: "Comma-separated user ids."}},
+			Response: []models.Presence{},
+			Errors:   []int{http.StatusBadRequest}},
+
+		{Method: "GET", Path: v1("/admin/sessions"), ID: "listAllSessions", Tag: "admin",
+			Summary: "List every live connection on this server", Security: adminSecurity,
+			Response: []models.Session{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusForbidden}},
+		{Method: "GET", Path: v1("/admin/origin_violations"), ID: "getOriginViolations", Tag: "admin",
+			Summary: "Count requests refused by the origin checks", Security: adminSecurity,
+			Response: models.OriginViolations{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusForbidden}},
+		{Method: "GET", Path: v1("/admin/users/{user_id}/sessions"), ID: "listSessions", Tag: "admin",
+			Summary: "List a user's live connections", Security: adminSecurity,
+			Params:   []apispec.Param{userIDPath},
+			Response: []models.Session{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden}},
+		{Method: "DELETE", Path: v1("/admin/users/{user_id}/sessions"), ID: "disconnectUser", Tag: "admin",
+			Summary: "Disconnect all of a user's sessions", Security: adminSecurity,
+			Params:   []apispec.Param{userIDPath},
+			Response: models.DisconnectResponse{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden}},
+		{Method: "DELETE", Path: v1("/admin/users/{user_id}/sessions/{session_id}"), ID: "disconnectSession", Tag: "admin",
+			Summary: "Disconnect one session", Security: adminSecurity,
+			Params:   []apispec.Param{userIDPath, {Name: "session_id", In: "path"}},
+			Response: models.DisconnectResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden,
+				http.StatusNotFound}},
+
+		{Method: "GET", Path: v1("/openapi.json"), ID: "getOpenAPI", Tag: "meta",
+			Summary: "This document", ContentType: "application/json"},
+		{Method: "GET", Path: v1("/asyncapi.json"), ID: "getAsyncAPI", Tag: "meta",
+			Summary: "The AsyncAPI document of the WebSocket protocol", ContentType: "application/json"},
+		{Method: "GET", Path: "/livez", ID: "livez", Tag: "meta",
+			Summary:     "Liveness probe",
+			Description: "Answers 503 with the same body when a check fails.",
+			Response:    models.HealthReport{}},
+		{Method: "GET", Path: "/readyz", ID: "readyz", Tag: "meta",
+			Summary:     "Readiness probe",
+			Description: "Answers 503 with the same body when a check fails.",
+			Response:    models.HealthReport{}},
+	}
+}
+
+// openAPIDocument describes the REST API.
+func openAPIDocument() apispec.Object {
+	return apispec.OpenAPI{
+		Info: apispec.Info{
+			Title:   "Chat API",
+			Version: specVersion,
+			Description: "REST routes of the chat server. Real-time traffic goes over the " +
+				"WebSocket at /ws, described by " + v1("/asyncapi.json") + ".",
+		},
+		Operations:   apiOperations(),
+		ErrorBody:    models.ErrorResponse{},
+		CommonErrors: []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+		SecuritySchemes: map[string]apispec.Object{
+			"adminToken": {"type": "http", "scheme": "bearer",
+				"description": "The admin_token (ADMIN_TOKEN) the server was configured with."},
+		},
+	}.Document()
+}
+
+// clientFrames and serverFrames list every WebSocket frame type. Client
+// frames share the WSIncoming payload and differ in which fields they use.
+var (
+	clientFrames = []apispec.Frame{
+		{Name: "Subscribe", Type: "subscribe", Payload: models.WSIncoming{},
+			Summary: "Start receiving a channel's messages.",
+			Example: models.WSIncoming{Type: "subscribe", ChannelID: 3}},
+		{Name: "Unsubscribe", Type: "unsubscribe", Payload: models.WSIncoming{},
+			Summary: "Stop receiving a channel's messages.",
+			Example: models.WSIncoming{Type: "unsubscribe", ChannelID: 3}},
+		{Name: "SendMessage", Type: "message", Payload: models.WSIncoming{},
+			Summary:     "Send a message to a channel.",
+			Description: "text is required unless attachmentIDs links uploaded attachments.",
+			Example:     models.WSIncoming{Type: "message", ChannelID: 3, Text: "see file", AttachmentIDs: []int{12}}},
+		{Name: "SetStatus", Type: "set_status", Payload: models.WSIncoming{},
+			Summary: "Set the user's presence to online, away or dnd.",
+			Description: "away and dnd outlast a disconnect and apply again on the next connection, " +
+				"until the user sets online.",
+			Example: models.WSIncoming{Type: "set_status", Status: models.PresenceAway}},
+		{Name: "StartTyping", Type: "typing", Payload: models.WSIncoming{},
+			Summary: "Tell a channel's other members that the user is typing.",
+			Example: models.WSIncoming{Type: "typing", ChannelID: 3}},
+	}
+	serverFrames = []apispec.Frame{
+		{Name: "Message", Type: "message", Payload: models.WSOutgoing{},
+			Summary: "A message was sent to a subscribed channel."},
+		{Name: "PresenceChanged", Type: "presence_changed", Payload: models.WSPresenceChanged{},
+			Summary: "A user sharing a channel changed presence."},
+		{Name: "Typing", Type: "typing", Payload: models.WSTyping{},
+			Summary: "Another member of a channel is typing."},
+		{Name: "AttachmentProcessed", Type: "attachment_processed", Payload: models.WSAttachmentProcessed{},
+			Summary: "An image attachment's thumbnail and preview are ready."},
+		{Name: "Error", Type: "error", Payload: models.WSError{},
+			Summary: "A frame was refused.",
+			Description: "code is rate_limited (retry after retryAfterMs), muted (frames are refused " +
+				"for retryAfterMs), flood_disconnect (the connection is about to close), " +
+				"invalid_frame (fields maps each broken field to its error), forbidden (a message " +
+				"to a channel the user isn't a member of) or internal (the message couldn't be stored)."},
+		{Name: "ServerShutdown", Type: "server_shutdown", Payload: models.WSServerShutdown{},
+			Summary:     "The server is stopping.",
+			Description: "Reconnect after reconnectAfterMs plus a random delay of up to jitterMs."},
+	}
+)
+
+// asyncAPIDocument describes the WebSocket protocol.
+func asyncAPIDocument() apispec.Object {
+	return apispec.AsyncAPI{
+		Info: apispec.Info{
+			Title:   "Chat WebSocket API",
+			Version: specVersion,
+			Description: "JSON text frames, told apart by their type property. The connection " +
+				"is subscribed to all of the user's channels when it opens.",
+		},
+		Path:        "/ws",
+		Description: "One connection per browser tab. Browsers must send an allowed Origin. Unknown users are refused with 404, disabled ones with 403.",
+		Query:       []apispec.Param{userIDQuery},
+		Publish:     clientFrames,
+		Subscribe:   serverFrames,
+	}.Document()
+}
+
+// HandleSpec serves a generated API document. It is encoded once, when the
+// router is built.
+func HandleSpec(doc apispec.Object) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic("encode API document: " + err.Error())
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(body)
+	}
+}
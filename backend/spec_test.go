@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"chat-app/backend/apispec"
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+	"chat-app/backend/store"
+
+	"github.com/gorilla/mux"
+)
+
+// fetchSpec downloads a served API document.
+func (ts *testServer) fetchSpec(t *testing.T, path string) apispec.Object {
+	t.Helper()
+	var doc apispec.Object
+	ts.do(t, "GET", path, nil, &doc)
+	return doc
+}
+
+// lookup follows a path of object keys through a decoded document.
+func lookup(t *testing.T, doc apispec.Object, keys ...string) apispec.Object {
+	t.Helper()
+	cur := doc
+	for i, k := range keys {
+		next, ok := cur[k].(apispec.Object)
+		if !ok {
+			t.Fatalf("document has no %s", strings.Join(keys[:i+1], "."))
+		}
+		cur = next
+	}
+	return cur
+}
+
+// resolve follows a local $ref such as #/components/schemas/User.
+func resolve(t *testing.T, doc apispec.Object, ref string) apispec.Object {
+	t.Helper()
+	path, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		t.Fatalf("non-local $ref %q", ref)
+	}
+	return lookup(t, doc, strings.Split(path, "/")...)
+}
+
+// checkSchema reports where the decoded JSON value v breaks schema sch of
+// doc: wrong types, missing required properties and properties the schema
+// doesn't document.
+func checkSchema(t *testing.T, doc, sch apispec.Object, v interface{}, at string) {
+	t.Helper()
+	checkSchemaPart(t, doc, sch, v, at, false)
+}
+
+// checkSchemaPart checks one part of an allOf when partial is set, leaving
+// undocumented properties to the caller, which knows all the parts.
+func checkSchemaPart(t *testing.T, doc, sch apispec.Object, v interface{}, at string, partial bool) {
+	t.Helper()
+	if ref, ok := sch["$ref"].(string); ok {
+		sch = resolve(t, doc, ref)
+	}
+	if parts, ok := sch["allOf"].([]interface{}); ok {
+		known := map[string]bool{}
+		for _, p := range parts {
+			part := p.(apispec.Object)
+			if ref, ok := part["$ref"].(string); ok {
+				part = resolve(t, doc, ref)
+			}
+			checkSchemaPart(t, doc, part, v, at, true)
+			for name := range lookupProps(part) {
+				known[name] = true
+			}
+		}
+		if m, ok := v.(map[string]interface{}); ok && !partial {
+			for name := range m {
+				if !known[name] {
+					t.Errorf("%s.%s: property not in the schema", at, name)
+				}
+			}
+		}
+		return
+	}
+
+	if v == nil {
+		if _, typed := sch["type"]; typed && sch["nullable"] != true {
+			t.Errorf("%s: null, but the schema isn't nullable", at)
+		}
+		return
+	}
+	if c, ok := sch["const"]; ok && v != c {
+		t.Errorf("%s: %v, want %v", at, v, c)
+	}
+	if enum, ok := sch["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			t.Errorf("%s: %v not in %v", at, v, enum)
+		}
+	}
+
+	switch sch["type"] {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: %T, want an object", at, v)
+			return
+		}
+		if props := lookupProps(sch); props != nil {
+			for name, val := range m {
+				if ps, ok := props[name].(apispec.Object); ok {
+					checkSchemaPart(t, doc, ps, val, at+"."+name, false)
+				} else if !partial {
+					t.Errorf("%s.%s: property not in the schema", at, name)
+				}
+			}
+		}
+		if required, ok := sch["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := m[name.(string)]; !ok {
+					t.Errorf("%s: missing required %s", at, name)
+				}
+			}
+		}
+		if extra, ok := sch["additionalProperties"].(apispec.Object); ok {
+			for name, val := range m {
+				checkSchemaPart(t, doc, extra, val, at+"."+name, false)
+			}
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			t.Errorf("%s: %T, want an array", at, v)
+			return
+		}
+		for i, item := range items {
+			checkSchemaPart(t, doc, sch["items"].(apispec.Object), item, fmt.Sprintf("%s[%d]", at, i), false)
+		}
+		checkBounds(t, sch, "minItems", "maxItems", float64(len(items)), at+" length")
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			t.Errorf("%s: %T, want a string", at, v)
+		}
+		checkBounds(t, sch, "minLength", "maxLength", float64(utf8.RuneCountInString(str)), at+" length")
+	case "integer":
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) {
+			t.Errorf("%s: %v, want an integer", at, v)
+		}
+		checkBounds(t, sch, "minimum", "maximum", f, at)
+	case "number":
+		f, ok := v.(float64)
+		if !ok {
+			t.Errorf("%s: %T, want a number", at, v)
+		}
+		checkBounds(t, sch, "minimum", "maximum", f, at)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%s: %T, want a boolean", at, v)
+		}
+	}
+}
+
+// checkBounds fails if n is outside the schema's min and max keywords.
+func checkBounds(t *testing.T, sch apispec.Object, min, max string, n float64, at string) {
+	t.Helper()
+	if lo, ok := sch[min].(float64); ok && n < lo {
+		t.Errorf("%s: %v, below the %s %v", at, n, min, lo)
+	}
+	if hi, ok := sch[max].(float64); ok && n > hi {
+		t.Errorf("%s: %v, above the %s %v", at, n, max, hi)
+	}
+}
+
+func lookupProps(sch apispec.Object) apispec.Object {
+	props, _ := sch["properties"].(apispec.Object)
+	return props
+}
+
+// checkRefs fails on any $ref in v that doesn't resolve within doc.
+func checkRefs(t *testing.T, doc apispec.Object, v interface{}) {
+	t.Helper()
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			resolve(t, doc, ref)
+		}
+		for _, child := range v {
+			checkRefs(t, doc, child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			checkRefs(t, doc, child)
+		}
+	}
+}
+
+func TestSpecsAreServed(t *testing.T) {
+	ts := newTestServer(t)
+	for path, version := range map[string]string{
+		"/api/v1/openapi.json":  "openapi",
+		"/api/v1/asyncapi.json": "asyncapi",
+	} {
+		doc := ts.fetchSpec(t, path)
+		if _, ok := doc[version].(string); !ok {
+			t.Errorf("%s: no %s version field", path, version)
+		}
+		checkRefs(t, doc, doc)
+	}
+}
+
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.NewMemory()
+	hub := NewHub()
+	r := newRouter(defaultConfig(), NewOriginPolicy(nil), hub, db, files, NewImageProcessor(db, files, hub, 1))
+
+	routed := map[string]bool{}
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tpl, apiPrefix+"/") && tpl != "/livez" && tpl != "/readyz" {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, m := range methods {
+			routed[m+" "+tpl] = true
+		}
+		return nil
+	})
+
+	documented := map[string]bool{}
+	var doc apispec.Object
+	if err := json.Unmarshal(mustJSON(t, openAPIDocument()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for path, item := range lookup(t, doc, "paths") {
+		for method := range item.(apispec.Object) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	for route := range routed {
+		if !documented[route] {
+			t.Errorf("%s is routed but not in the OpenAPI document", route)
+		}
+	}
+	for route := range documented {
+		if !routed[route] {
+			t.Errorf("%s is in the OpenAPI document but not routed", route)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// conforms sends a request to path, which matches the route template tpl,
+// and checks that the status is documented for the operation and that the
+// JSON body matches its schema. It returns the decoded body.
+func (ts *testServer) conforms(t *testing.T, doc apispec.Object, method, tpl, path string, body interface{}, wantStatus int) interface{} {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(mustJSON(t, body))
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(t, doc, method, tpl, resp, wantStatus)
+}
+
+func checkResponse(t *testing.T, doc apispec.Object, method, tpl string, resp *http.Response, wantStatus int) interface{} {
+	t.Helper()
+	op := method + " " + tpl
+	if resp.StatusCode != wantStatus {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s: %d, want %d: %s", op, resp.StatusCode, wantStatus, data)
+	}
+	documented := lookup(t, doc, "paths", tpl, strings.ToLower(method), "responses")
+	r, ok := documented[strconv.Itoa(resp.StatusCode)].(apispec.Object)
+	if !ok {
+		t.Fatalf("%s: status %d isn't documented", op, resp.StatusCode)
+	}
+	content, ok := r["content"].(apispec.Object)["application/json"].(apispec.Object)
+	if !ok {
+		t.Fatalf("%s: status %d has no documented JSON body", op, resp.StatusCode)
+	}
+	var v interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("%s: decode response: %v", op, err)
+	}
+	checkSchema(t, doc, content["schema"].(apispec.Object), v, op)
+	return v
+}
+
+const testAdminToken = "spec-test-token"
+
+// testPNG is a small image for upload tests.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// upload posts data as the multipart file of an attachment upload.
+func (ts *testServer) upload(t *testing.T, channelID, userID int, data []byte) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "pixel.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	resp, err := ts.Client().Post(fmt.Sprintf("%s/api/v1/channels/%d/attachments?user_id=%d", ts.URL, channelID, userID),
+		mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestOpenAPIMatchesResponses(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AdminToken = testAdminToken
+	ts := newTestServerWithConfig(t, cfg, store.NewMemory())
+	doc := ts.fetchSpec(t, "/api/v1/openapi.json")
+
+	user := func(name string) int {
+		v := ts.conforms(t, doc, "POST", "/api/v1/users", "/api/v1/users", models.CreateUserRequest{Username: name}, 200)
+		return int(v.(map[string]interface{})["user_id"].(float64))
+	}
+	alice, bob := user("alice"), user("bob")
+	ts.conforms(t, doc, "POST", "/api/v1/users", "/api/v1/users", models.CreateUserRequest{Username: "no spaces"}, 400)
+	ts.conforms(t, doc, "GET", "/api/v1/users", "/api/v1/users?username=bob", nil, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/users", "/api/v1/users?username=nobody", nil, 200)
+
+	v := ts.conforms(t, doc, "POST", "/api/v1/channels", "/api/v1/channels",
+		models.CreateChannelRequest{ChannelType: "GROUP", ChannelName: "general", UserIDs: []int{alice, bob}}, 200)
+	channel := int(v.(map[string]interface{})["channel_id"].(float64))
+	carol := user("carol")
+	ts.conforms(t, doc, "POST", "/api/v1/channels/{channel_id}/members",
+		fmt.Sprintf("/api/v1/channels/%d/members", channel), models.AddMemberRequest{UserID: carol}, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/users/{user_id}/channels", fmt.Sprintf("/api/v1/users/%d/channels", alice), nil, 200)
+
+	resp := ts.upload(t, channel, alice, testPNG(t))
+	att := checkResponse(t, doc, "POST", "/api/v1/channels/{channel_id}/attachments", resp, http.StatusCreated)
+	attID := int(att.(map[string]interface{})["id"].(float64))
+	resp = ts.upload(t, channel, alice, []byte("\x00\x01binary"))
+	checkResponse(t, doc, "POST", "/api/v1/channels/{channel_id}/attachments", resp, http.StatusUnsupportedMediaType)
+
+	ws := ts.dial(t, alice)
+	ws.send(t, models.WSIncoming{Type: "message", ChannelID: channel, Text: "deploy done", AttachmentIDs: []int{attID}})
+	ws.nextMessage(t)
+	ts.conforms(t, doc, "GET", "/api/v1/channels/{channel_id}/messages", fmt.Sprintf("/api/v1/channels/%d/messages", channel), nil, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/channels/{channel_id}/messages", "/api/v1/channels/x/messages", nil, 400)
+	ts.conforms(t, doc, "GET", "/api/v1/search", fmt.Sprintf("/api/v1/search?user_id=%d&q=deploy&limit=1", alice), nil, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/presence", fmt.Sprintf("/api/v1/presence?user_ids=%d,%d", alice, bob), nil, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/attachments/{attachment_id}", fmt.Sprintf("/api/v1/attachments/%d?user_id=%d", attID+100, alice), nil, 404)
+
+	ts.conforms(t, doc, "GET", "/api/v1/admin/sessions", "/api/v1/admin/sessions", nil, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/admin/origin_violations", "/api/v1/admin/origin_violations", nil, 200)
+	ts.conforms(t, doc, "GET", "/api/v1/admin/users/{user_id}/sessions", fmt.Sprintf("/api/v1/admin/users/%d/sessions", alice), nil, 200)
+	ts.conforms(t, doc, "DELETE", "/api/v1/admin/users/{user_id}/sessions/{session_id}",
+		fmt.Sprintf("/api/v1/admin/users/%d/sessions/nope", alice), nil, 404)
+	ts.conforms(t, doc, "DELETE", "/api/v1/admin/users/{user_id}/sessions", fmt.Sprintf("/api/v1/admin/users/%d/sessions", alice), nil, 200)
+
+	ts.conforms(t, doc, "GET", "/livez", "/livez", nil, 200)
+	ts.conforms(t, doc, "GET", "/readyz", "/readyz", nil, 200)
+}
+
+// asyncMessages returns the messages the AsyncAPI document lists for one
+// direction, "publish" or "subscribe", keyed by frame type.
+func asyncMessages(t *testing.T, doc apispec.Object, direction string) map[string]apispec.Object {
+	t.Helper()
+	msgs := map[string]apispec.Object{}
+	for _, ref := range lookup(t, doc, "channels", "/ws", direction, "message")["oneOf"].([]interface{}) {
+		msg := resolve(t, doc, ref.(apispec.Object)["$ref"].(string))
+		msgs[msg["name"].(string)] = msg
+	}
+	return msgs
+}
+
+func TestAsyncAPIMatchesFrames(t *testing.T) {
+	ts := newTestServer(t)
+	doc := ts.fetchSpec(t, "/api/v1/asyncapi.json")
+	server := asyncMessages(t, doc, "subscribe")
+
+	seen := map[string]bool{}
+	expect := func(ws *wsClient, frameType string) {
+		t.Helper()
+		var raw json.RawMessage
+		ws.next(t, frameType, &raw)
+		msg, ok := server[frameType]
+		if !ok {
+			t.Fatalf("server frame %q isn't in the AsyncAPI document", frameType)
+		}
+		var v interface{}
+		json.Unmarshal(raw, &v)
+		checkSchema(t, doc, msg["payload"].(apispec.Object), v, frameType)
+		seen[frameType] = true
+	}
+
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+
+	aliceWS.send(t, models.WSIncoming{Type: "typing", ChannelID: channel})
+	expect(bobWS, "typing")
+
+	resp := ts.upload(t, channel, alice, testPNG(t))
+	var att models.Attachment
+	if err := json.NewDecoder(resp.Body).Decode(&att); err != nil {
+		t.Fatal(err)
+	}
+	expect(bobWS, "attachment_processed")
+	aliceWS.send(t, models.WSIncoming{Type: "message", ChannelID: channel, Text: "pixel", AttachmentIDs: []int{att.ID}})
+	expect(bobWS, "message")
+
+	aliceWS.send(t, models.WSIncoming{Type: "set_status", Status: models.PresenceAway})
+	expect(bobWS, "presence_changed")
+
+	aliceWS.send(t, models.WSIncoming{Type: "message"})
+	expect(aliceWS, "error")
+
+	notice := mustJSON(t, models.WSServerShutdown{Type: "server_shutdown", ReconnectAfterMs: 1000, JitterMs: 500})
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	if err := ts.hub.Shutdown(ctx, notice); err != nil {
+		t.Fatal(err)
+	}
+	expect(bobWS, "server_shutdown")
+
+	for frameType := range server {
+		if !seen[frameType] {
+			t.Errorf("server frame %q is documented but this test doesn't produce it", frameType)
+		}
+	}
+}
+
+func TestAsyncAPIClientFrames(t *testing.T) {
+	ts := newTestServer(t)
+	doc := ts.fetchSpec(t, "/api/v1/asyncapi.json")
+	client := asyncMessages(t, doc, "publish")
+
+	// Every frame type the server accepts is documented, and nothing else.
+	var accepted, documented []string
+	for _, rule := range strings.Split(reflect.TypeOf(models.WSIncoming{}).Field(0).Tag.Get("validate"), ",") {
+		if types, ok := strings.CutPrefix(rule, "oneof="); ok {
+			accepted = strings.Fields(types)
+		}
+	}
+	for frameType, msg := range client {
+		documented = append(documented, frameType)
+		for _, ex := range msg["examples"].([]interface{}) {
+			checkSchema(t, doc, msg["payload"].(apispec.Object), ex.(apispec.Object)["payload"], frameType+" example")
+		}
+	}
+	sort.Strings(accepted)
+	sort.Strings(documented)
+	if !reflect.DeepEqual(accepted, documented) {
+		t.Errorf("documented client frames %v, the server accepts %v", documented, accepted)
+	}
+}
+
+// TestAsyncAPICoversFrameTypes fails when a WS* type in the models package
+// isn't the payload of any documented frame.
+func TestAsyncAPICoversFrameTypes(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "models/model.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]bool{}
+	for _, frame := range append(append([]apispec.Frame{}, clientFrames...), serverFrames...) {
+		payloads[reflect.TypeOf(frame.Payload).Name()] = true
+	}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			name := spec.(*ast.TypeSpec).Name.Name
+			if strings.HasPrefix(name, "WS") && !payloads[name] {
+				t.Errorf("models.%s is not the payload of any frame in the AsyncAPI document", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// sqlLimiter is the LimiterStore shared through the application database.
+// A token bucket needs a read-modify-write per request, so it uses a sliding
+// window counter instead: requests are counted per fixed window, and the
+// previous window's count is weighted by how much of it the sliding window
+// still covers. The rule maps to a window of Burst requests per
+// Burst/RequestsPerSecond, which keeps both the sustained rate and the burst.
+//
+// Windows are aligned on the Unix epoch and read from the local clock, so
+// instances need reasonably synchronised clocks; skew of a few hundred
+// milliseconds only shifts which window a request lands in.
+type sqlLimiter struct {
+	db        *sql.DB
+	idle      time.Duration
+	lastSweep atomic.Int64 // Unix milliseconds
+}
+
+func newSQLLimiter(db *sql.DB, idle time.Duration) *sqlLimiter {
+	l := &sqlLimiter{db: db, idle: idle}
+	l.lastSweep.Store(time.Now().UnixMilli())
+	return l
+}
+
+// slidingWindow is the window length the database store uses for rule.
+func slidingWindow(rule RateLimitRule) time.Duration {
+	w := time.Duration(float64(rule.Burst) / rule.RequestsPerSecond * float64(time.Second))
+	return max(w, time.Millisecond)
+}
+
+// takeQuery counts a request in the current window ($2) unless the
+// weighted previous window ($3, weight $4) plus the current one would pass
+// the limit ($5). The conditional upsert is atomic, so concurrent requests
+// from several instances can't overshoot. It returns no row when refused.
+const takeQuery = `
+    INSERT INTO rate_limit_windows (key, window_start, hits)
+    SELECT CAST($1 AS TEXT), CAST($2 AS BIGINT), 1
+    WHERE COALESCE((SELECT hits FROM rate_limit_windows WHERE key = $1 AND window_start = $3), 0)
+          * CAST($4 AS DOUBLE PRECISION) + 1 <= $5
+    ON CONFLICT (key, window_start) DO UPDATE SET hits = rate_limit_windows.hits + 1
+    WHERE COALESCE((SELECT p.hits FROM rate_limit_windows p WHERE p.key = $1 AND p.window_start = $3), 0)
+          * CAST($4 AS DOUBLE PRECISION) + rate_limit_windows.hits + 1 <= $5
+    RETURNING hits,
+        COALESCE((SELECT p.hits FROM rate_limit_windows p WHERE p.key = $1 AND p.window_start = $3), 0)`
+
+// Allow implements LimiterStore.
+func (l *sqlLimiter) Allow(ctx context.Context, key string, rule RateLimitRule, now time.Time) (rateDecision, error) {
+	l.sweep(now)
+
+	w := slidingWindow(rule).Milliseconds()
+	nowMs := now.UnixMilli()
+	start := nowMs - nowMs%w
+	weight := 1 - float64(nowMs-start)/float64(w)
+
+	d := rateDecision{Allowed: true, Limit: rule.Burst}
+	var cur, prev int
+	err := l.db.QueryRowContext(ctx, takeQuery, key, start, start-w, weight, rule.Burst).Scan(&cur, &prev)
+	if errors.Is(err, sql.ErrNoRows) {
+		d.Allowed = false
+		err = l.db.QueryRowContext(ctx, `
+            SELECT COALESCE(SUM(CASE WHEN window_start = $2 THEN hits END), 0),
+                   COALESCE(SUM(CASE WHEN window_start = $3 THEN hits END), 0)
+            FROM rate_limit_windows WHERE key = $1 AND window_start IN ($2, $3)`,
+			key, start, start-w).Scan(&cur, &prev)
+	}
+	if err != nil {
+		return rateDecision{}, err
+	}
+
+	window := time.Duration(w) * time.Millisecond
+	untilEnd := time.Duration(start+w-nowMs) * time.Millisecond
+	used := float64(prev)*weight + float64(cur)
+	d.Remaining = int(math.Max(0, math.Floor(float64(rule.Burst)-used)))
+	switch {
+	case cur > 0:
+		// The current window stops counting once the next one has passed.
+		d.Reset = untilEnd + window
+	case prev > 0:
+		d.Reset = untilEnd
+	}
+	if !d.Allowed {
+		d.RetryAfter = slidingRetry(prev, cur, rule.Burst, weight, window, untilEnd)
+	}
+	return d, nil
+}
+
+// slidingRetry is how long until one more request fits under limit: later
+// in this window if the previous window's share decays enough, otherwise
+// once this window's own share has decayed far enough into the next one.
+func slidingRetry(prev, cur, limit int, weight float64, window, untilEnd time.Duration) time.Duration {
+	if cur+1 <= limit && prev > 0 {
+		share := weight - float64(limit-cur-1)/float64(prev)
+		return time.Duration(math.Max(0, share) * float64(window))
+	}
+	if cur == 0 {
+		return untilEnd
+	}
+	share := 1 - float64(limit-1)/float64(cur)
+	return untilEnd + time.Duration(math.Max(0, share)*float64(window))
+}
+
+// sweep deletes windows older than the idle timeout, at most twice per idle
+// period per instance, in the background so no request waits for it.
+func (l *sqlLimiter) sweep(now time.Time) {
+	last := l.lastSweep.Load()
+	if now.UnixMilli()-last < l.idle.Milliseconds()/2 || !l.lastSweep.CompareAndSwap(last, now.UnixMilli()) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		cutoff := now.Add(-l.idle).UnixMilli()
+		if _, err := l.db.ExecContext(ctx, `DELETE FROM rate_limit_windows WHERE window_start < $1`, cutoff); err != nil {
+			slog.Warn("rate limit window cleanup failed", "err", err)
+		}
+	}()
+}
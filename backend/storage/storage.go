@@ -0,0 +1,24 @@
+// Package storage holds the blob stores used for message attachments.
+// Only a local-filesystem backend exists today; an S3-compatible backend can
+// be added later by implementing Storage.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Open and Delete when no object has the key.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage stores opaque blobs under slash-separated keys such as
+// "channels/3/9f2c1e...".
+type Storage interface {
+	// Put writes the contents of r under key and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open returns a reader for the object stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,424 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-app/backend/models"
+)
+
+// Memory is a Store that keeps everything in process memory. It is meant for
+// tests and throwaway local servers: nothing survives a restart.
+type Memory struct {
+	mu          sync.RWMutex
+	seq         map[string]int // per-table ID sequences, like SERIAL columns
+	users       map[int]*models.User
+	channels    map[int]models.Channel
+	members     map[int]map[int]bool // channel ID -> user IDs
+	messages    []models.Message
+	attachments map[int]models.Attachment
+}
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{
+		seq:         make(map[string]int),
+		users:       make(map[int]*models.User),
+		channels:    make(map[int]models.Channel),
+		members:     make(map[int]map[int]bool),
+		attachments: make(map[int]models.Attachment),
+	}
+}
+
+// id hands out the next ID for a table. Callers must hold mu.
+func (m *Memory) id(table string) int {
+	m.seq[table]++
+	return m.seq[table]
+}
+
+func (m *Memory) Ping(ctx context.Context) error { return nil }
+func (m *Memory) Close() error                   { return nil }
+
+// CreateUser adds a user. Usernames are unique, as in the SQL schema.
+func (m *Memory) CreateUser(ctx context.Context, username string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			return 0, fmt.Errorf("store: username %q already exists", username)
+		}
+	}
+	id := m.id("users")
+	m.users[id] = &models.User{ID: id, Username: username}
+	return id, nil
+}
+
+// GetUserByUsername looks a user up by name. It returns ErrNotFound if there is none.
+func (m *Memory) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			return *u, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+// GetUser looks a user up by ID. It returns ErrNotFound if there is none.
+func (m *Memory) GetUser(ctx context.Context, userID int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if u, ok := m.users[userID]; ok {
+		return *u, nil
+	}
+	return models.User{}, ErrNotFound
+}
+
+// ListUsers returns every user, oldest first.
+func (m *Memory) ListUsers(ctx context.Context) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]models.User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// SetUserDisabled locks a user out or lets them back in. It returns
+// ErrNotFound if the user does not exist.
+func (m *Memory) SetUserDisabled(ctx context.Context, userID int, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	switch {
+	case !disabled:
+		u.DisabledAt = nil
+	case u.DisabledAt == nil:
+		now := time.Now().UTC()
+		u.DisabledAt = &now
+	}
+	return nil
+}
+
+// UpdateLastSeen records when the user's last connection closed.
+func (m *Memory) UpdateLastSeen(ctx context.Context, userID int, seenAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[userID]; ok {
+		u.LastSeenAt = &seenAt
+	}
+	return nil
+}
+
+// FetchLastSeen returns the recorded last_seen_at for each of the given users.
+func (m *Memory) FetchLastSeen(ctx context.Context, userIDs []int) (map[int]time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := make(map[int]time.Time)
+	for _, id := range userIDs {
+		if u, ok := m.users[id]; ok && u.LastSeenAt != nil {
+			seen[id] = *u.LastSeenAt
+		}
+	}
+	return seen, nil
+}
+
+// CreateChannel adds a channel. The type must be DIRECT or GROUP, matching
+// the CHECK constraint of the SQL schema.
+func (m *Memory) CreateChannel(ctx context.Context, channelName, channelType string) (int, error) {
+	if channelType != "DIRECT" && channelType != "GROUP" {
+		return 0, fmt.Errorf("store: invalid channel type %q", channelType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.id("channels")
+	m.channels[id] = models.Channel{
+		ID:          id,
+		ChannelName: channelName,
+		ChannelType: channelType,
+		CreatedAt:   time.Now().UTC(),
+	}
+	m.members[id] = make(map[int]bool)
+	return id, nil
+}
+
+// AddChannelMembers associates users with a channel. Like the SQL stores it
+// fails on unknown channels or users and on duplicate members.
+func (m *Memory) AddChannelMembers(ctx context.Context, channelID int, userIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members, ok := m.members[channelID]
+	if !ok {
+		return fmt.Errorf("store: channel %d does not exist", channelID)
+	}
+	for _, uid := range userIDs {
+		if _, ok := m.users[uid]; !ok {
+			return fmt.Errorf("store: user %d does not exist", uid)
+		}
+		if members[uid] {
+			return fmt.Errorf("store: user %d is already a member of channel %d", uid, channelID)
+		}
+		members[uid] = true
+	}
+	return nil
+}
+
+// RemoveChannelMember takes a user out of a channel. It returns ErrNotFound
+// if the user is not a member.
+func (m *Memory) RemoveChannelMember(ctx context.Context, channelID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.members[channelID][userID] {
+		return ErrNotFound
+	}
+	delete(m.members[channelID], userID)
+	return nil
+}
+
+// CheckMembership returns true if the user is a member of the given channel.
+func (m *Memory) CheckMembership(ctx context.Context, channelID, userID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.members[channelID][userID], nil
+}
+
+// GetChannel fetches one channel. It returns ErrNotFound if it does not exist.
+func (m *Memory) GetChannel(ctx context.Context, channelID int) (models.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ch, ok := m.channels[channelID]
+	if !ok {
+		return models.Channel{}, ErrNotFound
+	}
+	return ch, nil
+}
+
+// FetchChannelMembers returns the members of a channel ordered by ID.
+func (m *Memory) FetchChannelMembers(ctx context.Context, channelID int) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var users []models.User
+	for id := range m.members[channelID] {
+		users = append(users, *m.users[id])
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// FetchUserChannels returns the channels a user belongs to, newest first.
+func (m *Memory) FetchUserChannels(ctx context.Context, userID int) ([]models.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var channels []models.Channel
+	for id, members := range m.members {
+		if members[userID] {
+			channels = append(channels, m.channels[id])
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID > channels[j].ID })
+	return channels, nil
+}
+
+// InsertMessage stores a message and returns it with its ID and timestamp.
+func (m *Memory) InsertMessage(ctx context.Context, channelID, senderID int, content string) (models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.channels[channelID]; !ok {
+		return models.Message{}, fmt.Errorf("store: channel %d does not exist", channelID)
+	}
+	msg := models.Message{
+		ID:        m.id("messages"),
+		ChannelID: channelID,
+		SenderID:  senderID,
+		Content:   content,
+		CreatedAt: time.Now().UTC(),
+	}
+	m.messages = append(m.messages, msg)
+	return msg, nil
+}
+
+// FetchChannelMessages retrieves all messages for a channel in chronological order.
+func (m *Memory) FetchChannelMessages(ctx context.Context, channelID int) ([]models.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var msgs []models.Message
+	for _, msg := range m.messages {
+		if msg.ChannelID == channelID {
+			msg.Attachments = m.attachmentsOf(msg.ID)
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// FetchMessagesPage retrieves one page of a channel's history.
+func (m *Memory) FetchMessagesPage(ctx context.Context, channelID int, page models.MessagePage) ([]models.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var msgs []models.Message
+	for _, msg := range m.messages {
+		switch {
+		case msg.ChannelID != channelID,
+			page.AfterID > 0 && msg.ID <= page.AfterID,
+			page.AfterID == 0 && page.BeforeID > 0 && msg.ID >= page.BeforeID:
+			continue
+		}
+		msg.Attachments = m.attachmentsOf(msg.ID)
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) > page.Limit {
+		if page.AfterID > 0 {
+			msgs = msgs[:page.Limit]
+		} else {
+			msgs = msgs[len(msgs)-page.Limit:]
+		}
+	}
+	return msgs, nil
+}
+
+// DeleteMessages removes a channel's messages older than before; see Store.
+func (m *Memory) DeleteMessages(ctx context.Context, channelID int, before time.Time) (int, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	kept := m.messages[:0]
+	deleted := 0
+	for _, msg := range m.messages {
+		if (channelID != 0 && msg.ChannelID != channelID) || (!before.IsZero() && !msg.CreatedAt.Before(before)) {
+			kept = append(kept, msg)
+			continue
+		}
+		for _, a := range m.attachmentsOf(msg.ID) {
+			keys = append(keys, a.StorageKey)
+			for _, k := range []string{a.ThumbnailKey, a.PreviewKey} {
+				if k != "" {
+					keys = append(keys, k)
+				}
+			}
+			delete(m.attachments, a.ID)
+		}
+		deleted++
+	}
+	m.messages = kept
+	return deleted, keys, nil
+}
+
+// SearchMessages does substring matching like the SQLite store. Results
+// come newest first with a zero rank.
+func (m *Memory) SearchMessages(ctx context.Context, userID int, q models.SearchQuery) ([]models.SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	terms := searchTerms(q.Terms)
+	var results []models.SearchResult
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		sender := m.users[msg.SenderID]
+		switch {
+		case !m.members[msg.ChannelID][userID],
+			!matchesAll(msg.Content, terms),
+			q.FromUserID != 0 && msg.SenderID != q.FromUserID,
+			q.FromUsername != "" && (sender == nil || sender.Username != q.FromUsername),
+			q.ChannelID != 0 && msg.ChannelID != q.ChannelID,
+			q.Before != nil && !msg.CreatedAt.Before(*q.Before),
+			q.After != nil && msg.CreatedAt.Before(*q.After),
+			q.HasLink && !hasLink(msg.Content):
+			continue
+		}
+		r := models.SearchResult{Message: msg, Snippet: highlight(msg.Content, terms)}
+		if sender != nil {
+			r.SenderUsername = sender.Username
+		}
+		results = append(results, r)
+	}
+
+	// Mirror LIMIT q.Limit+1 OFFSET q.Offset.
+	if q.Offset >= len(results) {
+		return nil, nil
+	}
+	results = results[q.Offset:]
+	if len(results) > q.Limit+1 {
+		results = results[:q.Limit+1]
+	}
+	return results, nil
+}
+
+// InsertAttachment records an uploaded file that is not linked to a message yet.
+func (m *Memory) InsertAttachment(ctx context.Context, a models.Attachment) (models.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.ID = m.id("attachments")
+	a.MessageID = nil
+	a.CreatedAt = time.Now().UTC()
+	setRenditionURLs(&a)
+	m.attachments[a.ID] = a
+	return a, nil
+}
+
+// GetAttachment fetches one attachment. It returns ErrNotFound if it does not exist.
+func (m *Memory) GetAttachment(ctx context.Context, attachmentID int) (models.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.attachments[attachmentID]
+	if !ok {
+		return models.Attachment{}, ErrNotFound
+	}
+	return a, nil
+}
+
+// LinkAttachments links the sender's unlinked attachments in the channel to a message.
+func (m *Memory) LinkAttachments(ctx context.Context, messageID, channelID, uploaderID int, attachmentIDs []int) ([]models.Attachment, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range attachmentIDs {
+		a, ok := m.attachments[id]
+		if !ok || a.ChannelID != channelID || a.UploaderID != uploaderID || a.MessageID != nil {
+			continue
+		}
+		msgID := messageID
+		a.MessageID = &msgID
+		m.attachments[id] = a
+	}
+	return m.attachmentsOf(messageID), nil
+}
+
+// MarkAttachmentProcessed stores the image dimensions and rendition keys.
+func (m *Memory) MarkAttachmentProcessed(ctx context.Context, attachmentID, width, height int, thumbnailKey, previewKey string) (models.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attachments[attachmentID]
+	if !ok {
+		return models.Attachment{}, ErrNotFound
+	}
+	now := time.Now().UTC()
+	a.Width, a.Height = width, height
+	a.ThumbnailKey, a.PreviewKey = thumbnailKey, previewKey
+	a.ProcessedAt = &now
+	setRenditionURLs(&a)
+	m.attachments[attachmentID] = a
+	return a, nil
+}
+
+// attachmentsOf returns a message's attachments ordered by ID. Callers must hold mu.
+func (m *Memory) attachmentsOf(messageID int) []models.Attachment {
+	var atts []models.Attachment
+	for _, a := range m.attachments {
+		if a.MessageID != nil && *a.MessageID == messageID {
+			atts = append(atts, a)
+		}
+	}
+	sort.Slice(atts, func(i, j int) bool { return atts[i].ID < atts[j].ID })
+	return atts
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"chat-app/backend/models"
+
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Postgres is the Store used in production against Neon.
+type Postgres struct {
+	sqlBase
+}
+
+// OpenPostgres opens a connection to Neon (Postgres) and pings it.
+func OpenPostgres(dsn string) (*Postgres, error) {
+	db, err := openTraced("postgres", dsn, semconv.DBSystemPostgreSQL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open DB: %w", err)
+	}
+	// Verify connectivity
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to ping DB: %w", err)
+	}
+	return NewPostgres(db), nil
+}
+
+// NewPostgres wraps an already opened database handle.
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{sqlBase{db: db}}
+}
+
+func (p *Postgres) Dialect() string { return DialectPostgres }
+
+// FetchLastSeen returns the persisted last_seen_at for each of the given
+// users. Users that were never seen are left out of the map.
+func (p *Postgres) FetchLastSeen(ctx context.Context, userIDs []int) (map[int]time.Time, error) {
+	rows, err := p.db.QueryContext(ctx, `
+        SELECT id, last_seen_at
+        FROM users
+        WHERE id = ANY($1) AND last_seen_at IS NOT NULL
+    `, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			return nil, err
+		}
+		seen[id] = t
+	}
+	return seen, rows.Err()
+}
+
+// FetchChannelMessages retrieves all messages for a channel in chronological order.
+func (p *Postgres) FetchChannelMessages(ctx context.Context, channelID int) ([]models.Message, error) {
+	rows, err := p.db.QueryContext(ctx, `
+        SELECT id, channel_id, sender_id, content, created_at
+        FROM messages
+        WHERE channel_id = $1
+        ORDER BY created_at ASC
+    `, channelID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, p.attachToMessages(ctx, msgs)
+}
+
+// FetchMessagesPage retrieves one page of a channel's history.
+func (p *Postgres) FetchMessagesPage(ctx context.Context, channelID int, page models.MessagePage) ([]models.Message, error) {
+	msgs, err := p.queryMessagesPage(ctx, channelID, page)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, p.attachToMessages(ctx, msgs)
+}
+
+// DeleteMessages removes a channel's messages older than before; see Store.
+func (p *Postgres) DeleteMessages(ctx context.Context, channelID int, before time.Time) (int, []string, error) {
+	if before.IsZero() {
+		before = endOfTime
+	}
+	return p.deleteMessages(ctx, channelID, before)
+}
+
+// attachToMessages loads the attachments of the given messages in one query.
+func (p *Postgres) attachToMessages(ctx context.Context, msgs []models.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	atts, err := p.queryAttachments(ctx, `WHERE message_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	groupAttachments(msgs, atts)
+	return nil
+}
+
+// SearchMessages runs a full-text search over the messages in channels the
+// user is a member of. It fetches one extra row so the caller can tell
+// whether another page exists.
+func (p *Postgres) SearchMessages(ctx context.Context, userID int, q models.SearchQuery) ([]models.SearchResult, error) {
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Snippets are HTML: the content is escaped the way html.EscapeString
+	// does it before ts_headline adds the marks.
+	escaped := `replace(replace(replace(replace(replace(m.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+	snippet := escaped
+	rank := "0::real"
+	where := []string{
+		"m.channel_id IN (SELECT channel_id FROM channel_members WHERE user_id = $1)",
+	}
+	if q.Terms != "" {
+		tsq := "websearch_to_tsquery('english', " + arg(q.Terms) + ")"
+		where = append(where, "m.content_tsv @@ "+tsq)
+		rank = "ts_rank(m.content_tsv, " + tsq + ")"
+		snippet = "ts_headline('english', " + escaped + ", " + tsq +
+			", 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2')"
+	}
+	if q.FromUserID != 0 {
+		where = append(where, "m.sender_id = "+arg(q.FromUserID))
+	}
+	if q.FromUsername != "" {
+		where = append(where, "u.username = "+arg(q.FromUsername))
+	}
+	if q.ChannelID != 0 {
+		where = append(where, "m.channel_id = "+arg(q.ChannelID))
+	}
+	if q.Before != nil {
+		where = append(where, "m.created_at < "+arg(*q.Before))
+	}
+	if q.After != nil {
+		where = append(where, "m.created_at >= "+arg(*q.After))
+	}
+	if q.HasLink {
+		where = append(where, `m.content ~* 'https?://'`)
+	}
+
+	query := fmt.Sprintf(`
+        SELECT m.id, m.channel_id, m.sender_id, m.content, m.created_at,
+               u.username, %s, %s
+        FROM messages m
+        JOIN users u ON u.id = m.sender_id
+        WHERE %s
+        ORDER BY 8 DESC, m.created_at DESC
+        LIMIT %s OFFSET %s
+    `, snippet, rank, strings.Join(where, " AND "), arg(q.Limit+1), arg(q.Offset))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		err := rows.Scan(&r.ID, &r.ChannelID, &r.SenderID, &r.Content, &r.CreatedAt,
+			&r.SenderUsername, &r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// LinkAttachments links attachments to a message. Only unlinked attachments
+// uploaded by the sender to the same channel are linked; the rest are ignored.
+func (p *Postgres) LinkAttachments(ctx context.Context, messageID, channelID, uploaderID int, attachmentIDs []int) ([]models.Attachment, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+	_, err := p.db.ExecContext(ctx, `
+        UPDATE attachments SET message_id = $1
+        WHERE id = ANY($2) AND channel_id = $3 AND uploader_id = $4 AND message_id IS NULL
+    `, messageID, pq.Array(attachmentIDs), channelID, uploaderID)
+	if err != nil {
+		return nil, err
+	}
+	return p.queryAttachments(ctx, `WHERE message_id = $1 ORDER BY id`, messageID)
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"database/sql"
+
+	"chat-app/backend/models"
+)
+
+// Row scanning shared by the SQL implementations.
+
+const attachmentColumns = `
+        SELECT id, channel_id, message_id, uploader_id, filename, content_type, size_bytes, storage_key,
+               width, height, thumbnail_key, preview_key, processed_at, created_at
+        FROM attachments `
+
+const userColumns = `SELECT id, username, last_seen_at, disabled_at FROM users `
+
+func scanUsers(rows *sql.Rows) ([]models.User, error) {
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		var lastSeen, disabled sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &lastSeen, &disabled); err != nil {
+			return nil, err
+		}
+		if lastSeen.Valid {
+			u.LastSeenAt = &lastSeen.Time
+		}
+		if disabled.Valid {
+			u.DisabledAt = &disabled.Time
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func scanChannels(rows *sql.Rows) ([]models.Channel, error) {
+	defer rows.Close()
+
+	var channels []models.Channel
+	for rows.Next() {
+		var ch models.Channel
+		if err := rows.Scan(&ch.ID, &ch.ChannelName, &ch.ChannelType, &ch.CreatedAt); err != nil {
+			return nil, err
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
+	defer rows.Close()
+
+	var msgs []models.Message
+	for rows.Next() {
+		var m models.Message
+		err := rows.Scan(&m.ID, &m.ChannelID, &m.SenderID, &m.Content, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+func scanAttachments(rows *sql.Rows) ([]models.Attachment, error) {
+	defer rows.Close()
+
+	var atts []models.Attachment
+	for rows.Next() {
+		var a models.Attachment
+		var messageID, width, height sql.NullInt64
+		var thumbnailKey, previewKey sql.NullString
+		var processedAt sql.NullTime
+		err := rows.Scan(&a.ID, &a.ChannelID, &messageID, &a.UploaderID, &a.Filename,
+			&a.ContentType, &a.SizeBytes, &a.StorageKey,
+			&width, &height, &thumbnailKey, &previewKey, &processedAt, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			id := int(messageID.Int64)
+			a.MessageID = &id
+		}
+		a.Width, a.Height = int(width.Int64), int(height.Int64)
+		a.ThumbnailKey, a.PreviewKey = thumbnailKey.String, previewKey.String
+		if processedAt.Valid {
+			a.ProcessedAt = &processedAt.Time
+		}
+		setRenditionURLs(&a)
+		atts = append(atts, a)
+	}
+	return atts, rows.Err()
+}
+
+// groupAttachments hangs each attachment off the message it belongs to.
+func groupAttachments(msgs []models.Message, atts []models.Attachment) {
+	index := make(map[int]int, len(msgs))
+	for i, m := range msgs {
+		index[m.ID] = i
+	}
+	for _, a := range atts {
+		if a.MessageID == nil {
+			continue
+		}
+		if i, ok := index[*a.MessageID]; ok {
+			msgs[i].Attachments = append(msgs[i].Attachments, a)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// Substring search helpers for the stores that have no full-text engine.
+// They approximate websearch_to_tsquery closely enough for local dev: every
+// word or quoted phrase must appear, case-insensitively; OR and -negation
+// are not supported.
+
+// snippetRadius is how many bytes of context are kept around the first match.
+const snippetRadius = 80
+
+// searchTerms splits free text into lower-cased words and quoted phrases.
+func searchTerms(text string) []string {
+	var terms []string
+	for i, part := range strings.Split(text, `"`) {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if i%2 == 1 {
+			terms = append(terms, part) // inside quotes
+			continue
+		}
+		terms = append(terms, strings.Fields(part)...)
+	}
+	return terms
+}
+
+// matchesAll reports whether content contains every term.
+func matchesAll(content string, terms []string) bool {
+	lower := strings.ToLower(content)
+	for _, t := range terms {
+		if !strings.Contains(lower, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasLink mirrors the Postgres has:link filter.
+func hasLink(content string) bool {
+	lower := strings.ToLower(content)
+	return strings.Contains(lower, "http://") || strings.Contains(lower, "https://")
+}
+
+// highlight cuts a window around the first match, HTML-escapes it and wraps
+// every term occurrence in <mark></mark>, like ts_headline does over the
+// escaped content in Postgres.
+func highlight(content string, terms []string) string {
+	lower := strings.ToLower(content)
+	if len(lower) != len(content) || len(terms) == 0 {
+		// Lower-casing changed byte offsets (rare non-ASCII case); skip marking.
+		return html.EscapeString(content)
+	}
+
+	first := -1
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	start, end := 0, len(content)
+	if first > snippetRadius {
+		start = first - snippetRadius
+	}
+	if end-start > 2*snippetRadius {
+		end = start + 2*snippetRadius
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	plain := start // start of the unmatched text not written yet
+	for i := start; i < end; {
+		matched := ""
+		for _, t := range terms {
+			if strings.HasPrefix(lower[i:], t) && len(t) > len(matched) {
+				matched = t
+			}
+		}
+		if matched == "" {
+			i++
+			continue
+		}
+		b.WriteString(html.EscapeString(content[plain:i]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[i : i+len(matched)]))
+		b.WriteString("</mark>")
+		i += len(matched)
+		plain = i
+	}
+	if plain < end {
+		b.WriteString(html.EscapeString(content[plain:end]))
+	}
+	return b.String()
+}
+
+// likePattern escapes a term for use in LIKE ... ESCAPE '\'.
+func likePattern(term string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(term) + "%"
+}
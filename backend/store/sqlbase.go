@@ -0,0 +1,322 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"slices"
+	"time"
+
+	"chat-app/backend/models"
+)
+
+// sqlBase holds the queries that read the same in Postgres and SQLite.
+// Both dialects accept $N placeholders and RETURNING.
+type sqlBase struct {
+	db *sql.DB
+}
+
+func (b *sqlBase) DB() *sql.DB                    { return b.db }
+func (b *sqlBase) Ping(ctx context.Context) error { return b.db.PingContext(ctx) }
+func (b *sqlBase) Close() error                   { return b.db.Close() }
+
+// CreateUser inserts a new user. For simplicity, no password or auth in this demo.
+func (b *sqlBase) CreateUser(ctx context.Context, username string) (int, error) {
+	var id int
+	query := `INSERT INTO users (username) VALUES ($1) RETURNING id`
+	err := b.db.QueryRowContext(ctx, query, username).Scan(&id)
+	return id, err
+}
+
+// GetUserByUsername looks a user up by name. It returns ErrNotFound if there is none.
+func (b *sqlBase) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	return b.queryUser(ctx, `WHERE username = $1`, username)
+}
+
+// GetUser looks a user up by ID. It returns ErrNotFound if there is none.
+func (b *sqlBase) GetUser(ctx context.Context, userID int) (models.User, error) {
+	return b.queryUser(ctx, `WHERE id = $1`, userID)
+}
+
+// ListUsers returns every user, oldest first.
+func (b *sqlBase) ListUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := b.db.QueryContext(ctx, userColumns+`ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+// SetUserDisabled locks a user out or lets them back in. It returns
+// ErrNotFound if the user does not exist.
+func (b *sqlBase) SetUserDisabled(ctx context.Context, userID int, disabled bool) error {
+	// Disabling twice keeps the original time.
+	res, err := b.db.ExecContext(ctx, `
+        UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, $3) END
+        WHERE id = $1
+    `, userID, disabled, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+func (b *sqlBase) queryUser(ctx context.Context, clause string, args ...interface{}) (models.User, error) {
+	rows, err := b.db.QueryContext(ctx, userColumns+clause, args...)
+	if err != nil {
+		return models.User{}, err
+	}
+	users, err := scanUsers(rows)
+	if err != nil {
+		return models.User{}, err
+	}
+	if len(users) == 0 {
+		return models.User{}, ErrNotFound
+	}
+	return users[0], nil
+}
+
+// expectRow turns an UPDATE or DELETE that matched nothing into ErrNotFound.
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateLastSeen records when the user's last connection closed.
+func (b *sqlBase) UpdateLastSeen(ctx context.Context, userID int, seenAt time.Time) error {
+	_, err := b.db.ExecContext(ctx, `UPDATE users SET last_seen_at = $2 WHERE id = $1`, userID, seenAt)
+	return err
+}
+
+// CreateChannel creates a new channel row. For DIRECT, you typically won't store a channel_name.
+func (b *sqlBase) CreateChannel(ctx context.Context, channelName, channelType string) (int, error) {
+	var channelID int
+	query := `INSERT INTO channels (channel_name, channel_type) VALUES ($1, $2) RETURNING id`
+	err := b.db.QueryRowContext(ctx, query, channelName, channelType).Scan(&channelID)
+	return channelID, err
+}
+
+// AddChannelMembers associates users with a channel.
+func (b *sqlBase) AddChannelMembers(ctx context.Context, channelID int, userIDs []int) error {
+	for _, uid := range userIDs {
+		_, err := b.db.ExecContext(ctx, `INSERT INTO channel_members (channel_id, user_id) VALUES ($1, $2)`, channelID, uid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveChannelMember takes a user out of a channel. It returns ErrNotFound
+// if the user is not a member.
+func (b *sqlBase) RemoveChannelMember(ctx context.Context, channelID, userID int) error {
+	res, err := b.db.ExecContext(ctx, `DELETE FROM channel_members WHERE channel_id = $1 AND user_id = $2`, channelID, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// GetChannel fetches one channel. It returns ErrNotFound if it does not exist.
+func (b *sqlBase) GetChannel(ctx context.Context, channelID int) (models.Channel, error) {
+	rows, err := b.db.QueryContext(ctx, `SELECT id, channel_name, channel_type, created_at FROM channels WHERE id = $1`, channelID)
+	if err != nil {
+		return models.Channel{}, err
+	}
+	channels, err := scanChannels(rows)
+	if err != nil {
+		return models.Channel{}, err
+	}
+	if len(channels) == 0 {
+		return models.Channel{}, ErrNotFound
+	}
+	return channels[0], nil
+}
+
+// FetchChannelMembers returns the members of a channel ordered by ID.
+func (b *sqlBase) FetchChannelMembers(ctx context.Context, channelID int) ([]models.User, error) {
+	rows, err := b.db.QueryContext(ctx, `
+        SELECT u.id, u.username, u.last_seen_at, u.disabled_at
+        FROM channel_members cm
+        JOIN users u ON cm.user_id = u.id
+        WHERE cm.channel_id = $1
+        ORDER BY u.id
+    `, channelID)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+// deleteMessages implements DeleteMessages. before is bound as given, so
+// each dialect passes it in the format its created_at column compares with.
+func (b *sqlBase) deleteMessages(ctx context.Context, channelID int, before interface{}) (int, []string, error) {
+	where := `WHERE ($1 = 0 OR channel_id = $1) AND created_at < $2`
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT storage_key, thumbnail_key, preview_key FROM attachments
+        WHERE message_id IN (SELECT id FROM messages `+where+`)
+    `, channelID, before)
+	if err != nil {
+		return 0, nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		var thumbnailKey, previewKey sql.NullString
+		if err := rows.Scan(&key, &thumbnailKey, &previewKey); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		keys = append(keys, key)
+		for _, k := range []sql.NullString{thumbnailKey, previewKey} {
+			if k.Valid && k.String != "" {
+				keys = append(keys, k.String)
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	// Attachment rows go with their messages (ON DELETE CASCADE).
+	res, err := tx.ExecContext(ctx, `DELETE FROM messages `+where, channelID, before)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(n), keys, tx.Commit()
+}
+
+// queryMessagesPage reads a page of a channel's history, without
+// attachments.
+func (b *sqlBase) queryMessagesPage(ctx context.Context, channelID int, page models.MessagePage) ([]models.Message, error) {
+	query := `
+        SELECT id, channel_id, sender_id, content, created_at
+        FROM messages
+        WHERE channel_id = $1 AND id < $2
+        ORDER BY id DESC
+        LIMIT $3`
+	bound := page.BeforeID
+	if bound == 0 {
+		bound = math.MaxInt32
+	}
+	if page.AfterID > 0 {
+		query = `
+        SELECT id, channel_id, sender_id, content, created_at
+        FROM messages
+        WHERE channel_id = $1 AND id > $2
+        ORDER BY id ASC
+        LIMIT $3`
+		bound = page.AfterID
+	}
+	rows, err := b.db.QueryContext(ctx, query, channelID, bound, page.Limit)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if page.AfterID == 0 {
+		slices.Reverse(msgs)
+	}
+	return msgs, nil
+}
+
+// CheckMembership returns true if the user is a member of the given channel.
+func (b *sqlBase) CheckMembership(ctx context.Context, channelID, userID int) (bool, error) {
+	var count int
+	err := b.db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM channel_members
+        WHERE channel_id = $1 AND user_id = $2
+    `, channelID, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FetchUserChannels returns the channels a user belongs to, newest first.
+func (b *sqlBase) FetchUserChannels(ctx context.Context, userID int) ([]models.Channel, error) {
+	rows, err := b.db.QueryContext(ctx, `
+        SELECT c.id, c.channel_name, c.channel_type, c.created_at
+        FROM channel_members cm
+        JOIN channels c ON cm.channel_id = c.id
+        WHERE cm.user_id = $1
+        ORDER BY c.created_at DESC
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanChannels(rows)
+}
+
+// InsertMessage inserts a new message into the messages table and returns the stored row.
+func (b *sqlBase) InsertMessage(ctx context.Context, channelID, senderID int, content string) (models.Message, error) {
+	m := models.Message{ChannelID: channelID, SenderID: senderID, Content: content}
+	err := b.db.QueryRowContext(ctx, `
+        INSERT INTO messages (channel_id, sender_id, content) VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `, channelID, senderID, content).Scan(&m.ID, &m.CreatedAt)
+	return m, err
+}
+
+// InsertAttachment records an uploaded file that is not linked to a message yet.
+func (b *sqlBase) InsertAttachment(ctx context.Context, a models.Attachment) (models.Attachment, error) {
+	err := b.db.QueryRowContext(ctx, `
+        INSERT INTO attachments (channel_id, uploader_id, filename, content_type, size_bytes, storage_key)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `, a.ChannelID, a.UploaderID, a.Filename, a.ContentType, a.SizeBytes, a.StorageKey).Scan(&a.ID, &a.CreatedAt)
+	setRenditionURLs(&a)
+	return a, err
+}
+
+// GetAttachment fetches one attachment. It returns ErrNotFound if it does not exist.
+func (b *sqlBase) GetAttachment(ctx context.Context, attachmentID int) (models.Attachment, error) {
+	atts, err := b.queryAttachments(ctx, `WHERE id = $1`, attachmentID)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if len(atts) == 0 {
+		return models.Attachment{}, ErrNotFound
+	}
+	return atts[0], nil
+}
+
+// MarkAttachmentProcessed stores the image dimensions and rendition keys.
+func (b *sqlBase) MarkAttachmentProcessed(ctx context.Context, attachmentID, width, height int, thumbnailKey, previewKey string) (models.Attachment, error) {
+	_, err := b.db.ExecContext(ctx, `
+        UPDATE attachments
+        SET width = $2, height = $3, thumbnail_key = $4, preview_key = $5, processed_at = $6
+        WHERE id = $1
+    `, attachmentID, width, height, thumbnailKey, previewKey, time.Now().UTC())
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	return b.GetAttachment(ctx, attachmentID)
+}
+
+func (b *sqlBase) queryAttachments(ctx context.Context, clause string, args ...interface{}) ([]models.Attachment, error) {
+	rows, err := b.db.QueryContext(ctx, attachmentColumns+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"chat-app/backend/models"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTimeFormat matches what CURRENT_TIMESTAMP stores, so bound times
+// compare correctly against column defaults.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// SQLite is a Store for local development backed by a single file. It uses
+// a pure-Go driver, so no cgo or external server is needed.
+type SQLite struct {
+	sqlBase
+}
+
+// OpenSQLite opens (creating if needed) the database file at path. Foreign
+// keys are enforced and writers wait instead of failing on a busy database.
+func OpenSQLite(path string) (*SQLite, error) {
+	if path == "" {
+		path = "chat.db"
+	}
+	dsn := path
+	if !strings.Contains(dsn, "?") {
+		dsn = "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	db, err := openTraced("sqlite", dsn, semconv.DBSystemSqlite)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open SQLite: %w", err)
+	}
+	if path == ":memory:" {
+		// Every connection to :memory: opens a database of its own.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to ping SQLite: %w", err)
+	}
+	return &SQLite{sqlBase{db: db}}, nil
+}
+
+func (s *SQLite) Dialect() string { return DialectSQLite }
+
+// inList appends ids to args and returns a "($n, $n+1, ...)" placeholder list.
+func inList(args []interface{}, ids []int) ([]interface{}, string) {
+	marks := make([]string, len(ids))
+	for i, id := range ids {
+		args = append(args, id)
+		marks[i] = fmt.Sprintf("$%d", len(args))
+	}
+	return args, "(" + strings.Join(marks, ", ") + ")"
+}
+
+// FetchLastSeen returns the persisted last_seen_at for each of the given users.
+func (s *SQLite) FetchLastSeen(ctx context.Context, userIDs []int) (map[int]time.Time, error) {
+	seen := make(map[int]time.Time)
+	if len(userIDs) == 0 {
+		return seen, nil
+	}
+	args, in := inList(nil, userIDs)
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, last_seen_at FROM users
+        WHERE id IN `+in+` AND last_seen_at IS NOT NULL
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			return nil, err
+		}
+		seen[id] = t
+	}
+	return seen, rows.Err()
+}
+
+// FetchChannelMessages retrieves all messages for a channel in chronological order.
+func (s *SQLite) FetchChannelMessages(ctx context.Context, channelID int) ([]models.Message, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, channel_id, sender_id, content, created_at
+        FROM messages
+        WHERE channel_id = $1
+        ORDER BY created_at ASC, id ASC
+    `, channelID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, s.attachToMessages(ctx, msgs)
+}
+
+// FetchMessagesPage retrieves one page of a channel's history.
+func (s *SQLite) FetchMessagesPage(ctx context.Context, channelID int, page models.MessagePage) ([]models.Message, error) {
+	msgs, err := s.queryMessagesPage(ctx, channelID, page)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, s.attachToMessages(ctx, msgs)
+}
+
+// DeleteMessages removes a channel's messages older than before; see Store.
+func (s *SQLite) DeleteMessages(ctx context.Context, channelID int, before time.Time) (int, []string, error) {
+	if before.IsZero() {
+		before = endOfTime
+	}
+	return s.deleteMessages(ctx, channelID, before.UTC().Format(sqliteTimeFormat))
+}
+
+// attachToMessages loads the attachments of the given messages in one query.
+func (s *SQLite) attachToMessages(ctx context.Context, msgs []models.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	args, in := inList(nil, ids)
+	atts, err := s.queryAttachments(ctx, `WHERE message_id IN `+in+` ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	groupAttachments(msgs, atts)
+	return nil
+}
+
+// SearchMessages matches every word with LIKE instead of a full-text index.
+// Results come newest first with a zero rank.
+func (s *SQLite) SearchMessages(ctx context.Context, userID int, q models.SearchQuery) ([]models.SearchResult, error) {
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	terms := searchTerms(q.Terms)
+	where := []string{
+		"m.channel_id IN (SELECT channel_id FROM channel_members WHERE user_id = $1)",
+	}
+	for _, t := range terms {
+		where = append(where, `m.content LIKE `+arg(likePattern(t))+` ESCAPE '\'`)
+	}
+	if q.FromUserID != 0 {
+		where = append(where, "m.sender_id = "+arg(q.FromUserID))
+	}
+	if q.FromUsername != "" {
+		where = append(where, "u.username = "+arg(q.FromUsername))
+	}
+	if q.ChannelID != 0 {
+		where = append(where, "m.channel_id = "+arg(q.ChannelID))
+	}
+	if q.Before != nil {
+		where = append(where, "m.created_at < "+arg(q.Before.UTC().Format(sqliteTimeFormat)))
+	}
+	if q.After != nil {
+		where = append(where, "m.created_at >= "+arg(q.After.UTC().Format(sqliteTimeFormat)))
+	}
+	if q.HasLink {
+		where = append(where, `(m.content LIKE '%http://%' OR m.content LIKE '%https://%')`)
+	}
+
+	query := fmt.Sprintf(`
+        SELECT m.id, m.channel_id, m.sender_id, m.content, m.created_at, u.username
+        FROM messages m
+        JOIN users u ON u.id = m.sender_id
+        WHERE %s
+        ORDER BY m.created_at DESC, m.id DESC
+        LIMIT %s OFFSET %s
+    `, strings.Join(where, " AND "), arg(q.Limit+1), arg(q.Offset))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		err := rows.Scan(&r.ID, &r.ChannelID, &r.SenderID, &r.Content, &r.CreatedAt, &r.SenderUsername)
+		if err != nil {
+			return nil, err
+		}
+		r.Snippet = highlight(r.Content, terms)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// LinkAttachments links the sender's unlinked attachments in the channel to a message.
+func (s *SQLite) LinkAttachments(ctx context.Context, messageID, channelID, uploaderID int, attachmentIDs []int) ([]models.Attachment, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+	args, in := inList([]interface{}{messageID, channelID, uploaderID}, attachmentIDs)
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE attachments SET message_id = $1
+        WHERE id IN `+in+` AND channel_id = $2 AND uploader_id = $3 AND message_id IS NULL
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryAttachments(ctx, `WHERE message_id = $1 ORDER BY id`, messageID)
+}
@@ -0,0 +1,103 @@
+// Package store is the persistence layer of the chat server. Handlers and the
+// Hub talk to the Store interface; Postgres (Neon) is the production
+// implementation and SQLite lets the whole server run locally without Neon.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"chat-app/backend/models"
+)
+
+// ErrNotFound is returned when a looked-up row does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// Store covers users, channels, members, messages and attachments.
+type Store interface {
+	// Users
+	CreateUser(ctx context.Context, username string) (int, error)
+	GetUserByUsername(ctx context.Context, username string) (models.User, error)
+	GetUser(ctx context.Context, userID int) (models.User, error)
+	ListUsers(ctx context.Context) ([]models.User, error)
+	SetUserDisabled(ctx context.Context, userID int, disabled bool) error
+	UpdateLastSeen(ctx context.Context, userID int, seenAt time.Time) error
+	FetchLastSeen(ctx context.Context, userIDs []int) (map[int]time.Time, error)
+
+	// Channels and members
+	CreateChannel(ctx context.Context, channelName, channelType string) (int, error)
+	AddChannelMembers(ctx context.Context, channelID int, userIDs []int) error
+	RemoveChannelMember(ctx context.Context, channelID, userID int) error
+	CheckMembership(ctx context.Context, channelID, userID int) (bool, error)
+	GetChannel(ctx context.Context, channelID int) (models.Channel, error)
+	FetchChannelMembers(ctx context.Context, channelID int) ([]models.User, error)
+	FetchUserChannels(ctx context.Context, userID int) ([]models.Channel, error)
+
+	// Messages
+	InsertMessage(ctx context.Context, channelID, senderID int, content string) (models.Message, error)
+	FetchChannelMessages(ctx context.Context, channelID int) ([]models.Message, error)
+	FetchMessagesPage(ctx context.Context, channelID int, page models.MessagePage) ([]models.Message, error)
+	SearchMessages(ctx context.Context, userID int, q models.SearchQuery) ([]models.SearchResult, error)
+	// DeleteMessages removes the messages of a channel (of every channel if
+	// channelID is 0) created before the given time (all of them if it is
+	// zero), along with their attachment rows. It returns how many messages
+	// went and the storage keys of the attachment files, which the caller
+	// should delete from blob storage.
+	DeleteMessages(ctx context.Context, channelID int, before time.Time) (int, []string, error)
+
+	// Attachments
+	InsertAttachment(ctx context.Context, a models.Attachment) (models.Attachment, error)
+	GetAttachment(ctx context.Context, attachmentID int) (models.Attachment, error)
+	LinkAttachments(ctx context.Context, messageID, channelID, uploaderID int, attachmentIDs []int) ([]models.Attachment, error)
+	MarkAttachmentProcessed(ctx context.Context, attachmentID, width, height int, thumbnailKey, previewKey string) (models.Attachment, error)
+
+	// Ping checks that the backing database is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// SQLStore is a Store backed by database/sql. Migrations and pool tuning
+// need the underlying handle and its dialect.
+type SQLStore interface {
+	Store
+	DB() *sql.DB
+	Dialect() string
+}
+
+// endOfTime stands in for "no upper bound" in time comparisons.
+var endOfTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Dialects understood by Open and the migrations package.
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// Open connects to the database for the given driver and verifies connectivity.
+func Open(driver, dsn string) (SQLStore, error) {
+	switch driver {
+	case DialectPostgres, "":
+		return OpenPostgres(dsn)
+	case DialectSQLite:
+		return OpenSQLite(dsn)
+	default:
+		return nil, fmt.Errorf("store: unknown driver %q", driver)
+	}
+}
+
+// AttachmentURL is the download path of an attachment.
+func AttachmentURL(id int) string {
+	return fmt.Sprintf("/api/v1/attachments/%d", id)
+}
+
+// setRenditionURLs fills the thumbnail/preview URLs once processing has run.
+func setRenditionURLs(a *models.Attachment) {
+	a.URL = AttachmentURL(a.ID)
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = a.URL + "/thumbnail?size=thumb"
+		a.PreviewURL = a.URL + "/thumbnail?size=preview"
+	}
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// openTraced opens a database whose queries are recorded as OpenTelemetry
+// spans, children of whatever span the caller's context carries. Without a
+// configured tracer provider the spans are no-ops.
+func openTraced(driverName, dsn string, system attribute.KeyValue) (*sql.DB, error) {
+	return otelsql.Open(driverName, dsn,
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestStoreOperatorMethods covers what chatctl uses, on the stores that run
+// without a server.
+func TestStoreOperatorMethods(t *testing.T) {
+	for name, db := range map[string]store.Store{
+		"memory":          store.NewMemory(),
+		"sqlite":          migratedSQLite(t),
+		"sqlite :memory:": migratedStore(t, store.DialectSQLite, ":memory:"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			alice, _ := db.CreateUser(ctx, "alice")
+			bob, _ := db.CreateUser(ctx, "bob")
+
+			users, err := db.ListUsers(ctx)
+			if err != nil || len(users) != 2 || users[0].Username != "alice" || users[1].Username != "bob" {
+				t.Fatalf("ListUsers = %+v, %v", users, err)
+			}
+			if err := db.SetUserDisabled(ctx, bob, true); err != nil {
+				t.Fatal(err)
+			}
+			first, _ := db.GetUser(ctx, bob)
+			if first.DisabledAt == nil {
+				t.Fatal("bob not disabled")
+			}
+			db.SetUserDisabled(ctx, bob, true)
+			if again, _ := db.GetUser(ctx, bob); again.DisabledAt == nil || !again.DisabledAt.Equal(*first.DisabledAt) {
+				t.Errorf("disabling twice moved disabled_at from %v to %v", first.DisabledAt, again.DisabledAt)
+			}
+			db.SetUserDisabled(ctx, bob, false)
+			if u, _ := db.GetUserByUsername(ctx, "bob"); u.DisabledAt != nil {
+				t.Errorf("bob still disabled: %v", u.DisabledAt)
+			}
+			if err := db.SetUserDisabled(ctx, 999, true); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("disabling a missing user: %v, want ErrNotFound", err)
+			}
+			if _, err := db.GetUser(ctx, 999); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUser(999): %v, want ErrNotFound", err)
+			}
+
+			ops, _ := db.CreateChannel(ctx, "ops", "GROUP")
+			other, _ := db.CreateChannel(ctx, "other", "GROUP")
+			db.AddChannelMembers(ctx, ops, []int{bob, alice})
+			if ch, err := db.GetChannel(ctx, ops); err != nil || ch.ChannelName != "ops" {
+				t.Errorf("GetChannel = %+v, %v", ch, err)
+			}
+			if _, err := db.GetChannel(ctx, 999); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetChannel(999): %v, want ErrNotFound", err)
+			}
+			members, err := db.FetchChannelMembers(ctx, ops)
+			if err != nil || len(members) != 2 || members[0].ID != alice || members[1].ID != bob {
+				t.Errorf("FetchChannelMembers = %+v, %v", members, err)
+			}
+			if err := db.RemoveChannelMember(ctx, ops, bob); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := db.CheckMembership(ctx, ops, bob); ok {
+				t.Error("bob is still a member")
+			}
+			if err := db.RemoveChannelMember(ctx, ops, bob); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("removing a non-member: %v, want ErrNotFound", err)
+			}
+
+			withFile, _ := db.InsertMessage(ctx, ops, alice, "see file")
+			db.InsertMessage(ctx, ops, alice, "plain")
+			db.InsertMessage(ctx, other, alice, "elsewhere")
+			att, err := db.InsertAttachment(ctx, models.Attachment{ChannelID: ops, UploaderID: alice,
+				Filename: "a.png", ContentType: "image/png", SizeBytes: 1, StorageKey: "channels/1/a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.LinkAttachments(ctx, withFile.ID, ops, alice, []int{att.ID})
+			db.MarkAttachmentProcessed(ctx, att.ID, 1, 1, "channels/1/a.thumb", "channels/1/a.preview")
+
+			if n, keys, err := db.DeleteMessages(ctx, 0, time.Now().Add(-time.Hour)); err != nil || n != 0 || len(keys) != 0 {
+				t.Errorf("DeleteMessages before an hour ago = %d, %v, %v; want nothing", n, keys, err)
+			}
+			n, keys, err := db.DeleteMessages(ctx, ops, time.Time{})
+			slices.Sort(keys)
+			if err != nil || n != 2 || strings.Join(keys, " ") != "channels/1/a channels/1/a.preview channels/1/a.thumb" {
+				t.Errorf("DeleteMessages(ops) = %d, %v, %v", n, keys, err)
+			}
+			if _, err := db.GetAttachment(ctx, att.ID); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("attachment survived its message: %v", err)
+			}
+			if msgs, _ := db.FetchChannelMessages(ctx, other); len(msgs) != 1 {
+				t.Errorf("other channel has %d messages, want 1", len(msgs))
+			}
+		})
+	}
+}
+
+func TestDisabledUserCannotConnect(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	ts.dial(t, alice).close()
+	if err := ts.db.SetUserDisabled(context.Background(), alice, true); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + fmt.Sprintf("/ws?user_id=%d", alice)
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial as a disabled user: %v, %v; want a 403", resp, err)
+	}
+
+	ts.db.SetUserDisabled(context.Background(), alice, false)
+	ts.dial(t, alice)
+}
+
+func TestUnknownUserCannotConnect(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + fmt.Sprintf("/ws?user_id=%d", alice+1)
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("dial as an unknown user: %v, %v; want a 404", resp, err)
+	}
+	if n := len(ts.hub.Sessions(alice + 1)); n != 0 {
+		t.Errorf("hub holds %d sessions of the unknown user", n)
+	}
+}
+
+func TestConnectDBKeepsInMemorySQLiteOnOneConnection(t *testing.T) {
+	cfg := defaultConfig().Database
+	cfg.Driver = store.DialectSQLite
+	cfg.SQLitePath = ":memory:"
+	db, err := ConnectDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if n := db.DB().Stats().MaxOpenConnections; n != 1 {
+		t.Fatalf("pool allows %d connections, want 1", n)
+	}
+	if err := runMigrations(db, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent queries would open extra connections if the pool let them.
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := db.CreateUser(context.Background(), fmt.Sprintf("user%d", i))
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("create user: %v", err)
+		}
+	}
+	users, err := db.ListUsers(context.Background())
+	if err != nil || len(users) != cap(errs) {
+		t.Errorf("ListUsers = %d users, %v; want %d", len(users), err, cap(errs))
+	}
+}
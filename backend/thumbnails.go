@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"strings"
+
+	"chat-app/backend/models"
+	"chat-app/backend/storage"
+	"chat-app/backend/store"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	thumbnailSize = 200 // longest edge in pixels
+	previewSize   = 800
+	// maxImagePixels guards against decompression bombs.
+	maxImagePixels = 50_000_000
+	// thumbnailQueueSize is how many images may wait for a worker.
+	thumbnailQueueSize = 100
+)
+
+// ImageProcessor renders thumbnails and previews for image attachments in
+// the background and tells the channel when they are ready.
+type ImageProcessor struct {
+	db    store.Store
+	files storage.Storage
+	hub   *Hub
+	jobs  chan models.Attachment
+}
+
+// NewImageProcessor starts the given number of workers.
+func NewImageProcessor(db store.Store, files storage.Storage, hub *Hub, workers int) *ImageProcessor {
+	p := &ImageProcessor{
+		db:    db,
+		files: files,
+		hub:   hub,
+		jobs:  make(chan models.Attachment, thumbnailQueueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+// Enqueue schedules an attachment for processing. Non-images are ignored and
+// a full queue drops the job; the original file stays downloadable either way.
+func (p *ImageProcessor) Enqueue(att models.Attachment) {
+	if !strings.HasPrefix(att.ContentType, "image/") {
+		return
+	}
+	select {
+	case p.jobs <- att:
+	default:
+		slog.Warn("thumbnail queue full, skipping", "attachment_id", att.ID)
+	}
+}
+
+func (p *ImageProcessor) run() {
+	for att := range p.jobs {
+		ctx, span := tracer.Start(context.Background(), "thumbnail.process",
+			trace.WithAttributes(attribute.Int("chat.attachment_id", att.ID)))
+		if err := p.process(ctx, att); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "thumbnail failed")
+			slog.Error("thumbnail failed", "attachment_id", att.ID, "err", err)
+		}
+		span.End()
+	}
+}
+
+func (p *ImageProcessor) process(ctx context.Context, att models.Attachment) error {
+	f, err := p.files.Open(ctx, att.StorageKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := checkImageSize(data); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	att.Width, att.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	att.ThumbnailKey = att.StorageKey + "_thumb"
+	att.PreviewKey = att.StorageKey + "_preview"
+	for key, size := range map[string]int{att.ThumbnailKey: thumbnailSize, att.PreviewKey: previewSize} {
+		var buf bytes.Buffer
+		if err := encodeThumbnail(&buf, resizeToFit(img, size), att.ContentType); err != nil {
+			return err
+		}
+		if _, err := p.files.Put(ctx, key, &buf); err != nil {
+			return err
+		}
+	}
+
+	att, err = p.db.MarkAttachmentProcessed(ctx, att.ID, att.Width, att.Height, att.ThumbnailKey, att.PreviewKey)
+	if err != nil {
+		return err
+	}
+
+	event := models.WSAttachmentProcessed{
+		Type:       "attachment_processed",
+		ChannelID:  att.ChannelID,
+		Attachment: att,
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	p.hub.Broadcast(BroadcastMessage{ChannelID: att.ChannelID, Data: encoded, Trace: trace.SpanContextFromContext(ctx)})
+	return nil
+}
+
+// checkImageSize rejects images whose header declares an absurd pixel count.
+func checkImageSize(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// resizeToFit scales img down so its longest edge is at most size. Smaller
+// images are returned unchanged.
+func resizeToFit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// thumbnailContentType is JPEG for JPEG sources and PNG for everything else,
+// so transparency survives.
+func thumbnailContentType(sourceType string) string {
+	if sourceType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+func encodeThumbnail(w io.Writer, img image.Image, sourceType string) error {
+	if thumbnailContentType(sourceType) == "image/jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(w, img)
+}
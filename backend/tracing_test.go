@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanRecorder installs a recording tracer provider once per test binary;
+// the global provider can only be replaced once.
+var spanRecorder = sync.OnceValue(func() *tracetest.SpanRecorder {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return rec
+})
+
+func TestMessageTracedFromFrameToBroadcast(t *testing.T) {
+	rec := spanRecorder()
+	// Only look at spans from this run.
+	before := len(rec.Ended())
+	ended := func() []sdktrace.ReadOnlySpan { return rec.Ended()[before:] }
+
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channelID := ts.createChannel(t, "group", "general", alice, bob)
+	aliceWS := ts.dial(t, alice)
+	bobWS := ts.dial(t, bob)
+
+	aliceWS.sendMessage(t, channelID, "traced")
+	bobWS.nextMessage(t)
+
+	find := func(name string) sdktrace.ReadOnlySpan {
+		for _, s := range ended() {
+			if s.Name() == name {
+				return s
+			}
+		}
+		return nil
+	}
+	waitFor(t, "broadcast span ended", func() bool { return find("hub.broadcast") != nil })
+
+	frame, fanout := find("ws.frame"), find("hub.broadcast")
+	if frame == nil {
+		t.Fatal("no ws.frame span")
+	}
+	if fanout.Parent().SpanID() != frame.SpanContext().SpanID() {
+		t.Errorf("hub.broadcast parent = %s, want the ws.frame span %s",
+			fanout.Parent().SpanID(), frame.SpanContext().SpanID())
+	}
+
+	links := frame.Links()
+	if len(links) != 1 {
+		t.Fatalf("ws.frame has %d links, want 1 to the /ws upgrade", len(links))
+	}
+	linked := false
+	for _, s := range ended() {
+		if s.Name() == "/ws" && s.SpanContext().SpanID() == links[0].SpanContext.SpanID() {
+			linked = true
+		}
+	}
+	if !linked {
+		t.Error("ws.frame does not link to a /ws upgrade span")
+	}
+}
@@ -0,0 +1,259 @@
+// Package validate checks request payloads against rules declared in struct
+// tags, so handlers reject bad input with field errors before touching the
+// database.
+//
+// Rules go in a `validate` tag, separated by commas:
+//
+//	Username string `json:"username" validate:"required,min=1,max=32,charset=name"`
+//	UserIDs  []int  `json:"user_ids" validate:"required,max=100,dive,required,min=1"`
+//
+//	required     the value is not the zero value (an empty string, 0, an empty slice)
+//	min=N max=N  the rune count of a string, the value of a number or the length of a slice
+//	oneof=A B C  a string is one of the space-separated words
+//	charset=X    every rune of a string is in the named charset, see Charsets
+//	dive         the rules after it apply to each element of a slice
+//
+// Rules other than required pass on the zero value, so optional fields only
+// need required left out. Fields are reported under their JSON names.
+// Rules that involve several fields go in a Check method; see Checker.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError is one violated rule.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists every field that failed, at most one error per field.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + " " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Map returns the errors keyed by field.
+func (e Errors) Map() map[string]string {
+	m := make(map[string]string, len(e))
+	for _, fe := range e {
+		m[fe.Field] = fe.Message
+	}
+	return m
+}
+
+// Add records a violation unless field already has one.
+func (e *Errors) Add(field, message string) {
+	for _, fe := range *e {
+		if fe.Field == field {
+			return
+		}
+	}
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Checker is implemented by payloads with rules that span several fields.
+// Check runs after the tag rules and only when they passed.
+type Checker interface {
+	Check() Errors
+}
+
+// Charsets are the character sets available to the charset rule.
+var Charsets = map[string]func(rune) bool{
+	// name: letters, digits and _ . - as in usernames
+	"name": func(r rune) bool {
+		return r < utf8.RuneSelf && (r == '_' || r == '.' || r == '-' ||
+			'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	},
+	// text: anything printable, plus newlines and tabs
+	"text": func(r rune) bool {
+		return r == '\n' || r == '\t' || unicode.IsPrint(r)
+	},
+	// line: anything printable on a single line
+	"line": unicode.IsPrint,
+}
+
+// charsetDescriptions name the charsets in error messages.
+var charsetDescriptions = map[string]string{
+	"name": "letters, digits, _ . and -",
+	"text": "printable characters",
+	"line": "printable characters on one line",
+}
+
+// CharsetDescription describes a charset for people, as error messages do.
+func CharsetDescription(name string) string { return charsetDescriptions[name] }
+
+// Struct checks v, a struct or a pointer to one, and returns nil or Errors.
+func Struct(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	var errs Errors
+	for _, f := range fieldsOf(rv.Type()) {
+		if msg := check(rv.Field(f.index), f.rules); msg != "" {
+			errs.Add(f.name, msg)
+		}
+	}
+	if len(errs) == 0 {
+		if c, ok := v.(Checker); ok {
+			errs = c.Check()
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// rule is one parsed tag entry.
+type rule struct {
+	name string
+	arg  string
+	n    int // numeric argument of min and max
+}
+
+type field struct {
+	index int
+	name  string
+	rules []rule
+}
+
+// fieldCache holds the parsed rules per struct type.
+var fieldCache sync.Map // reflect.Type -> []field
+
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("validate")
+		if !ok || !sf.IsExported() {
+			continue
+		}
+		name := sf.Name
+		if j, _, _ := strings.Cut(sf.Tag.Get("json"), ","); j != "" && j != "-" {
+			name = j
+		}
+		fields = append(fields, field{index: i, name: name, rules: parseRules(t, sf.Name, tag)})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// parseRules panics on a malformed tag: that is a programming error and
+// shows up the first time the type is validated.
+func parseRules(t reflect.Type, fieldName, tag string) []rule {
+	var rules []rule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "required", "dive":
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: %s.%s: bad %s argument %q", t, fieldName, name, arg))
+			}
+			r.n = n
+		case "oneof":
+			if arg == "" {
+				panic(fmt.Sprintf("validate: %s.%s: oneof needs values", t, fieldName))
+			}
+		case "charset":
+			if Charsets[arg] == nil {
+				panic(fmt.Sprintf("validate: %s.%s: unknown charset %q", t, fieldName, arg))
+			}
+		default:
+			panic(fmt.Sprintf("validate: %s.%s: unknown rule %q", t, fieldName, name))
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// check applies rules to v and returns the first violation's message.
+func check(v reflect.Value, rules []rule) string {
+	for i, r := range rules {
+		if r.name == "dive" {
+			if v.Kind() != reflect.Slice {
+				return ""
+			}
+			for j := 0; j < v.Len(); j++ {
+				if msg := check(v.Index(j), rules[i+1:]); msg != "" {
+					return fmt.Sprintf("item %d %s", j, msg)
+				}
+			}
+			return ""
+		}
+		if r.name == "required" {
+			if v.IsZero() || v.Kind() == reflect.Slice && v.Len() == 0 {
+				return "is required"
+			}
+			continue
+		}
+		if v.IsZero() {
+			continue
+		}
+		if msg := checkRule(v, r); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(v reflect.Value, r rule) string {
+	switch r.name {
+	case "min", "max":
+		var n int64
+		unit := ""
+		switch v.Kind() {
+		case reflect.String:
+			n, unit = int64(utf8.RuneCountInString(v.String())), " characters"
+		case reflect.Slice:
+			n, unit = int64(v.Len()), " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = v.Int()
+		default:
+			return ""
+		}
+		if r.name == "min" && n < int64(r.n) {
+			return fmt.Sprintf("must be at least %d%s", r.n, unit)
+		}
+		if r.name == "max" && n > int64(r.n) {
+			return fmt.Sprintf("must be at most %d%s", r.n, unit)
+		}
+	case "oneof":
+		if v.Kind() != reflect.String {
+			return ""
+		}
+		for _, allowed := range strings.Fields(r.arg) {
+			if v.String() == allowed {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(strings.Fields(r.arg), ", ")
+	case "charset":
+		if v.Kind() != reflect.String {
+			return ""
+		}
+		in := Charsets[r.arg]
+		for _, c := range v.String() {
+			if !in(c) {
+				return "may only contain " + charsetDescriptions[r.arg]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chat-app/backend/models"
+	"chat-app/backend/store"
+)
+
+// noWrites fails the test when a write reaches the store.
+type noWrites struct {
+	store.Store
+	t *testing.T
+}
+
+func (s noWrites) CreateUser(ctx context.Context, username string) (int, error) {
+	s.t.Errorf("CreateUser(%q) called for an invalid request", username)
+	return 0, nil
+}
+
+func (s noWrites) CreateChannel(ctx context.Context, name, channelType string) (int, error) {
+	s.t.Errorf("CreateChannel(%q, %q) called for an invalid request", name, channelType)
+	return 0, nil
+}
+
+func (s noWrites) AddChannelMembers(ctx context.Context, channelID int, userIDs []int) error {
+	s.t.Errorf("AddChannelMembers(%d, %v) called for an invalid request", channelID, userIDs)
+	return nil
+}
+
+// postError posts a raw JSON body that must be refused.
+func (ts *testServer) postError(t *testing.T, path, body string, wantStatus int) models.APIError {
+	t.Helper()
+	resp, err := ts.Client().Post(ts.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("POST %s %.40s: %d, want %d", path, body, resp.StatusCode, wantStatus)
+	}
+	var e models.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	return e.Error
+}
+
+func TestRequestValidationRejectsBeforeStore(t *testing.T) {
+	ts := newTestServerWithStore(t, noWrites{Store: store.NewMemory(), t: t})
+
+	tests := []struct {
+		path, body, field, want string
+	}{
+		{"/api/v1/users", `{"username":""}`, "username", "is required"},
+		{"/api/v1/users", `{"username":"` + strings.Repeat("a", 33) + `"}`, "username", "must be at most 32 characters"},
+		{"/api/v1/users", `{"username":"alice smith"}`, "username", "may only contain letters, digits, _ . and -"},
+		{"/api/v1/channels", `{"channel_type":"PUBLIC","user_ids":[1,2]}`, "channel_type", "must be one of DIRECT, GROUP"},
+		{"/api/v1/channels", `{"channel_type":"group","user_ids":[1,2]}`, "channel_name", "is required"},
+		{"/api/v1/channels", `{"channel_type":"DIRECT","user_ids":[1,0]}`, "user_ids", "item 1 is required"},
+		{"/api/v1/channels", `{"channel_type":"DIRECT","user_ids":[1,2,3]}`, "user_ids", "must list exactly 2 users"},
+		{"/api/v1/channels/1/members", `{"user_id":0}`, "user_id", "is required"},
+		{"/api/v1/channels/1/members", `{"user_id":-4}`, "user_id", "must be at least 1"},
+	}
+	for _, tt := range tests {
+		e := ts.postError(t, tt.path, tt.body, http.StatusBadRequest)
+		if e.Code != models.ErrCodeValidationFailed || e.Details[tt.field] != tt.want {
+			t.Errorf("POST %s %s: %+v, want validation_failed with %s %q", tt.path, tt.body, e, tt.field, tt.want)
+		}
+	}
+
+	big := fmt.Sprintf(`{"username":"%s"}`, strings.Repeat("a", maxJSONBodyBytes))
+	if e := ts.postError(t, "/api/v1/users", big, http.StatusRequestEntityTooLarge); e.Code != models.ErrCodePayloadTooLarge {
+		t.Errorf("oversized body: code %q, want payload_too_large", e.Code)
+	}
+	if e := ts.postError(t, "/api/v1/users", `{"username":`, http.StatusBadRequest); e.Code != models.ErrCodeBadRequest {
+		t.Errorf("broken JSON: code %q, want bad_request", e.Code)
+	}
+}
+
+func TestInvalidFramesAreAnswered(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser(t, "alice")
+	bob := ts.createUser(t, "bob")
+	channel := ts.createChannel(t, "DIRECT", "", alice, bob)
+	aliceWS := ts.dial(t, alice)
+
+	tests := []struct {
+		frame        models.WSIncoming
+		field, error string
+	}{
+		{models.WSIncoming{Type: "shout", ChannelID: channel}, "type", "must be one of subscribe, unsubscribe, message, set_status, typing"},
+		{models.WSIncoming{Type: "message", ChannelID: channel}, "text", "is required without attachments"},
+		{models.WSIncoming{Type: "message", Text: "hi"}, "channelID", "is required"},
+		{models.WSIncoming{Type: "message", ChannelID: channel, Text: strings.Repeat("x", 4001)}, "text", "must be at most 4000 characters"},
+		{models.WSIncoming{Type: "message", ChannelID: channel, Text: "bell\a"}, "text", "may only contain printable characters"},
+		{models.WSIncoming{Type: "set_status", Status: "busy"}, "status", "must be one of online, away, dnd"},
+	}
+	for _, tt := range tests {
+		aliceWS.send(t, tt.frame)
+		var e models.WSError
+		aliceWS.next(t, "error", &e)
+		if e.Code != "invalid_frame" || e.Fields[tt.field] != tt.error {
+			t.Errorf("frame %+v: error %+v, want invalid_frame with %s %q", tt.frame, e, tt.field, tt.error)
+		}
+	}
+
+	// The connection still works, and nothing invalid was stored.
+	aliceWS.sendMessage(t, channel, "hello")
+	if msg := aliceWS.nextMessage(t); msg.Content != "hello" {
+		t.Errorf("message after invalid frames = %q, want hello", msg.Content)
+	}
+	msgs, err := ts.db.FetchChannelMessages(context.Background(), channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Errorf("stored messages = %d, want 1", len(msgs))
+	}
+}
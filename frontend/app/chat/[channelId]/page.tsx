@@ -32,7 +32,7 @@ export default function ChatPage() {
         {(!messages || messages.length === 0) ? (
           <div>No messages yet</div>
         ) : (
-          [...messages, ...(allMessages || []).filter((m: Message) => m.channelID === Number(channelId))].map((msg, idx) => (
+          [...messages, ...(allMessages || []).filter((m: Message) => m.type === "message" && m.channelID === Number(channelId))].map((msg, idx) => (
             <div key={idx} className="mb-1">
               <span className="font-semibold">User {msg.senderID}:</span> {msg.content}
             </div>
@@ -1,7 +1,7 @@
 "use client";
 import { createContext, useContext, useEffect, useRef, useState } from "react";
 import { fetchChannels } from "@/lib/api";
-import { Message, Channel } from "@/types/websocket";
+import { Message, Channel, ServerShutdown, WSError } from "@/types/websocket";
 
 interface WebSocketContextType {
   messages: Message[];
@@ -28,28 +28,57 @@ export function WebSocketProvider({ children }: { children: React.ReactNode }) {
     const userId = Number(localStorage.getItem("user_id"));
     if (!userId) return;
 
-    const ws = new WebSocket(`ws://localhost:8080/ws?user_id=${userId}`);
-    wsRef.current = ws;
+    let closedByUs = false;
+    let reconnectTimer: ReturnType<typeof setTimeout> | undefined;
 
-    ws.onopen = async () => {
-      setConnected(true);
-      try {
-        const channels = await fetchChannels(userId) || [];
-        channels.forEach((ch: Channel) => {
-          ws.send(JSON.stringify({ type: "subscribe", channelID: ch.id }));
-        });
-      } catch (error) {
-        console.error("Error fetching channels:", error);
-      }
-    };
+    const connect = () => {
+      const ws = new WebSocket(`ws://localhost:8080/ws?user_id=${userId}`);
+      wsRef.current = ws;
+
+      ws.onopen = async () => {
+        setConnected(true);
+        try {
+          const channels = await fetchChannels(userId) || [];
+          channels.forEach((ch: Channel) => {
+            ws.send(JSON.stringify({ type: "subscribe", channelID: ch.id }));
+          });
+        } catch (error) {
+          console.error("Error fetching channels:", error);
+        }
+      };
 
-    ws.onmessage = (event) => {
-      const msg = JSON.parse(event.data);
-      setMessages((prev) => [...prev, msg]);
+      ws.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        if (msg.type === "server_shutdown") {
+          // The server is restarting: come back after the hinted delay,
+          // spread out so every tab doesn't reconnect at once.
+          const notice = msg as ServerShutdown;
+          const delay = notice.reconnectAfterMs + Math.random() * notice.jitterMs;
+          reconnectTimer = setTimeout(() => {
+            if (!closedByUs) connect();
+          }, delay);
+          return;
+        }
+        if (msg.type === "error") {
+          const err = msg as WSError;
+          console.warn(`Server refused ${err.frame} frame: ${err.message}`);
+          return;
+        }
+        if (msg.type === "typing") return; // no typing indicator in the UI yet
+        setMessages((prev) => [...prev, msg]);
+      };
+
+      ws.onclose = () => {
+        setConnected(false);
+      };
     };
 
+    connect();
+
     return () => {
-      ws.close();
+      closedByUs = true;
+      clearTimeout(reconnectTimer);
+      wsRef.current?.close();
     };
   }, []);
 
@@ -4,19 +4,19 @@ import axios from "axios";
 const API_URL = process.env.NEXT_PUBLIC_API_URL || "http://localhost:8080";
 
 export async function createUserIfNotExists(username: string) {
-  const res = await axios.get(`${API_URL}/check_user?username=${username}`);
+  const res = await axios.get(`${API_URL}/api/v1/users?username=${encodeURIComponent(username)}`);
   if (res.data?.exists) return res.data;
 
-  const createRes = await axios.post(`${API_URL}/users`, { username });
+  const createRes = await axios.post(`${API_URL}/api/v1/users`, { username });
   return createRes.data;
 }
 
 export async function fetchChannels(userId: number) {
-  const res = await axios.get(`${API_URL}/my_channels?user_id=${userId}`);
+  const res = await axios.get(`${API_URL}/api/v1/users/${userId}/channels`);
   return res.data;
 }
 export async function fetchMessages(channelId: number) {
-  const res = await axios.get(`${API_URL}/fetch_messages?channel_id=${channelId}`);
+  const res = await axios.get(`${API_URL}/api/v1/channels/${channelId}/messages`);
   return res.data;
 }
 
@@ -25,6 +25,6 @@ export async function createChannel(payload: {
   channel_name: string;
   user_ids: number[];
 }) {
-  const res = await axios.post(`${API_URL}/create_channel`, payload);
+  const res = await axios.post(`${API_URL}/api/v1/channels`, payload);
   return res.data;
 }
\ No newline at end of file
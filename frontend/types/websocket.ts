@@ -1,9 +1,49 @@
+export interface Attachment {
+  id: number;
+  channel_id: number;
+  message_id?: number;
+  uploader_id: number;
+  filename: string;
+  content_type: string;
+  size_bytes: number;
+  url: string;
+  created_at: string;
+  width?: number;
+  height?: number;
+  thumbnail_url?: string;
+  preview_url?: string;
+  processed_at?: string;
+}
+
 export interface Message {
   type: string;
+  id?: number;
   channelID: number;
   senderID: number;
   content: string;
   created_at: string;
+  attachments?: Attachment[];
+}
+
+export interface ServerShutdown {
+  type: "server_shutdown";
+  reconnectAfterMs: number;
+  jitterMs: number;
+}
+
+export interface Typing {
+  type: "typing";
+  channelID: number;
+  userID: number;
+}
+
+export interface WSError {
+  type: "error";
+  code: "rate_limited" | "muted" | "flood_disconnect" | "invalid_frame";
+  message: string;
+  frame?: string;
+  retryAfterMs?: number;
+  fields?: Record<string, string>;
 }
 
 export interface Channel {